@@ -7,16 +7,21 @@ package bam
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/bgzf/index"
+	"github.com/biogo/hts/csi"
 	"github.com/biogo/hts/internal"
 	"github.com/biogo/hts/sam"
 
@@ -120,6 +125,500 @@ func (s *S) TestRead(c *check.C) {
 	}
 }
 
+func (s *S) TestFlagStats(c *check.C) {
+	br, err := NewReader(bytes.NewReader(conceptualBAMdata), *conc)
+	c.Assert(err, check.Equals, nil)
+	stats, err := br.FlagStats()
+	c.Check(err, check.Equals, nil)
+	c.Check(stats, check.Equals, FlagStats{Total: 3, Mapped: 3})
+	c.Check(br.Close(), check.Equals, nil)
+
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	var want FlagStats
+	for {
+		r, err := br.Read()
+		if err != nil {
+			c.Assert(err, check.Equals, io.EOF)
+			break
+		}
+		want.add(r.Flags)
+	}
+	c.Check(br.Close(), check.Equals, nil)
+	c.Check(want.Total, check.Equals, uint64(1000))
+	c.Check(want.Paired, check.Not(check.Equals), uint64(0))
+
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	stats, err = br.FlagStats()
+	c.Check(err, check.Equals, nil)
+	c.Check(stats, check.Equals, want)
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestPrimaryOnly(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	var want []string
+	for i, flags := range []sam.Flags{
+		0,
+		sam.Secondary,
+		sam.Paired | sam.Read1,
+		sam.Supplementary,
+		sam.Secondary | sam.Supplementary,
+		sam.Reverse | sam.Duplicate,
+		sam.Unmapped,
+	} {
+		name := fmt.Sprintf("r%d", i)
+		r, err := sam.NewRecord(name, ref, nil, i*10, -1, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		r.Flags = flags
+		c.Assert(bw.Write(r), check.Equals, nil)
+		if flags&(sam.Secondary|sam.Supplementary) == 0 {
+			want = append(want, name)
+		}
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	it := br.PrimaryOnly()
+	var got []string
+	for it.Next() {
+		r := it.Record()
+		c.Check(r.Flags&(sam.Secondary|sam.Supplementary), check.Equals, sam.Flags(0))
+		c.Check(r.Seq.Expand(), check.DeepEquals, []byte("ACGT"))
+		got = append(got, r.Name)
+	}
+	c.Check(it.Close(), check.Equals, nil)
+	c.Check(got, check.DeepEquals, want)
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestStoredBin(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 1<<20, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	var recs []*sam.Record
+	for _, pos := range []int{100, 20000} {
+		r, err := sam.NewRecord(fmt.Sprintf("r%d", pos), ref, nil, pos, -1, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		recs = append(recs, r)
+	}
+
+	// The second record is written with the bin of the first.
+	const binOffset = 4 + 4 + 4 + 1 + 1 // block_size, refID, pos, l_read_name and mapq.
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(bw.Write(recs[0]), check.Equals, nil)
+	var raw bytes.Buffer
+	c.Assert(recs[1].EncodeBinary(&raw, 0, -1), check.Equals, nil)
+	p := raw.Bytes()
+	binary.LittleEndian.PutUint16(p[binOffset:], uint16(recs[0].Bin()))
+	c.Assert(bw.WriteRaw(p), check.Equals, nil)
+	c.Assert(bw.Close(), check.Equals, nil)
+	c.Assert(recs[0].Bin(), check.Not(check.Equals), recs[1].Bin())
+
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	for _, want := range recs {
+		r, err := br.Read()
+		c.Assert(err, check.Equals, nil)
+		c.Check(r.Name, check.Equals, want.Name)
+		c.Check(r.Bin(), check.Equals, want.Bin())
+		c.Check(int(br.LastStoredBin()), check.Equals, recs[0].Bin())
+	}
+	c.Check(br.Close(), check.Equals, nil)
+
+	br, err = NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	br.SetValidateBins(true)
+	_, err = br.Read()
+	c.Check(err, check.Equals, nil)
+	_, err = br.Read()
+	c.Check(err, check.ErrorMatches, fmt.Sprintf(`bam: stored bin %d does not match computed bin %d for "r20000"`, recs[0].Bin(), recs[1].Bin()))
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Well-formed files pass validation.
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	br.SetValidateBins(true)
+	var n int
+	for {
+		_, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		n++
+	}
+	c.Check(n, check.Equals, 1000)
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestScanTolerant(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	// Each record is written to its own BGZF block.
+	var buf bytes.Buffer
+	bg, err := bgzf.NewWriterConfig(&buf, bgzf.WriterConfig{Level: gzip.DefaultCompression, Concurrency: 1, FlushWrites: true})
+	c.Assert(err, check.Equals, nil)
+	bw, err := NewWriter(bg, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	var want []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("r%d", i)
+		r, err := sam.NewRecord(name, ref, nil, i*10, -1, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		if i != 2 {
+			c.Assert(bw.Write(r), check.Equals, nil)
+			want = append(want, name)
+			continue
+		}
+		// Inject a record with an invalid reference ID.
+		var raw bytes.Buffer
+		c.Assert(r.EncodeBinary(&raw, 5, -1), check.Equals, nil)
+		c.Assert(bw.WriteRaw(raw.Bytes()), check.Equals, nil)
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	var got []string
+	good, bad, err := br.ScanTolerant(func(r *sam.Record) { got = append(got, r.Name) })
+	c.Check(err, check.Equals, nil)
+	c.Check(good, check.Equals, 4)
+	c.Check(bad, check.Equals, 1)
+	c.Check(got, check.DeepEquals, want)
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Without the ability to seek, the scan cannot recover.
+	br, err = NewReader(struct{ io.Reader }{bytes.NewReader(buf.Bytes())}, *conc)
+	c.Assert(err, check.Equals, nil)
+	good, bad, err = br.ScanTolerant(func(*sam.Record) {})
+	c.Check(err, check.Equals, bgzf.ErrNotASeeker)
+	c.Check(good, check.Equals, 2)
+	c.Check(bad, check.Equals, 1)
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestReadLongCigar(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	want := sam.Cigar{
+		sam.NewCigarOp(sam.CigarMatch, 5),
+		sam.NewCigarOp(sam.CigarDeletion, 2),
+		sam.NewCigarOp(sam.CigarMatch, 5),
+	}
+	ops := make([]uint32, len(want))
+	for i, co := range want {
+		ops[i] = uint32(co)
+	}
+	cg, err := sam.NewAux(sam.NewTag("CG"), ops)
+	c.Assert(err, check.Equals, nil)
+	nm, err := sam.NewAux(sam.NewTag("NM"), 2)
+	c.Assert(err, check.Equals, nil)
+
+	placeholder := sam.Cigar{
+		sam.NewCigarOp(sam.CigarSoftClipped, 10),
+		sam.NewCigarOp(sam.CigarSkipped, 12),
+	}
+	rec, err := sam.NewRecord("long", ref, nil, 10, -1, 0, 60, placeholder, []byte("ACGTACGTAC"), nil, []sam.Aux{cg, nm})
+	c.Assert(err, check.Equals, nil)
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(bw.Write(rec), check.Equals, nil)
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(&buf, *conc)
+	c.Assert(err, check.Equals, nil)
+	got, err := br.Read()
+	c.Assert(err, check.Equals, nil)
+	c.Check(got.Cigar, check.DeepEquals, want)
+	c.Check(got.AuxFields, check.DeepEquals, sam.AuxFields{nm})
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestWriteLongCigar(c *check.C) {
+	const pairs = 35000
+	ref, err := sam.NewReference("chr1", "", "", 2*pairs, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	cigar := make(sam.Cigar, 0, 2*pairs)
+	for i := 0; i < pairs; i++ {
+		cigar = append(cigar,
+			sam.NewCigarOp(sam.CigarMatch, 1),
+			sam.NewCigarOp(sam.CigarInsertion, 1),
+		)
+	}
+	c.Assert(len(cigar) > 0xffff, check.Equals, true)
+	seq := bytes.Repeat([]byte("A"), 2*pairs)
+	nm, err := sam.NewAux(sam.NewTag("NM"), pairs)
+	c.Assert(err, check.Equals, nil)
+	rec, err := sam.NewRecord("long", ref, nil, 0, -1, 0, 60, cigar, seq, nil, []sam.Aux{nm})
+	c.Assert(err, check.Equals, nil)
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(bw.Write(rec), check.Equals, nil)
+	c.Assert(bw.Close(), check.Equals, nil)
+	data := buf.Bytes()
+
+	br, err := NewReader(bytes.NewReader(data), *conc)
+	c.Assert(err, check.Equals, nil)
+	got, err := br.Read()
+	c.Assert(err, check.Equals, nil)
+	c.Check(got.Cigar, check.DeepEquals, cigar)
+	c.Check(got.AuxFields, check.DeepEquals, sam.AuxFields{nm})
+	c.Check(got.Seq.Expand(), check.DeepEquals, seq)
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Without auxiliary data the placeholder CIGAR is visible.
+	br, err = NewReader(bytes.NewReader(data), *conc)
+	c.Assert(err, check.Equals, nil)
+	br.Omit(AuxTags)
+	got, err = br.Read()
+	c.Assert(err, check.Equals, nil)
+	c.Check(got.Cigar, check.DeepEquals, sam.Cigar{
+		sam.NewCigarOp(sam.CigarSoftClipped, 2*pairs),
+		sam.NewCigarOp(sam.CigarSkipped, pairs),
+	})
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+func (s *S) TestRawRoundTrip(c *check.C) {
+	br, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, br.Header().Clone(), *conc)
+	c.Assert(err, check.Equals, nil)
+	var n int
+	for {
+		p, err := br.ReadRaw()
+		if err != nil {
+			c.Assert(err, check.Equals, io.EOF)
+			break
+		}
+		c.Assert(bw.WriteRaw(p), check.Equals, nil)
+		n++
+	}
+	c.Check(n, check.Equals, 1000)
+	c.Assert(bw.Close(), check.Equals, nil)
+	c.Check(br.Close(), check.Equals, nil)
+
+	want, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	got, err := NewReader(&buf, *conc)
+	c.Assert(err, check.Equals, nil)
+	for {
+		w, werr := want.Read()
+		g, gerr := got.Read()
+		c.Assert(gerr, check.Equals, werr)
+		if werr != nil {
+			c.Assert(werr, check.Equals, io.EOF)
+			break
+		}
+		g.Ref, g.MateRef = w.Ref, w.MateRef // Different headers.
+		c.Check(g, check.DeepEquals, w)
+	}
+	c.Check(want.Close(), check.Equals, nil)
+	c.Check(got.Close(), check.Equals, nil)
+
+	bw, err = NewWriter(io.Discard, br.Header().Clone(), *conc)
+	c.Assert(err, check.Equals, nil)
+	p := make([]byte, lenFieldSize+bamFixedRemainder+2)
+	binary.LittleEndian.PutUint32(p, uint32(bamFixedRemainder))
+	c.Check(bw.WriteRaw(p), check.ErrorMatches, "bam: raw record block size mismatch")
+	c.Check(bw.WriteRaw(p[:8]), check.ErrorMatches, "bam: raw record too short")
+	c.Check(bw.Close(), check.Equals, nil)
+}
+
+func (s *S) TestRecordMarshalBinary(c *check.C) {
+	raw, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer raw.Close()
+	br, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+
+	var n int
+	for {
+		want, err := raw.ReadRaw()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		r, err := br.Read()
+		c.Assert(err, check.Equals, nil)
+
+		got, err := r.MarshalBinary()
+		c.Assert(err, check.Equals, nil)
+
+		// Some records in the test data have bin values
+		// that differ from those calculated by Record.Bin,
+		// so compare bin separately.
+		const binOffset = 14
+		c.Check(int(binary.LittleEndian.Uint16(got[binOffset:])), check.Equals, r.Bin())
+		gotBin := append([]byte(nil), got...)
+		copy(gotBin[binOffset:binOffset+2], want[binOffset:binOffset+2])
+		c.Check(gotBin, check.DeepEquals, want, check.Commentf("record %d: %s", n, r.Name))
+
+		// Round-trip the encoding through the Reader.
+		var buf bytes.Buffer
+		bw, err := NewWriter(&buf, br.Header(), 1)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(bw.WriteRaw(got), check.Equals, nil)
+		c.Assert(bw.Close(), check.Equals, nil)
+		rr, err := NewReader(&buf, 1)
+		c.Assert(err, check.Equals, nil)
+		back, err := rr.Read()
+		c.Assert(err, check.Equals, nil)
+		c.Check(back, check.DeepEquals, r)
+		c.Check(rr.Close(), check.Equals, nil)
+		n++
+	}
+	c.Check(n, check.Not(check.Equals), 0)
+
+	err = (&sam.Record{}).EncodeBinary(io.Discard, -1, -1)
+	c.Check(err, check.ErrorMatches, "sam: name absent or too long")
+
+	// The Writer reports invalid records with its own errors.
+	bw, err := NewWriter(io.Discard, br.Header(), 1)
+	c.Assert(err, check.Equals, nil)
+	c.Check(bw.Write(&sam.Record{}), check.ErrorMatches, "bam: name absent or too long")
+	c.Check(bw.Write(&sam.Record{Name: "r", Seq: sam.NewSeq([]byte("ACGT")), Qual: []byte{30}}),
+		check.ErrorMatches, "bam: sequence/quality length mismatch")
+	c.Check(bw.Close(), check.Equals, nil)
+}
+
+func (s *S) TestUnaligned(c *check.C) {
+	rg, err := sam.NewReadGroup("group", "", "", "", "", "PACBIO", "", "sample", "", "", time.Time{}, 0)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeaderFull(nil, nil, []*sam.ReadGroup{rg}, nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.IsUnaligned(), check.Equals, true)
+
+	rgAux, err := sam.NewAux(sam.NewTag("RG"), "group")
+	c.Assert(err, check.Equals, nil)
+	var want []*sam.Record
+	for i, seq := range []string{"ACGT", "GGCCTTAA", "T"} {
+		r, err := sam.NewRecord(fmt.Sprintf("read%d", i), nil, nil, -1, -1, 0, 0, nil, []byte(seq), nil, []sam.Aux{rgAux})
+		c.Assert(err, check.Equals, nil)
+		r.Flags = sam.Unmapped
+		want = append(want, r)
+	}
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for _, r := range want {
+		c.Assert(bw.Write(r), check.Equals, nil)
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(&buf, *conc)
+	c.Assert(err, check.Equals, nil)
+	c.Check(br.Header().IsUnaligned(), check.Equals, true)
+	it, err := NewIterator(br, nil)
+	c.Assert(err, check.Equals, nil)
+	var got []*sam.Record
+	for it.Next() {
+		got = append(got, it.Record())
+	}
+	c.Check(it.Close(), check.Equals, nil)
+	c.Assert(len(got), check.Equals, len(want))
+	for i, r := range got {
+		c.Check(r.Name, check.Equals, want[i].Name)
+		c.Check(r.Ref, check.IsNil)
+		c.Check(r.Pos, check.Equals, -1)
+		c.Check(r.Seq.Expand(), check.DeepEquals, want[i].Seq.Expand())
+		c.Check(r.AuxFields.Get(sam.NewTag("RG")).Value(), check.Equals, "group")
+	}
+	c.Check(br.Close(), check.Equals, nil)
+
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	c.Check(br.Header().IsUnaligned(), check.Equals, false)
+	c.Check(br.Close(), check.Equals, nil)
+}
+
+// manyRefsBAM returns a BAM stream with n references and a single
+// unmapped record.
+func manyRefsBAM(n int) ([]byte, error) {
+	refs := make([]*sam.Reference, n)
+	for i := range refs {
+		var err error
+		refs[i], err = sam.NewReference(fmt.Sprintf("contig_with_a_long_name_%08d", i), "", "", 1000+i, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sam.NewRecord("read", nil, nil, -1, -1, 0, 0, nil, []byte("ACGT"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Flags = sam.Unmapped
+	err = bw.Write(r)
+	if err != nil {
+		return nil, err
+	}
+	err = bw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *S) TestReadHeaderOnly(c *check.C) {
+	big, err := manyRefsBAM(5000) // Header spans several BGZF blocks.
+	c.Assert(err, check.Equals, nil)
+	for _, in := range [][]byte{bamHG00096_1000, big} {
+		br, err := NewReader(bytes.NewReader(in), *conc)
+		c.Assert(err, check.Equals, nil)
+		want := br.Header()
+		c.Check(br.Close(), check.Equals, nil)
+
+		got, err := ReadHeaderOnly(bytes.NewReader(in))
+		c.Assert(err, check.Equals, nil)
+		c.Check(got, check.DeepEquals, want)
+	}
+
+	_, err = ReadHeaderOnly(bytes.NewReader(big[:len(big)/2]))
+	c.Check(err, check.Not(check.Equals), nil)
+}
+
 func headerText(h *sam.Header) []byte {
 	b, _ := h.MarshalText()
 	return b
@@ -217,6 +716,46 @@ func BenchmarkRead(b *testing.B) {
 	f.Close()
 }
 
+func BenchmarkHeader(b *testing.B) {
+	big, err := manyRefsBAM(5000)
+	if err != nil {
+		b.Fatalf("failed to construct BAM: %v", err)
+	}
+	for _, bench := range []struct {
+		name string
+		in   []byte
+	}{
+		{name: "HG00096_1000", in: bamHG00096_1000},
+		{name: "manyRefs", in: big},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			benchmarkHeader(b, bench.in)
+		})
+	}
+}
+
+func benchmarkHeader(b *testing.B, in []byte) {
+	b.Run("NewReader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			br, err := NewReader(bytes.NewReader(in), *conc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			br.Close()
+		}
+	})
+	b.Run("ReadHeaderOnly", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := ReadHeaderOnly(bytes.NewReader(in))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func BenchmarkReadIndex(b *testing.B) {
 	if *findex == "" {
 		b.Skip("no index file specified")
@@ -1952,6 +2491,45 @@ func (s *S) TestConceptualBAI(c *check.C) {
 	}
 }
 
+func (s *S) TestCoverage(c *check.C) {
+	gz, err := gzip.NewReader(bytes.NewReader(conceptualBAIdata))
+	c.Assert(err, check.Equals, nil)
+	bai, err := ReadIndex(gz)
+	c.Assert(err, check.Equals, nil)
+	br, err := NewReader(bytes.NewReader(conceptualBAMdata), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	ref := br.Header().Refs()[0]
+
+	// The records cover [62914560,69206016), [73400320,79691776)
+	// and [76546048,78643200).
+	repeat := func(v, n int) []int {
+		d := make([]int, n)
+		for i := range d {
+			d[i] = v
+		}
+		return d
+	}
+	for _, test := range []struct {
+		beg, end int
+		want     []int
+	}{
+		{beg: 62914550, end: 62914570, want: append(repeat(0, 10), repeat(1, 10)...)},
+		{beg: 69206010, end: 69206020, want: append(repeat(1, 6), repeat(0, 4)...)},
+		{beg: 76546040, end: 76546060, want: append(repeat(1, 8), repeat(2, 12)...)},
+		{beg: 78643195, end: 78643205, want: append(repeat(2, 5), repeat(1, 5)...)},
+		{beg: 70000000, end: 70000010, want: repeat(0, 10)},
+		{beg: 100000000, end: 100000010, want: repeat(0, 10)},
+	} {
+		got, err := br.Coverage(bai.Querier(), ref, test.beg, test.end)
+		c.Assert(err, check.Equals, nil)
+		c.Check(got, check.DeepEquals, test.want, check.Commentf("[%d,%d)", test.beg, test.end))
+	}
+
+	_, err = br.Coverage(bai.Querier(), ref, 10, 5)
+	c.Check(err, check.ErrorMatches, "bam: invalid coverage interval")
+}
+
 // @HD	VN:1.0	SO:coordinate
 // @SQ	SN:conceptual	LN:134217728
 // 60m66m:bin0	0	conceptual	62914561	40	6291456M	*	0	0	*	*
@@ -2054,48 +2632,591 @@ func (s *S) TestAdd(c *check.C) {
 	}
 }
 
-var chunkMergeTests = []struct {
-	index func() *Index
+func (s *S) TestAddTileSpanning(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 1<<17, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	_, err = sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
 
-	expectAdjacent *Index
+	var (
+		bai    Index
+		begins []bgzf.Offset
+	)
+	for i, p := range []struct{ pos, len int }{
+		{pos: 100, len: 100},
+		{pos: 16300, len: 100},   // Spans the first tile boundary.
+		{pos: 16500, len: 16268}, // Ends on the second tile boundary.
+		{pos: 40000, len: 100},
+	} {
+		r := &sam.Record{
+			Name:    fmt.Sprintf("r%d", i),
+			Ref:     ref,
+			Pos:     p.pos,
+			MatePos: -1,
+			Cigar:   sam.Cigar{sam.NewCigarOp(sam.CigarMatch, p.len)},
+		}
+		chunk := bgzf.Chunk{
+			Begin: bgzf.Offset{File: int64(100 * (i + 1))},
+			End:   bgzf.Offset{File: int64(100 * (i + 1)), Block: 10},
+		}
+		c.Assert(bai.Add(r, chunk), check.Equals, nil)
+		begins = append(begins, chunk.Begin)
+	}
 
-	expectSquash *Index
+	// Each tile holds the offset of the first record overlapping it.
+	c.Check(bai.idx.Refs[0].Intervals, check.DeepEquals, []bgzf.Offset{
+		begins[0], begins[1], begins[3],
+	})
+}
 
-	compStrat      index.MergeStrategy
-	expectCompress *Index
-}{
-	{
-		index: func() *Index {
-			return &Index{idx: internal.Index{
-				Refs: []internal.RefIndex{
-					{
-						Bins: []internal.Bin{
-							{
-								Bin: 0,
-								Chunks: []bgzf.Chunk{
-									{
-										Begin: bgzf.Offset{File: 1, Block: 0},
-										End:   bgzf.Offset{File: 2, Block: 0},
-									},
-									{
-										Begin: bgzf.Offset{File: 2, Block: 0},
-										End:   bgzf.Offset{File: 3, Block: 0},
-									},
-								},
-							},
-							{
-								Bin: 1,
-								Chunks: []bgzf.Chunk{
-									{
-										Begin: bgzf.Offset{File: 1, Block: 0},
-										End:   bgzf.Offset{File: 2, Block: 0},
-									},
-									{
-										Begin: bgzf.Offset{File: 2, Block: 0},
-										End:   bgzf.Offset{File: 3, Block: 0},
-									},
-								},
-							},
+func (s *S) TestReindex(c *check.C) {
+	gz, err := gzip.NewReader(bytes.NewReader(conceptualBAIdata))
+	c.Assert(err, check.Equals, nil)
+	expect, err := ReadIndex(gz)
+	c.Assert(err, check.Equals, nil)
+
+	// samtools defines the end offset of the last chunk as the
+	// end of the file, so translate that to the end of the
+	// last record; see conceptualChunks.
+	last := conceptualChunks[len(conceptualChunks)-1].End
+	fixEnd := func(chunks []bgzf.Chunk) {
+		for i, c := range chunks {
+			if c.End == (bgzf.Offset{File: 228}) {
+				chunks[i].End = last
+			}
+		}
+	}
+	for _, b := range expect.idx.Refs[0].Bins {
+		fixEnd(b.Chunks)
+	}
+	expect.idx.Refs[0].Stats.Chunk.End = last
+
+	br, err := NewReader(bytes.NewReader(conceptualBAMdata), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	// Move past the first record to check that
+	// Reindex starts from the end of the header.
+	_, err = br.Read()
+	c.Assert(err, check.Equals, nil)
+	br.Omit(AllVariableLengthData)
+
+	bai, err := Reindex(br)
+	c.Assert(err, check.Equals, nil)
+	c.Check(bai.idx.Refs[0].Bins, check.DeepEquals, expect.idx.Refs[0].Bins)
+	c.Check(bai.idx.Refs[0].Stats, check.DeepEquals, expect.idx.Refs[0].Stats)
+	c.Check(bai.idx.Unmapped, check.DeepEquals, expect.idx.Unmapped)
+
+	ref := br.Header().Refs()[0]
+	for _, test := range chunkTests {
+		got, err := bai.Chunks(ref, test.beg, test.end)
+		want, wantErr := expect.Chunks(ref, test.beg, test.end)
+		fixEnd(want)
+		c.Check(err, check.Equals, wantErr,
+			check.Commentf("Unexpected error for [%d,%d).", test.beg, test.end),
+		)
+		c.Check(got, check.DeepEquals, want,
+			check.Commentf("Unexpected result for [%d,%d).", test.beg, test.end),
+		)
+	}
+}
+
+func (s *S) TestReindexTileSpanning(c *check.C) {
+	chr1, err := sam.NewReference("chr1", "", "", 1<<17, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{chr1})
+	c.Assert(err, check.Equals, nil)
+	h.SortOrder = sam.Coordinate
+
+	var (
+		buf  bytes.Buffer
+		recs []*sam.Record
+	)
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for _, p := range []struct{ pos, len int }{
+		{pos: 100, len: 100},
+		{pos: 16300, len: 100},   // Spans the first tile boundary.
+		{pos: 16400, len: 50000}, // Spans several tiles.
+		{pos: 40000, len: 100},
+		{pos: 65530, len: 10},
+		{pos: 98303, len: 2}, // Spans the last tile boundary.
+	} {
+		r, err := sam.NewRecord(fmt.Sprintf("r%d", p.pos), chr1, nil, p.pos, -1, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, p.len)}, bytes.Repeat([]byte{'A'}, p.len), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(bw.Write(r), check.Equals, nil)
+		recs = append(recs, r)
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br := mustNewReader(c, buf.Bytes())
+	var begins []bgzf.Offset
+	for {
+		_, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		begins = append(begins, br.LastChunk().Begin)
+	}
+	idx, err := Reindex(br)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(br.Close(), check.Equals, nil)
+
+	// Each tile must hold the offset of the first record overlapping it.
+	c.Check(idx.idx.Refs[0].Intervals, check.DeepEquals, []bgzf.Offset{
+		begins[0], begins[1], begins[2], begins[2], begins[2], begins[5], begins[5],
+	})
+
+	checkIndexQueries(c, buf.Bytes(), recs, idx)
+}
+
+func (s *S) TestQuerier(c *check.C) {
+	var bai Index
+	cidx := csi.New(csi.DefaultShift, csi.DefaultDepth)
+	for _, r := range bamFile {
+		c.Assert(bai.Add(r.rec, r.chunk), check.Equals, nil)
+		c.Assert(cidx.Add(r.rec, r.chunk, true, true), check.Equals, nil)
+	}
+
+	// query is written once and used for both index types.
+	query := func(q index.Querier, rid, beg, end int) ([]bgzf.Chunk, uint64, error) {
+		chunks, err := q.Chunks(rid, beg, end)
+		if err != nil {
+			return nil, 0, err
+		}
+		stats, ok := q.ReferenceStats(rid)
+		if !ok {
+			return chunks, 0, nil
+		}
+		return chunks, stats.Mapped, nil
+	}
+
+	// The CSI does not use a linear index, so it returns the chunk for
+	// the record in bin 0 in addition to those returned by the BAI.
+	for _, test := range []struct {
+		q      index.Querier
+		expect []bgzf.Chunk
+	}{
+		{
+			q: bai.Querier(),
+			expect: []bgzf.Chunk{
+				{Begin: bgzf.Offset{File: 101, Block: 52}, End: bgzf.Offset{File: 228, Block: 0}},
+			},
+		},
+		{
+			q: cidx.Querier(),
+			expect: []bgzf.Chunk{
+				{Begin: bgzf.Offset{File: 101, Block: 0}, End: bgzf.Offset{File: 228, Block: 0}},
+			},
+		},
+	} {
+		q := test.q
+		chunks, mapped, err := query(q, conceptual.ID(), 77594624, 80740352)
+		c.Check(err, check.Equals, nil)
+		c.Check(chunks, check.DeepEquals, test.expect,
+			check.Commentf("Unexpected result for %T.", q),
+		)
+		c.Check(mapped, check.Equals, uint64(len(bamFile)))
+
+		_, _, err = query(q, 1, 0, 1)
+		c.Check(err, check.Equals, index.ErrNoReference)
+	}
+}
+
+func (s *S) TestFetchMulti(c *check.C) {
+	chr1, err := sam.NewReference("chr1", "", "", 100000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	chr2, err := sam.NewReference("chr2", "", "", 100000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{chr1, chr2})
+	c.Assert(err, check.Equals, nil)
+	h.SortOrder = sam.Coordinate
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for _, ref := range h.Refs() {
+		for pos := 0; pos < ref.Len(); pos += 1000 {
+			r, err := sam.NewRecord(fmt.Sprintf("%s:%d", ref.Name(), pos), ref, nil, pos, -1, 0, 60,
+				[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 100)}, bytes.Repeat([]byte{'A'}, 100), nil, nil)
+			c.Assert(err, check.Equals, nil)
+			c.Assert(bw.Write(r), check.Equals, nil)
+		}
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	var bai Index
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		c.Assert(bai.Add(r, br.LastChunk()), check.Equals, nil)
+	}
+	c.Assert(br.Close(), check.Equals, nil)
+
+	br, err = NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	refs := br.Header().Refs()
+	it, err := br.FetchMulti(bai.Querier(), []Region{
+		{Ref: refs[1], Beg: 5000, End: 8000},
+		{Ref: refs[0], Beg: 19050, End: 25000},
+	})
+	c.Assert(err, check.Equals, nil)
+	var got []string
+	for it.Next() {
+		got = append(got, it.Record().Name)
+	}
+	c.Check(it.Close(), check.Equals, nil)
+	c.Check(got, check.DeepEquals, []string{
+		"chr2:5000", "chr2:6000", "chr2:7000",
+		"chr1:19000", "chr1:20000", "chr1:21000", "chr1:22000", "chr1:23000", "chr1:24000",
+	})
+
+	it, err = br.FetchMulti(bai.Querier(), nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(it.Next(), check.Equals, false)
+	c.Check(it.Close(), check.Equals, nil)
+}
+
+func (s *S) TestFetchMultiEmptyRegion(c *check.C) {
+	var refs []*sam.Reference
+	for _, name := range []string{"chr1", "chr2", "chr3"} {
+		ref, err := sam.NewReference(name, "", "", 100000, nil, nil)
+		c.Assert(err, check.Equals, nil)
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	c.Assert(err, check.Equals, nil)
+	h.SortOrder = sam.Coordinate
+
+	// No records are placed on chr2, or beyond 20000 on chr1.
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for _, ref := range []*sam.Reference{refs[0], refs[2]} {
+		for pos := 0; pos < 20000; pos += 1000 {
+			r, err := sam.NewRecord(fmt.Sprintf("%s:%d", ref.Name(), pos), ref, nil, pos, -1, 0, 60,
+				[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 100)}, bytes.Repeat([]byte{'A'}, 100), nil, nil)
+			c.Assert(err, check.Equals, nil)
+			c.Assert(bw.Write(r), check.Equals, nil)
+		}
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br := mustNewReader(c, buf.Bytes())
+	bai, err := Reindex(br)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(br.Close(), check.Equals, nil)
+
+	br = mustNewReader(c, buf.Bytes())
+	defer br.Close()
+	refs = br.Header().Refs()
+	_, err = bai.Chunks(refs[1], 0, 1000)
+	c.Assert(err, check.Equals, index.ErrInvalid)
+
+	it, err := br.FetchMulti(bai.Querier(), []Region{
+		{Ref: refs[0], Beg: 1000, End: 2050},
+		{Ref: refs[1], Beg: 0, End: 1000},
+		{Ref: refs[0], Beg: 50000, End: 60000},
+		{Ref: refs[2], Beg: 5000, End: 6000},
+	})
+	c.Assert(err, check.Equals, nil)
+	var got []string
+	for it.Next() {
+		got = append(got, it.Record().Name)
+	}
+	c.Check(it.Close(), check.Equals, nil)
+	c.Check(got, check.DeepEquals, []string{"chr1:1000", "chr1:2000", "chr3:5000"})
+
+	it, err = br.FetchMulti(bai.Querier(), []Region{{Ref: refs[1], Beg: 0, End: 1000}})
+	c.Assert(err, check.Equals, nil)
+	c.Check(it.Next(), check.Equals, false)
+	c.Check(it.Close(), check.Equals, nil)
+}
+
+func (s *S) TestSetChunks(c *check.C) {
+	ref, err := sam.NewReference("chr1", "", "", 100000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	c.Assert(err, check.Equals, nil)
+
+	var buf bytes.Buffer
+	bw, err := NewWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for pos := 0; pos < ref.Len(); pos += 1000 {
+		r, err := sam.NewRecord(fmt.Sprintf("r%d", pos), ref, nil, pos, -1, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 100)}, bytes.Repeat([]byte{'A'}, 100), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(bw.Write(r), check.Equals, nil)
+	}
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	var chunks []bgzf.Chunk
+	for {
+		_, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		chunks = append(chunks, br.LastChunk())
+	}
+
+	c.Assert(br.SetChunks([]bgzf.Chunk{
+		{Begin: chunks[2].Begin, End: chunks[4].End},
+		{Begin: chunks[90].Begin, End: chunks[91].End},
+	}), check.Equals, nil)
+	var got []string
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		got = append(got, r.Name)
+	}
+	c.Check(got, check.DeepEquals, []string{"r2000", "r3000", "r4000", "r90000", "r91000"})
+
+	c.Assert(br.SetChunks([]bgzf.Chunk{
+		{Begin: chunks[10].Begin, End: chunks[19].End},
+		{Begin: chunks[50].Begin, End: chunks[54].End},
+	}), check.Equals, nil)
+	stats, err := br.FlagStats()
+	c.Assert(err, check.Equals, nil)
+	c.Check(stats.Total, check.Equals, uint64(15))
+
+	c.Assert(br.SetChunks(nil), check.Equals, nil)
+	c.Assert(br.Seek(chunks[98].Begin), check.Equals, nil)
+	var n int
+	for {
+		_, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		n++
+	}
+	c.Check(n, check.Equals, 2)
+}
+
+func (s *S) TestIndexingWriter(c *check.C) {
+	chr1, err := sam.NewReference("chr1", "", "", 1<<20, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	chr2, err := sam.NewReference("chr2", "", "", 1<<20, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{chr1, chr2})
+	c.Assert(err, check.Equals, nil)
+	h.SortOrder = sam.Coordinate
+
+	// Small blocks exercise records spanning blocks.
+	for _, autoFlush := range []int{0, 4096} {
+		testIndexingWriter(c, h, autoFlush)
+	}
+}
+
+func testIndexingWriter(c *check.C, h *sam.Header, autoFlush int) {
+	var (
+		buf  bytes.Buffer
+		recs []*sam.Record
+	)
+	iw, err := NewIndexingWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	iw.w.bg.AutoFlushBytes = autoFlush
+	for _, ref := range h.Refs() {
+		// Records are placed so that some span index tile boundaries.
+		for pos := 0; pos+600 <= ref.Len(); pos += 1000 {
+			r, err := sam.NewRecord(fmt.Sprintf("%s:%d", ref.Name(), pos), ref, nil, pos, -1, 0, 60,
+				[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 600)}, bytes.Repeat([]byte{'A'}, 600), nil, nil)
+			c.Assert(err, check.Equals, nil)
+			c.Assert(iw.Write(r), check.Equals, nil)
+			recs = append(recs, r)
+		}
+	}
+	unmapped, err := sam.NewUnmappedRecord("unmapped", []byte("ACGT"), nil)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(iw.Write(unmapped), check.Equals, nil)
+	c.Check(iw.Index(), check.IsNil)
+	c.Assert(iw.Close(), check.Equals, nil)
+	idx := iw.Index()
+	c.Assert(idx, check.NotNil)
+
+	c.Check(idx.NumRefs(), check.Equals, 2)
+	n, ok := idx.Unmapped()
+	c.Check(ok, check.Equals, true)
+	c.Check(n, check.Equals, uint64(1))
+	for i := range h.Refs() {
+		stats, ok := idx.ReferenceStats(i)
+		c.Check(ok, check.Equals, true)
+		c.Check(stats.Mapped, check.Equals, uint64(len(recs)/2))
+	}
+
+	// The index must be usable after a round trip.
+	var bai bytes.Buffer
+	c.Assert(WriteIndex(&bai, idx), check.Equals, nil)
+	idx, err = ReadIndex(&bai)
+	c.Assert(err, check.Equals, nil)
+
+	// The index must give the same query results as an
+	// index built by reading the BAM data.
+	br := mustNewReader(c, buf.Bytes())
+	readIdx, err := Reindex(br)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(br.Close(), check.Equals, nil)
+	checkIndexQueries(c, buf.Bytes(), recs, idx, readIdx)
+}
+
+// mustNewReader returns a Reader for the BAM data in b.
+func mustNewReader(c *check.C, b []byte) *Reader {
+	br, err := NewReader(bytes.NewReader(b), *conc)
+	c.Assert(err, check.Equals, nil)
+	return br
+}
+
+// checkIndexQueries checks that queries of the BAM data in b using each of
+// the indexes return exactly the records in recs overlapping the query.
+func checkIndexQueries(c *check.C, b []byte, recs []*sam.Record, idxs ...*Index) {
+	br := mustNewReader(c, b)
+	defer br.Close()
+	refs := br.Header().Refs()
+	for _, ref := range refs {
+		for beg := 0; beg < ref.Len(); beg += 7919 {
+			for _, end := range []int{beg + 1, beg + 300, beg + internal.TileWidth} {
+				var want []string
+				for _, r := range recs {
+					if r.Ref.Name() == ref.Name() && r.Start() < end && r.End() > beg {
+						want = append(want, r.Name)
+					}
+				}
+				for i, idx := range idxs {
+					chunks, err := idx.Chunks(ref, beg, end)
+					if err == index.ErrInvalid {
+						chunks = nil
+					} else {
+						c.Assert(err, check.Equals, nil)
+					}
+					it, err := NewIterator(br, chunks)
+					c.Assert(err, check.Equals, nil)
+					var got []string
+					for it.Next() {
+						r := it.Record()
+						if r.Ref == ref && r.Start() < end && r.End() > beg {
+							got = append(got, r.Name)
+						}
+					}
+					c.Check(it.Close(), check.Equals, nil)
+					c.Check(got, check.DeepEquals, want, check.Commentf("index %d %s:[%d,%d)", i, ref.Name(), beg, end))
+				}
+			}
+		}
+	}
+}
+
+func (s *S) TestIndexingWriterUnplaced(c *check.C) {
+	chr1, err := sam.NewReference("chr1", "", "", 1<<20, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{chr1})
+	c.Assert(err, check.Equals, nil)
+	h.SortOrder = sam.Coordinate
+
+	var buf bytes.Buffer
+	iw, err := NewIndexingWriter(&buf, h, *conc)
+	c.Assert(err, check.Equals, nil)
+	for pos := 0; pos < 3*1024; pos += 1024 {
+		r, err := sam.NewRecord(fmt.Sprintf("mapped:%d", pos), chr1, chr1, pos, pos+2048, 0, 60,
+			[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 100)}, bytes.Repeat([]byte{'A'}, 100), nil, nil)
+		c.Assert(err, check.Equals, nil)
+		r.Flags = sam.Paired | sam.MateUnmapped
+		c.Assert(iw.Write(r), check.Equals, nil)
+	}
+	// An unmapped read placed at its mate's position
+	// is counted in the reference statistics.
+	mate, err := sam.NewRecord("mate", chr1, chr1, 2048, 2048, 0, 0, nil, []byte("ACGT"), nil, nil)
+	c.Assert(err, check.Equals, nil)
+	mate.Flags = sam.Paired | sam.Unmapped
+	c.Assert(iw.Write(mate), check.Equals, nil)
+	for i := 0; i < 3; i++ {
+		r, err := sam.NewUnmappedRecord(fmt.Sprintf("unmapped:%d", i), []byte("ACGT"), nil)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(iw.Write(r), check.Equals, nil)
+	}
+
+	// Placed records may not follow unplaced records.
+	r, err := sam.NewRecord("late", chr1, nil, 4096, -1, 0, 60,
+		[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(iw.Write(r), check.ErrorMatches, "bam: placed record written after unplaced records")
+
+	c.Assert(iw.Close(), check.Equals, nil)
+	idx := iw.Index()
+	c.Assert(idx, check.NotNil)
+
+	n, ok := idx.Unmapped()
+	c.Check(ok, check.Equals, true)
+	c.Check(n, check.Equals, uint64(3))
+	stats, ok := idx.ReferenceStats(0)
+	c.Check(ok, check.Equals, true)
+	c.Check(stats.Mapped, check.Equals, uint64(3))
+	c.Check(stats.Unmapped, check.Equals, uint64(1))
+
+	// The index must match an index built from reading the BAM data.
+	br, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	bai, err := Reindex(br)
+	c.Assert(err, check.Equals, nil)
+	c.Check(idx.idx, check.DeepEquals, bai.idx)
+}
+
+var chunkMergeTests = []struct {
+	index func() *Index
+
+	expectAdjacent *Index
+
+	expectSquash *Index
+
+	compStrat      index.MergeStrategy
+	expectCompress *Index
+}{
+	{
+		index: func() *Index {
+			return &Index{idx: internal.Index{
+				Refs: []internal.RefIndex{
+					{
+						Bins: []internal.Bin{
+							{
+								Bin: 0,
+								Chunks: []bgzf.Chunk{
+									{
+										Begin: bgzf.Offset{File: 1, Block: 0},
+										End:   bgzf.Offset{File: 2, Block: 0},
+									},
+									{
+										Begin: bgzf.Offset{File: 2, Block: 0},
+										End:   bgzf.Offset{File: 3, Block: 0},
+									},
+								},
+							},
+							{
+								Bin: 1,
+								Chunks: []bgzf.Chunk{
+									{
+										Begin: bgzf.Offset{File: 1, Block: 0},
+										End:   bgzf.Offset{File: 2, Block: 0},
+									},
+									{
+										Begin: bgzf.Offset{File: 2, Block: 0},
+										End:   bgzf.Offset{File: 3, Block: 0},
+									},
+								},
+							},
 						},
 					},
 				},
@@ -2357,6 +3478,49 @@ func (s *S) TestIndexRoundtrip(c *check.C) {
 	}
 }
 
+func (s *S) TestOpenIndex(c *check.C) {
+	c.Check(IndexPath("dir/foo.bam"), check.DeepEquals, []string{"dir/foo.bam.bai", "dir/foo.bai"})
+	c.Check(IndexPath("dir/foo"), check.DeepEquals, []string{"dir/foo.bai"})
+
+	writeIndex := func(path string, idx *Index) {
+		f, err := os.Create(path)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(WriteIndex(f, idx), check.Equals, nil)
+		c.Assert(f.Close(), check.Equals, nil)
+	}
+	first := baiTestData[0].expect
+	second := baiTestData[1].expect
+
+	dir := c.MkDir()
+	bamPath := filepath.Join(dir, "foo.bam")
+	_, err := OpenIndex(bamPath)
+	c.Check(errors.Is(err, fs.ErrNotExist), check.Equals, true)
+
+	// Only foo.bai.
+	writeIndex(filepath.Join(dir, "foo.bai"), second)
+	got, err := OpenIndex(bamPath)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got, check.DeepEquals, second)
+
+	// Both foo.bam.bai and foo.bai; foo.bam.bai is preferred.
+	writeIndex(filepath.Join(dir, "foo.bam.bai"), first)
+	got, err = OpenIndex(bamPath)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got, check.DeepEquals, first)
+
+	// Only foo.bam.bai.
+	c.Assert(os.Remove(filepath.Join(dir, "foo.bai")), check.Equals, nil)
+	got, err = OpenIndex(bamPath)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got, check.DeepEquals, first)
+
+	// A corrupt index is an error, not a reason to look further.
+	c.Assert(os.WriteFile(filepath.Join(dir, "foo.bam.bai"), []byte("not an index"), 0o644), check.Equals, nil)
+	writeIndex(filepath.Join(dir, "foo.bai"), second)
+	_, err = OpenIndex(bamPath)
+	c.Check(err, check.ErrorMatches, `bam: failed to read index ".*foo.bam.bai": bam: magic number mismatch`)
+}
+
 func (s *S) TestBufferSharing(c *check.C) {
 	// @HD	VN:1.5	GO:none	SO:coordinate
 	// @SQ	SN:1	LN:249250621
@@ -2513,3 +3677,259 @@ func TestFuzzCrashers(t *testing.T) {
 		}()
 	}
 }
+
+func (s *S) TestNewRegionReader(c *check.C) {
+	gz, err := gzip.NewReader(bytes.NewReader(conceptualBAIdata))
+	c.Assert(err, check.Equals, nil)
+	bai, err := ReadIndex(gz)
+	c.Assert(err, check.Equals, nil)
+
+	// The records cover [62914560,69206016), [73400320,79691776)
+	// and [76546048,78643200).
+	for _, test := range []struct {
+		beg, end int
+		want     []string
+	}{
+		{beg: 62914560, end: 62914570, want: []string{"60m66m:bin0"}},
+		{beg: 69206016, end: 73400320, want: nil},
+		{beg: 73400319, end: 73400330, want: []string{"70m76m:bin2"}},
+		{beg: 77000000, end: 77000010, want: []string{"70m76m:bin2", "73m75m:bin18"}},
+		{beg: 0, end: 134217728, want: []string{"60m66m:bin0", "70m76m:bin2", "73m75m:bin18"}},
+	} {
+		// conceptual is not held by the BAM header, so
+		// the header reference is found by name.
+		it, err := NewRegionReader(bytes.NewReader(conceptualBAMdata), *conc, bai.Querier(), conceptual, test.beg, test.end)
+		c.Assert(err, check.Equals, nil)
+		var got []string
+		for it.Next() {
+			got = append(got, it.Record().Name)
+		}
+		c.Check(it.Close(), check.Equals, nil)
+		c.Check(got, check.DeepEquals, test.want, check.Commentf("[%d,%d)", test.beg, test.end))
+	}
+
+	missing, err := sam.NewReference("missing", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	_, err = NewRegionReader(bytes.NewReader(conceptualBAMdata), *conc, bai.Querier(), missing, 0, 1000)
+	c.Check(err, check.ErrorMatches, `bam: reference "missing" not in header`)
+}
+
+func (s *S) TestReferenceByID(c *check.C) {
+	br, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	defer br.Close()
+	refs := br.Header().Refs()
+	c.Assert(len(refs) > 1, check.Equals, true)
+
+	for _, test := range []struct {
+		id   int
+		want *sam.Reference
+		ok   bool
+	}{
+		{id: -1, want: nil, ok: true},
+		{id: 0, want: refs[0], ok: true},
+		{id: 1, want: refs[1], ok: true},
+		{id: len(refs) - 1, want: refs[len(refs)-1], ok: true},
+		{id: len(refs), want: nil, ok: false},
+		{id: len(refs) + 1, want: nil, ok: false},
+		{id: -2, want: nil, ok: false},
+	} {
+		got, ok := br.ReferenceByID(test.id)
+		c.Check(ok, check.Equals, test.ok, check.Commentf("id %d", test.id))
+		c.Check(got == test.want, check.Equals, true, check.Commentf("id %d", test.id))
+	}
+
+	// Records with a reference ID equal to the number of
+	// references are rejected rather than causing a panic.
+	rec, err := sam.NewRecord("r", refs[0], nil, 0, -1, 0, 60,
+		[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+	c.Assert(err, check.Equals, nil)
+	for _, ids := range []struct {
+		ref, mate int
+		err       string
+	}{
+		{ref: len(refs), mate: -1, err: "bam: reference id out of range"},
+		{ref: 0, mate: len(refs), err: "bam: mate reference id out of range"},
+	} {
+		var buf bytes.Buffer
+		bw, err := NewWriter(&buf, br.Header(), 1)
+		c.Assert(err, check.Equals, nil)
+		var raw bytes.Buffer
+		c.Assert(rec.EncodeBinary(&raw, int32(ids.ref), int32(ids.mate)), check.Equals, nil)
+		c.Assert(bw.WriteRaw(raw.Bytes()), check.Equals, nil)
+		c.Assert(bw.Close(), check.Equals, nil)
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), 1)
+		c.Assert(err, check.Equals, nil)
+		_, err = r.Read()
+		c.Check(err, check.ErrorMatches, ids.err)
+		c.Check(r.Close(), check.Equals, nil)
+	}
+}
+
+func (s *S) TestNewMultiReader(c *check.C) {
+	newHeader := func(refs ...string) *sam.Header {
+		var r []*sam.Reference
+		for _, name := range refs {
+			ref, err := sam.NewReference(name, "", "", 1000, nil, nil)
+			c.Assert(err, check.Equals, nil)
+			r = append(r, ref)
+		}
+		h, err := sam.NewHeader(nil, r)
+		c.Assert(err, check.Equals, nil)
+		return h
+	}
+	write := func(h *sam.Header, names ...string) []byte {
+		var buf bytes.Buffer
+		bw, err := NewWriter(&buf, h, *conc)
+		c.Assert(err, check.Equals, nil)
+		for i, name := range names {
+			r, err := sam.NewRecord(name, h.Refs()[0], nil, i*10, -1, 0, 60,
+				[]sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}, []byte("ACGT"), nil, nil)
+			c.Assert(err, check.Equals, nil)
+			c.Assert(bw.Write(r), check.Equals, nil)
+		}
+		c.Assert(bw.Close(), check.Equals, nil)
+		return buf.Bytes()
+	}
+
+	a := write(newHeader("chr1", "chr2"), "a0", "a1", "a2")
+	b := write(newHeader("chr1", "chr2"), "b0", "b1")
+	empty := write(newHeader("chr1", "chr2"))
+
+	br, err := NewMultiReader([]io.Reader{bytes.NewReader(a), bytes.NewReader(empty), bytes.NewReader(b)}, *conc)
+	c.Assert(err, check.Equals, nil)
+	var got []string
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		c.Check(r.Ref == br.Header().Refs()[0], check.Equals, true)
+		got = append(got, r.Name)
+	}
+	c.Check(got, check.DeepEquals, []string{"a0", "a1", "a2", "b0", "b1"})
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Raw records are read from all the streams.
+	br, err = NewMultiReader([]io.Reader{bytes.NewReader(a), bytes.NewReader(empty), bytes.NewReader(b)}, *conc)
+	c.Assert(err, check.Equals, nil)
+	got = got[:0]
+	for {
+		p, err := br.ReadRaw()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		var r sam.Record
+		c.Assert(r.UnmarshalBAM(br.Header(), p), check.Equals, nil)
+		got = append(got, r.Name)
+	}
+	c.Check(got, check.DeepEquals, []string{"a0", "a1", "a2", "b0", "b1"})
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Flag statistics are collected from all the streams.
+	br, err = NewMultiReader([]io.Reader{bytes.NewReader(a), bytes.NewReader(empty), bytes.NewReader(b)}, *conc)
+	c.Assert(err, check.Equals, nil)
+	stats, err := br.FlagStats()
+	c.Check(err, check.Equals, nil)
+	c.Check(stats.Total, check.Equals, uint64(5))
+	c.Check(stats.Mapped, check.Equals, uint64(5))
+	c.Check(br.Close(), check.Equals, nil)
+
+	mismatch := write(newHeader("chr1", "chr3"), "m0")
+	br, err = NewMultiReader([]io.Reader{bytes.NewReader(a), bytes.NewReader(mismatch)}, *conc)
+	c.Assert(err, check.Equals, nil)
+	got = got[:0]
+	for {
+		r, err := br.Read()
+		if err != nil {
+			c.Check(err, check.ErrorMatches, "bam: header mismatch in concatenated stream")
+			break
+		}
+		got = append(got, r.Name)
+	}
+	c.Check(got, check.DeepEquals, []string{"a0", "a1", "a2"})
+	c.Check(br.Close(), check.Equals, nil)
+
+	_, err = NewMultiReader(nil, *conc)
+	c.Check(err, check.ErrorMatches, "bam: no streams to read")
+}
+
+func (s *S) TestUsedReferences(c *check.C) {
+	var refs []*sam.Reference
+	for _, name := range []string{"chr1", "chr2", "chr3", "chr4", "chr5"} {
+		ref, err := sam.NewReference(name, "", "", 1000, nil, nil)
+		c.Assert(err, check.Equals, nil)
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	c.Assert(err, check.Equals, nil)
+
+	co := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	for _, test := range []struct {
+		recs []*sam.Record
+		want map[int]bool
+	}{
+		{
+			recs: nil,
+			want: map[int]bool{},
+		},
+		{
+			recs: []*sam.Record{
+				{Name: "r0", Ref: refs[0], Pos: 10, MateRef: refs[0], MatePos: 100, Cigar: co},
+				{Name: "r1", Ref: refs[2], Pos: 10, MateRef: refs[3], MatePos: 100, Flags: sam.Paired, Cigar: co},
+				{Name: "r2", Ref: nil, Pos: -1, MateRef: nil, MatePos: -1, Flags: sam.Unmapped},
+			},
+			want: map[int]bool{0: true, 2: true, 3: true},
+		},
+		{
+			recs: []*sam.Record{
+				{Name: "r0", Ref: refs[4], Pos: 10, MateRef: refs[4], MatePos: 10, Flags: sam.Unmapped},
+			},
+			want: map[int]bool{4: true},
+		},
+	} {
+		var buf bytes.Buffer
+		bw, err := NewWriter(&buf, h, *conc)
+		c.Assert(err, check.Equals, nil)
+		for _, r := range test.recs {
+			r.Seq = sam.NewSeq([]byte("ACGT"))
+			c.Assert(bw.Write(r), check.Equals, nil)
+		}
+		c.Assert(bw.Close(), check.Equals, nil)
+
+		br, err := NewReader(&buf, *conc)
+		c.Assert(err, check.Equals, nil)
+		got, err := UsedReferences(br)
+		c.Check(err, check.Equals, nil)
+		c.Check(got, check.DeepEquals, test.want)
+		_, err = br.Read()
+		c.Check(err, check.Equals, io.EOF)
+		c.Check(br.Close(), check.Equals, nil)
+
+		// Unused references are resolved before removal.
+		bh := br.Header()
+		var unused []*sam.Reference
+		for id, ref := range bh.Refs() {
+			if !got[id] {
+				unused = append(unused, ref)
+			}
+		}
+		for _, ref := range unused {
+			c.Assert(bh.RemoveReference(ref), check.Equals, nil)
+		}
+		var names []string
+		for _, ref := range bh.Refs() {
+			names = append(names, ref.Name())
+		}
+		var want []string
+		for id, ref := range refs {
+			if test.want[id] {
+				want = append(want, ref.Name())
+			}
+		}
+		c.Check(names, check.DeepEquals, want)
+	}
+}
@@ -5,9 +5,14 @@
 package bam
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"strings"
 
 	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/bgzf/index"
@@ -55,6 +60,40 @@ func (i *Index) Add(r *sam.Record, c bgzf.Chunk) error {
 	return i.idx.Add(r, uint32(r.Bin()), c, isPlaced(r), isMapped(r))
 }
 
+// Reindex returns a BAI index for the coordinate-sorted BAM data read
+// by r. If the underlying io.Reader is an io.Seeker, r is first
+// positioned at the end of the BAM header, otherwise indexing starts
+// from the current position of r which must be at a record boundary.
+// Any chunk limits or omission level set on r are cleared. Reindex
+// reads r to the end of the stream; the returned index may be
+// written with WriteIndex.
+func Reindex(r *Reader) (*Index, error) {
+	r.c = nil
+	r.chunks = nil
+	r.omit = None
+	if r.r.Seekable() {
+		err := r.Seek(r.headerEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var idx Index
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		err = idx.Add(rec, r.LastChunk())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &idx, nil
+}
+
 func isPlaced(r *sam.Record) bool {
 	return r.Ref != nil && r.Pos != -1
 }
@@ -65,14 +104,30 @@ func isMapped(r *sam.Record) bool {
 
 // Chunks returns a []bgzf.Chunk that corresponds to the given genomic interval.
 func (i *Index) Chunks(r *sam.Reference, beg, end int) ([]bgzf.Chunk, error) {
-	chunks, err := i.idx.Chunks(r.ID(), beg, end)
+	return baiQuerier{i}.Chunks(r.ID(), beg, end)
+}
+
+// Querier returns an index.Querier that queries the Index by reference ID.
+func (i *Index) Querier() index.Querier { return baiQuerier{i} }
+
+var _ index.Querier = baiQuerier{}
+
+// baiQuerier is an index.Querier adapter for a BAI Index.
+type baiQuerier struct {
+	*Index
+}
+
+// Chunks returns a []bgzf.Chunk that corresponds to the given genomic interval
+// on the reference with the given ID.
+func (q baiQuerier) Chunks(rid, beg, end int) ([]bgzf.Chunk, error) {
+	chunks, err := q.idx.Chunks(rid, beg, end)
 	if err != nil {
 		return nil, err
 	}
-	if i.MergeStrategy == nil {
+	if q.MergeStrategy == nil {
 		return index.Adjacent(chunks), nil
 	}
-	return i.MergeStrategy(chunks), nil
+	return q.MergeStrategy(chunks), nil
 }
 
 // MergeChunks applies the given MergeStrategy to all bins in the Index.
@@ -82,6 +137,40 @@ func (i *Index) MergeChunks(s index.MergeStrategy) {
 
 var baiMagic = [4]byte{'B', 'A', 'I', 0x1}
 
+// IndexPath returns the candidate BAI index paths for the BAM file at
+// bamPath in the order they are searched by samtools: the BAM path with
+// ".bai" appended, and then, if bamPath has a ".bam" extension, the BAM
+// path with the extension replaced by ".bai".
+func IndexPath(bamPath string) []string {
+	paths := []string{bamPath + ".bai"}
+	if strings.HasSuffix(bamPath, ".bam") {
+		paths = append(paths, strings.TrimSuffix(bamPath, ".bam")+".bai")
+	}
+	return paths
+}
+
+// OpenIndex reads the BAI index for the BAM file at bamPath from the
+// first of the paths returned by IndexPath that exists. If no index
+// file exists, the returned error wraps fs.ErrNotExist.
+func OpenIndex(bamPath string) (*Index, error) {
+	for _, p := range IndexPath(bamPath) {
+		f, err := os.Open(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		idx, err := ReadIndex(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("bam: failed to read index %q: %w", p, err)
+		}
+		return idx, nil
+	}
+	return nil, fmt.Errorf("bam: no index found for %q: %w", bamPath, fs.ErrNotExist)
+}
+
 // ReadIndex reads the BAI Index from the given io.Reader.
 func ReadIndex(r io.Reader) (*Index, error) {
 	var (
@@ -0,0 +1,45 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package bam
+
+import (
+	"io"
+	"iter"
+
+	"github.com/biogo/hts/sam"
+)
+
+// All returns an iterator over the records remaining in the BAM stream.
+// Each record is obtained by a call to Read, so the records are subject
+// to the same Omit settings and are not reused by the Reader; a record
+// may be retained after the loop body has moved on. Iteration stops at
+// the end of the stream, or the end of the current chunk set by SetChunk,
+// without yielding io.EOF. Any other error is yielded with a nil record
+// and ends the iteration.
+//
+//	for rec, err := range br.All() {
+//		if err != nil {
+//			return err
+//		}
+//		fn(rec)
+//	}
+func (br *Reader) All() iter.Seq2[*sam.Record, error] {
+	return func(yield func(*sam.Record, error) bool) {
+		for {
+			rec, err := br.Read()
+			if err != nil {
+				if err != io.EOF {
+					yield(nil, err)
+				}
+				return
+			}
+			if !yield(rec, nil) {
+				return
+			}
+		}
+	}
+}
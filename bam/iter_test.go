@@ -0,0 +1,69 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package bam
+
+import (
+	"bytes"
+
+	"github.com/biogo/hts/sam"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAll(c *check.C) {
+	br, err := NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	var want []*sam.Record
+	for {
+		r, err := br.Read()
+		if err != nil {
+			break
+		}
+		want = append(want, r)
+	}
+	c.Check(br.Close(), check.Equals, nil)
+
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	var got []*sam.Record
+	for r, err := range br.All() {
+		c.Assert(err, check.Equals, nil)
+		got = append(got, r)
+	}
+	c.Check(got, check.DeepEquals, want)
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Break early and continue reading from the Reader.
+	const stop = 10
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000), *conc)
+	c.Assert(err, check.Equals, nil)
+	var n int
+	for r, err := range br.All() {
+		c.Assert(err, check.Equals, nil)
+		c.Check(r, check.DeepEquals, want[n])
+		n++
+		if n == stop {
+			break
+		}
+	}
+	c.Check(n, check.Equals, stop)
+	r, err := br.Read()
+	c.Assert(err, check.Equals, nil)
+	c.Check(r, check.DeepEquals, want[stop])
+	c.Check(br.Close(), check.Equals, nil)
+
+	// Errors are yielded and end the iteration.
+	br, err = NewReader(bytes.NewReader(bamHG00096_1000[:len(bamHG00096_1000)/2]), *conc)
+	c.Assert(err, check.Equals, nil)
+	var errs int
+	for _, err := range br.All() {
+		if err != nil {
+			errs++
+		}
+	}
+	c.Check(errs, check.Equals, 1)
+}
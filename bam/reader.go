@@ -5,7 +5,9 @@
 package bam
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -13,6 +15,8 @@ import (
 	"unsafe"
 
 	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/bgzf/index"
+	"github.com/biogo/hts/internal"
 	"github.com/biogo/hts/sam"
 )
 
@@ -22,6 +26,10 @@ type Reader struct {
 	h *sam.Header
 	c *bgzf.Chunk
 
+	// chunks holds the chunks set by
+	// SetChunks that follow c.
+	chunks []bgzf.Chunk
+
 	// references is cached header
 	// reference count.
 	references int32
@@ -31,8 +39,26 @@ type Reader struct {
 	// a read of the BAM input.
 	omit int
 
+	// storedBin is the bin field of the
+	// most recently read record and
+	// validateBins specifies that Read
+	// checks it against the computed bin.
+	storedBin    uint16
+	validateBins bool
+
 	lastChunk bgzf.Chunk
 
+	// headerEnd is the offset of the
+	// first record in the BAM stream.
+	headerEnd bgzf.Offset
+
+	// streams holds the BAM streams that
+	// follow the current stream of a Reader
+	// returned by NewMultiReader, and rd is
+	// the read concurrency used to open them.
+	streams []io.Reader
+	rd      int
+
 	// buf is used to read the block data for each record.
 	// The size is chosen to be small, but large enough to
 	// be able to contain the majority of reasonable BAM
@@ -61,9 +87,101 @@ func NewReader(r io.Reader, rd int) (*Reader, error) {
 		return nil, err
 	}
 	br.lastChunk.End = br.r.LastChunk().End
+	br.headerEnd = br.lastChunk.End
 	return br, nil
 }
 
+// NewMultiReader returns a new Reader that reads the records of each of the
+// BAM streams in rs in turn, as a single stream, setting the read concurrency
+// to rd as for NewReader. The header of the first stream is returned by the
+// Reader's Header method. The header of each following stream is read when
+// the preceding stream is exhausted, and must be identical to the first;
+// otherwise Read returns an error. The BGZF offsets reported by the Reader,
+// and the offsets accepted by Seek and SetChunk, refer to the stream being
+// read, so index-based access is only meaningful within a single stream.
+// Reading continues to the following streams only when no chunk has been
+// set on the Reader.
+func NewMultiReader(rs []io.Reader, rd int) (*Reader, error) {
+	if len(rs) == 0 {
+		return nil, errors.New("bam: no streams to read")
+	}
+	br, err := NewReader(rs[0], rd)
+	if err != nil {
+		return nil, err
+	}
+	br.streams = rs[1:]
+	br.rd = rd
+	return br, nil
+}
+
+// nextStream replaces the current BGZF stream of br with the first of
+// its following streams, checking that the stream's header matches.
+func (br *Reader) nextStream() error {
+	r := br.streams[0]
+	br.streams = br.streams[1:]
+	bg, err := bgzf.NewReader(r, br.rd)
+	if err != nil {
+		return err
+	}
+	h, _ := sam.NewHeader(nil, nil)
+	err = h.DecodeBinary(bg)
+	if err != nil {
+		bg.Close()
+		return err
+	}
+	want, err := br.h.MarshalBinary()
+	if err != nil {
+		bg.Close()
+		return err
+	}
+	got, err := h.MarshalBinary()
+	if err != nil || !bytes.Equal(got, want) {
+		bg.Close()
+		return errors.New("bam: header mismatch in concatenated stream")
+	}
+	err = br.r.Close()
+	if err != nil {
+		bg.Close()
+		return err
+	}
+	br.r = bg
+	br.lastChunk = bgzf.Chunk{End: bg.LastChunk().End}
+	br.headerEnd = br.lastChunk.End
+	return nil
+}
+
+// ReadHeaderOnly returns the SAM Header read from the BAM stream in r.
+// Only the gzip members that hold the header are decompressed and no
+// BGZF block reading state is constructed, so ReadHeaderOnly allocates
+// less than NewReader when only the header is needed. The BGZF block
+// structure of the stream is not validated and r is left positioned
+// at an undefined point after the header.
+func ReadHeaderOnly(r io.Reader) (*sam.Header, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	h, _ := sam.NewHeader(nil, nil)
+	err = h.DecodeBinary(fullReader{bufio.NewReader(gz)})
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return h, nil
+}
+
+// fullReader is an io.Reader that fills the buffer on each call to Read,
+// reading across gzip member boundaries.
+type fullReader struct {
+	r io.Reader
+}
+
+func (r fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(r.r, p)
+}
+
 // Header returns the SAM Header held by the Reader.
 func (br *Reader) Header() *sam.Header {
 	return br.h
@@ -103,6 +221,33 @@ func (br *Reader) Omit(o int) {
 	br.omit = o
 }
 
+// LastStoredBin returns the bin field stored in the BAM encoding of the
+// record most recently returned by Read. The stored bin is not otherwise
+// used by the Reader.
+func (br *Reader) LastStoredBin() uint16 {
+	return br.storedBin
+}
+
+// SetValidateBins specifies whether Read checks the bin stored in each
+// record against the bin computed from the record's position and CIGAR,
+// returning an error if they disagree. A disagreement indicates a
+// malformed BAM file. For placed unmapped records, the bin of a length
+// one alignment at the record's position is also accepted.
+func (br *Reader) SetValidateBins(validate bool) {
+	br.validateBins = validate
+}
+
+// validBin returns whether bin is a valid stored bin for rec. In addition
+// to the bin computed by rec.Bin, a placed unmapped record may hold the
+// bin for an alignment of length one at its position, as written by
+// htslib.
+func validBin(rec *sam.Record, bin uint16) bool {
+	if int(bin) == rec.Bin() {
+		return true
+	}
+	return rec.Flags&sam.Unmapped != 0 && rec.Pos >= 0 && uint32(bin) == internal.BinFor(rec.Pos, rec.Pos+1)
+}
+
 // None, AuxTags and AllVariableLengthData are values taken
 // by the Reader Omit method.
 const (
@@ -118,11 +263,14 @@ const (
 // prior to the Read call and will not contain the auxiliary tag data
 // if Omit(AuxTags) has been called.
 func (br *Reader) Read() (*sam.Record, error) {
-	if br.c != nil && vOffset(br.r.LastChunk().End) >= vOffset(br.c.End) {
-		return nil, io.EOF
-	}
+	return br.read(0)
+}
 
-	b, err := newBuffer(br)
+// read returns the next sam.Record in the BAM stream that has none of
+// the flags in skip set. Skipped records are not decoded beyond their
+// flags.
+func (br *Reader) read(skip sam.Flags) (*sam.Record, error) {
+	b, err := br.nextBuffer(skip)
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +280,7 @@ func (br *Reader) Read() (*sam.Record, error) {
 	rec.Pos = int(b.readInt32())
 	nLen := b.readUint8()
 	rec.MapQ = b.readUint8()
-	b.discard(2)
+	br.storedBin = b.readUint16()
 	nCigar := b.readUint16()
 	rec.Flags = sam.Flags(b.readUint16())
 	lSeq := int(b.readInt32())
@@ -166,31 +314,249 @@ func (br *Reader) Read() (*sam.Record, error) {
 		goto done
 	}
 	auxTags = b.bytes(b.len())
-	rec.AuxFields, err = parseAux(auxTags)
+	rec.AuxFields, err = internal.ParseAux[sam.Aux](auxTags)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("bam: %w", err)
+	}
+	if refID >= 0 && rec.Pos >= 0 {
+		rec.Cigar, rec.AuxFields = internal.ExpandLongCigar(rec.Cigar, rec.Seq.Length, rec.AuxFields)
 	}
 
 done:
-	refs := int32(len(br.h.Refs()))
-	if refID != -1 {
-		if refID < -1 || refID >= refs {
-			return nil, errors.New("bam: reference id out of range")
+	if br.validateBins && !validBin(&rec, br.storedBin) {
+		return nil, fmt.Errorf("bam: stored bin %d does not match computed bin %d for %q", br.storedBin, rec.Bin(), rec.Name)
+	}
+	var ok bool
+	rec.Ref, ok = br.ReferenceByID(int(refID))
+	if !ok {
+		return nil, errors.New("bam: reference id out of range")
+	}
+	if refID == nextRefID {
+		rec.MateRef = rec.Ref
+		return &rec, nil
+	}
+	rec.MateRef, ok = br.ReferenceByID(int(nextRefID))
+	if !ok {
+		return nil, errors.New("bam: mate reference id out of range")
+	}
+
+	return &rec, nil
+}
+
+// nextBuffer returns a buffer holding the next BAM record in the stream
+// that has none of the flags in skip set.
+func (br *Reader) nextBuffer(skip sam.Flags) (*buffer, error) {
+	for {
+		end, err := br.chunkEnd()
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return nil, io.EOF
+		}
+
+		b, err := newBuffer(br)
+		if err == io.EOF && len(br.streams) != 0 && br.c == nil {
+			err = br.nextStream()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if skip == 0 || b.flags()&skip == 0 {
+			return b, nil
 		}
-		rec.Ref = br.h.Refs()[refID]
 	}
-	if nextRefID != -1 {
-		if refID == nextRefID {
-			rec.MateRef = rec.Ref
-			return &rec, nil
+}
+
+// UsedReferences reads the remaining records in r and returns the set of
+// reference IDs used by at least one record, either as the record's
+// reference or as its mate's reference. Records are not decoded beyond
+// their reference ID fields. The returned set can be used to drop
+// references that no record uses with sam.Header.RemoveReference, but
+// since RemoveReference renumbers the references that follow the one
+// removed, the unused IDs must all be resolved to their References
+// before any are removed.
+func UsedReferences(r *Reader) (map[int]bool, error) {
+	used := make(map[int]bool)
+	for {
+		b, err := r.nextBuffer(0)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return used, err
+		}
+		ref, mate, ok := b.refIDs()
+		if !ok {
+			return used, errors.New("bam: invalid record: short fixed fields")
 		}
-		if nextRefID < -1 || nextRefID >= refs {
-			return nil, errors.New("bam: mate reference id out of range")
+		for _, id := range []int{ref, mate} {
+			if id == -1 {
+				continue
+			}
+			if _, ok := r.ReferenceByID(id); !ok {
+				return used, errors.New("bam: reference id out of range")
+			}
+			used[id] = true
 		}
-		rec.MateRef = br.h.Refs()[nextRefID]
 	}
+}
 
-	return &rec, nil
+// ReferenceByID returns the Reference in the BAM header with the given
+// reference ID and true. The ID -1, used by records that are not placed
+// on a reference, returns a nil Reference and true. Other IDs outside
+// the range of the header's references return nil and false.
+func (br *Reader) ReferenceByID(id int) (*sam.Reference, bool) {
+	if id == -1 {
+		return nil, true
+	}
+	refs := br.h.Refs()
+	if id < 0 || id >= len(refs) {
+		return nil, false
+	}
+	return refs[id], true
+}
+
+// ReadRaw returns the next BAM record in the stream as its undecoded
+// binary encoding, including the leading block size field. The returned
+// slice is newly allocated and may be passed to Writer.WriteRaw.
+func (br *Reader) ReadRaw() ([]byte, error) {
+	b, err := br.nextBuffer(0)
+	if err != nil {
+		return nil, err
+	}
+	p := make([]byte, lenFieldSize+len(b.data))
+	binary.LittleEndian.PutUint32(p, uint32(len(b.data)))
+	copy(p[lenFieldSize:], b.data)
+	return p, nil
+}
+
+// FlagStats holds alignment flag statistics in the style of samtools flagstat.
+// Paired, ProperPair and Singleton are counted only for primary alignments.
+type FlagStats struct {
+	// Total is the total number of records.
+	Total uint64
+
+	// Secondary, Supplementary and Duplicate
+	// are the number of records with the
+	// respective flag set.
+	Secondary     uint64
+	Supplementary uint64
+	Duplicate     uint64
+
+	// Mapped is the number of records without
+	// the sam.Unmapped flag set.
+	Mapped uint64
+
+	// Paired is the number of primary records
+	// that are paired in sequencing.
+	Paired uint64
+
+	// ProperPair is the number of mapped
+	// primary records that are mapped in a
+	// proper pair.
+	ProperPair uint64
+
+	// Singleton is the number of mapped paired
+	// primary records with an unmapped mate.
+	Singleton uint64
+}
+
+// add adds a record with the given flags to the statistics.
+func (s *FlagStats) add(f sam.Flags) {
+	s.Total++
+	if f&sam.Secondary != 0 {
+		s.Secondary++
+	}
+	if f&sam.Supplementary != 0 {
+		s.Supplementary++
+	}
+	if f&sam.Duplicate != 0 {
+		s.Duplicate++
+	}
+	if f&sam.Unmapped == 0 {
+		s.Mapped++
+	}
+	if f&(sam.Secondary|sam.Supplementary) != 0 || f&sam.Paired == 0 {
+		return
+	}
+	s.Paired++
+	if f&(sam.ProperPair|sam.Unmapped) == sam.ProperPair {
+		s.ProperPair++
+	}
+	if f&(sam.MateUnmapped|sam.Unmapped) == sam.MateUnmapped {
+		s.Singleton++
+	}
+}
+
+// FlagStats returns alignment flag statistics for the records remaining
+// in the BAM stream. Only the fixed-length portion of each record is
+// decoded, so FlagStats is faster than reading each record with Read.
+// After FlagStats returns without error, the Reader is at the end of the
+// stream or the end of the current chunk set by SetChunk.
+func (br *Reader) FlagStats() (FlagStats, error) {
+	var stats FlagStats
+	for {
+		b, err := br.nextBuffer(0)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return stats, err
+		}
+		// Skip refID, pos, l_read_name, mapq, bin and n_cigar_op.
+		b.discard(4 + 4 + 1 + 1 + 2 + 2)
+		flags := sam.Flags(b.readUint16())
+		if b.err != nil {
+			return stats, b.err
+		}
+		stats.add(flags)
+	}
+}
+
+// ScanTolerant reads the remaining records in the BAM stream, calling fn
+// on each record that is successfully decoded. When a record cannot be
+// decoded, it is counted as bad and the Reader is resynchronised to the
+// start of the next BGZF block using bgzf.Reader.Resync, skipping any
+// data remaining in the current block. Since a block may start within a
+// record, records following a resynchronisation may also fail to decode;
+// recovery is exact when records begin at block boundaries. ScanTolerant
+// returns the number of good and bad records, and a non-nil error only if
+// the stream cannot be resynchronised, for example when the underlying
+// io.Reader is not an io.ReadSeeker.
+func (br *Reader) ScanTolerant(fn func(*sam.Record)) (good, bad int, err error) {
+	for {
+		rec, err := br.Read()
+		switch err {
+		case nil:
+			good++
+			fn(rec)
+			continue
+		case io.EOF:
+			return good, bad, nil
+		}
+		bad++
+		last := int64(-1)
+		for {
+			off, err := br.r.Resync()
+			if err == nil {
+				break
+			}
+			if err == io.EOF {
+				return good, bad, nil
+			}
+			if err == bgzf.ErrNotASeeker || off.File <= last {
+				return good, bad, err
+			}
+			// The found member could not be read,
+			// so continue scanning past it.
+			last = off.File
+		}
+	}
 }
 
 // SetCache sets the cache to be used by the Reader.
@@ -214,9 +580,49 @@ func (br *Reader) SetChunk(c *bgzf.Chunk) error {
 		}
 	}
 	br.c = c
+	br.chunks = nil
 	return nil
 }
 
+// SetChunks sets a list of limited ranges of the underlying BGZF file to
+// read, after seeking to the start of the first chunk. When the end of a
+// chunk is reached, Read, ReadRaw and FlagStats seek to the start of the
+// next chunk, returning io.EOF only after the last chunk is exhausted.
+// Chunks are read in the order given, so SetChunks may be used to read
+// the chunks returned by an index query without an Iterator. If chunks
+// is empty, the Reader is not limited, as for SetChunk(nil).
+func (br *Reader) SetChunks(chunks []bgzf.Chunk) error {
+	if len(chunks) == 0 {
+		return br.SetChunk(nil)
+	}
+	chunks = append([]bgzf.Chunk(nil), chunks...)
+	err := br.SetChunk(&chunks[0])
+	if err != nil {
+		return err
+	}
+	br.chunks = chunks[1:]
+	return nil
+}
+
+// chunkEnd returns whether the Reader has reached the end of its current
+// chunk and there are no further chunks set by SetChunks. If the current
+// chunk is exhausted and another is available, the Reader seeks to the
+// start of the next chunk.
+func (br *Reader) chunkEnd() (bool, error) {
+	for br.c != nil && vOffset(br.r.LastChunk().End) >= vOffset(br.c.End) {
+		if len(br.chunks) == 0 {
+			return true, nil
+		}
+		err := br.r.Seek(br.chunks[0].Begin)
+		if err != nil {
+			return false, err
+		}
+		br.c = &br.chunks[0]
+		br.chunks = br.chunks[1:]
+	}
+	return false, nil
+}
+
 // LastChunk returns the bgzf.Chunk corresponding to the last Read operation.
 // The bgzf.Chunk returned is only valid if the last Read operation returned a
 // nil error.
@@ -229,6 +635,55 @@ func (br *Reader) Close() error {
 	return br.r.Close()
 }
 
+// Coverage returns the per-base depth of coverage over the half-open interval
+// [beg, end) of ref, using idx to find the overlapping records. Only the
+// reference positions of CIGAR match, sequence match and sequence mismatch
+// operations of mapped records are counted, so deletions and skipped regions
+// do not contribute. Element i of the returned slice holds the depth at
+// position beg+i. A region beyond the last indexed record has zero coverage.
+func (br *Reader) Coverage(idx index.Querier, ref *sam.Reference, beg, end int) ([]int, error) {
+	if beg < 0 || end < beg {
+		return nil, errors.New("bam: invalid coverage interval")
+	}
+	depth := make([]int, end-beg)
+	it, err := br.FetchMulti(idx, []Region{{Ref: ref, Beg: beg, End: end}})
+	if err != nil {
+		return nil, err
+	}
+	for it.Next() {
+		r := it.Record()
+		if r.Flags&sam.Unmapped != 0 {
+			continue
+		}
+		pos := r.Pos
+		for _, co := range r.Cigar {
+			n := co.Len()
+			switch co.Type() {
+			case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+				lo, hi := pos, pos+n
+				if lo < beg {
+					lo = beg
+				}
+				if hi > end {
+					hi = end
+				}
+				for p := lo; p < hi; p++ {
+					depth[p-beg]++
+				}
+			}
+			pos += n * co.Type().Consumes().Reference
+			if pos >= end {
+				break
+			}
+		}
+	}
+	err = it.Close()
+	if err != nil {
+		return nil, err
+	}
+	return depth, nil
+}
+
 // Iterator wraps a Reader to provide a convenient loop interface for reading BAM data.
 // Successive calls to the Next method will step through the features of the provided
 // Reader. Iteration stops unrecoverably at EOF or the first error.
@@ -237,12 +692,37 @@ type Iterator struct {
 
 	chunks []bgzf.Chunk
 
+	// regions holds the query regions
+	// corresponding to each of chunks
+	// and region is the region for the
+	// current chunk when filter is true.
+	regions []Region
+	region  Region
+	filter  bool
+
+	// skip holds the flags of records
+	// that are skipped by the Iterator.
+	skip sam.Flags
+
+	// owned specifies that r was created
+	// by the Iterator and is closed by
+	// Close.
+	owned bool
+
 	rec *sam.Record
 	err error
 }
 
+// Region is a half-open genomic interval [Beg, End) on a reference.
+type Region struct {
+	Ref      *sam.Reference
+	Beg, End int
+}
+
 // NewIterator returns a Iterator to read from r, limiting the reads to the provided
-// chunks.
+// chunks. If chunks is empty, all the remaining records in r are read, so no index
+// is required. This is the way to iterate over an unaligned BAM (uBAM), which
+// cannot be indexed since its records have no reference or position.
 //
 //	chunks, err := idx.Chunks(ref, beg, end)
 //	if err != nil {
@@ -268,21 +748,115 @@ func NewIterator(r *Reader, chunks []bgzf.Chunk) (*Iterator, error) {
 	return &Iterator{r: r, chunks: chunks}, nil
 }
 
+// PrimaryOnly returns an Iterator that reads the remaining records in br,
+// skipping secondary and supplementary alignments so that each read is
+// returned at most once. The fixed-length portion of each record is still
+// read to obtain its flags, but skipped records are not otherwise decoded.
+func (br *Reader) PrimaryOnly() *Iterator {
+	return &Iterator{r: br, skip: sam.Secondary | sam.Supplementary}
+}
+
+// FetchMulti returns an Iterator that reads the records in br that overlap
+// each of the provided regions, using idx to find the BGZF chunks to read.
+// Regions are visited in the order given and records are filtered to those
+// overlapping the region being visited. Records overlapping more than one
+// region are returned once for each region. Regions that the index reports
+// as holding no records are skipped. The Ref of each region must be a
+// Reference held by the Header of br.
+func (br *Reader) FetchMulti(idx index.Querier, regions []Region) (*Iterator, error) {
+	var (
+		chunks []bgzf.Chunk
+		owners []Region
+	)
+	for _, reg := range regions {
+		if reg.Ref == nil {
+			return nil, errors.New("bam: nil reference in region")
+		}
+		c, err := idx.Chunks(reg.Ref.ID(), reg.Beg, reg.End)
+		if err != nil {
+			if err == index.ErrInvalid {
+				// There are no records in the region.
+				continue
+			}
+			return nil, err
+		}
+		chunks = append(chunks, c...)
+		for range c {
+			owners = append(owners, reg)
+		}
+	}
+	if len(chunks) == 0 {
+		return &Iterator{r: br, err: io.EOF}, nil
+	}
+	err := br.SetChunk(&chunks[0])
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{
+		r:       br,
+		chunks:  chunks[1:],
+		regions: owners[1:],
+		region:  owners[0],
+		filter:  true,
+	}, nil
+}
+
+// NewRegionReader returns an Iterator over the records in the BAM data
+// read from r that overlap the half-open interval [beg, end) on ref. The
+// BAM header is read from r with read concurrency rd, as for NewReader,
+// and idx is used to find the BGZF chunks to read. The reference used
+// is the one in the BAM header with the same name as ref, so ref need
+// not be held by the header. The returned Iterator owns the underlying
+// Reader, which is closed by the Iterator's Close method.
+func NewRegionReader(r io.ReadSeeker, rd int, idx index.Querier, ref *sam.Reference, beg, end int) (*Iterator, error) {
+	if ref == nil {
+		return nil, errors.New("bam: nil reference in region")
+	}
+	br, err := NewReader(r, rd)
+	if err != nil {
+		return nil, err
+	}
+	hr, ok := br.Header().ReferenceByName(ref.Name())
+	if !ok {
+		br.Close()
+		return nil, fmt.Errorf("bam: reference %q not in header", ref.Name())
+	}
+	it, err := br.FetchMulti(idx, []Region{{Ref: hr, Beg: beg, End: end}})
+	if err != nil {
+		br.Close()
+		return nil, err
+	}
+	it.owned = true
+	return it, nil
+}
+
 // Next advances the Iterator past the next record, which will then be available through
 // the Record method. It returns false when the iteration stops, either by reaching the end of the
 // input or an error. After Next returns false, the Error method will return any error that
 // occurred during iteration, except that if it was io.EOF, Error will return nil.
 func (i *Iterator) Next() bool {
-	if i.err != nil {
-		return false
-	}
-	i.rec, i.err = i.r.Read()
-	if len(i.chunks) != 0 && i.err == io.EOF {
-		i.err = i.r.SetChunk(&i.chunks[0])
-		i.chunks = i.chunks[1:]
-		return i.Next()
+	for i.err == nil {
+		i.rec, i.err = i.r.read(i.skip)
+		if i.filter && i.err == nil && !i.rec.Overlaps(i.region.Ref, i.region.Beg, i.region.End) {
+			if i.rec.Ref != i.region.Ref || i.rec.Pos < i.region.End {
+				continue
+			}
+			// The record is beyond the end of the region,
+			// so there is nothing more to read in this chunk.
+			i.err = io.EOF
+		}
+		if len(i.chunks) != 0 && i.err == io.EOF {
+			i.err = i.r.SetChunk(&i.chunks[0])
+			i.chunks = i.chunks[1:]
+			if i.filter {
+				i.region = i.regions[0]
+				i.regions = i.regions[1:]
+			}
+			continue
+		}
+		return i.err == nil
 	}
-	return i.err == nil
+	return false
 }
 
 // Error returns the first non-EOF error that was encountered by the Iterator.
@@ -296,9 +870,16 @@ func (i *Iterator) Error() error {
 // Record returns the most recent record read by a call to Next.
 func (i *Iterator) Record() *sam.Record { return i.rec }
 
-// Close releases the underlying Reader.
+// Close releases the underlying Reader. If the Iterator was
+// returned by NewRegionReader, the Reader is also closed.
 func (i *Iterator) Close() error {
 	i.r.SetChunk(nil)
+	if i.owned {
+		err := i.r.Close()
+		if err != nil && i.Error() == nil {
+			return err
+		}
+	}
 	return i.Error()
 }
 
@@ -311,61 +892,6 @@ func readCigarOps(cb []byte) []sam.CigarOp {
 	return co
 }
 
-var jumps = [256]int{
-	'A': 1,
-	'c': 1, 'C': 1,
-	's': 2, 'S': 2,
-	'i': 4, 'I': 4,
-	'f': 4,
-	'Z': -1,
-	'H': -1,
-	'B': -1,
-}
-
-// parseAux examines the data of a SAM record's OPT fields,
-// returning a slice of sam.Aux that are backed by the original data.
-func parseAux(aux []byte) ([]sam.Aux, error) {
-	if len(aux) == 0 {
-		return nil, nil
-	}
-
-	// Heuristically pre-allocate enough slots for the byte data.
-	// Value chosen by experimentation and will not fit all inputs,
-	// with the cost being over-allocation.
-	aa := make([]sam.Aux, 0, len(aux)/4)
-
-	for i := 0; i+2 < len(aux); {
-		t := aux[i+2]
-		switch j := jumps[t]; {
-		case j > 0:
-			j += 3
-			aa = append(aa, sam.Aux(aux[i:i+j:i+j]))
-			i += j
-		case j < 0:
-			switch t {
-			case 'Z', 'H':
-				j := bytes.IndexByte(aux[i:], 0)
-				if j == -1 {
-					return nil, errors.New("bam: invalid zero terminated data: no zero")
-				}
-				aa = append(aa, sam.Aux(aux[i:i+j:i+j]))
-				i += j + 1
-			case 'B':
-				length := binary.LittleEndian.Uint32(aux[i+4 : i+8])
-				j = int(length)*jumps[aux[i+3]] + int(unsafe.Sizeof(length)) + 4
-				if j < 0 || i+j < 0 || i+j > len(aux) {
-					return nil, fmt.Errorf("bam: invalid array length for aux data: %d", length)
-				}
-				aa = append(aa, sam.Aux(aux[i:i+j:i+j]))
-				i += j
-			}
-		default:
-			return nil, fmt.Errorf("bam: unrecognised optional field type: %q", t)
-		}
-	}
-	return aa, nil
-}
-
 // buffer is light-weight read buffer.
 type buffer struct {
 	off    int
@@ -401,6 +927,33 @@ func (b *buffer) unsafeBytes(n int) []byte {
 	return b.data[s:b.off]
 }
 
+// flags returns the flags field of the BAM record held by the buffer
+// without advancing the buffer. If the buffer is too short to hold
+// the field, flags returns zero.
+func (b *buffer) flags() sam.Flags {
+	// Skip refID, pos, l_read_name, mapq, bin and n_cigar_op.
+	const off = 4 + 4 + 1 + 1 + 2 + 2
+	if len(b.data) < off+2 {
+		return 0
+	}
+	return sam.Flags(binary.LittleEndian.Uint16(b.data[off:]))
+}
+
+// refIDs returns the refID and next_refID fields of the BAM record held
+// by the buffer without advancing the buffer. If the buffer is too short
+// to hold the fields, ok is false.
+func (b *buffer) refIDs() (ref, mate int, ok bool) {
+	// Skip refID, pos, l_read_name, mapq, bin, n_cigar_op,
+	// flag and l_seq to reach next_refID.
+	const off = 4 + 4 + 1 + 1 + 2 + 2 + 2 + 4
+	if len(b.data) < off+4 {
+		return 0, 0, false
+	}
+	ref = int(int32(binary.LittleEndian.Uint32(b.data)))
+	mate = int(int32(binary.LittleEndian.Uint32(b.data[off:])))
+	return ref, mate, true
+}
+
 func (b *buffer) len() int {
 	return len(b.data) - b.off
 }
@@ -490,19 +1043,4 @@ func newBuffer(br *Reader) (*buffer, error) {
 	return b, nil
 }
 
-// buildAux constructs a single byte slice that represents a slice of sam.Aux.
-func buildAux(aa []sam.Aux) (aux []byte) {
-	for _, a := range aa {
-		// TODO: validate each 'a'
-		aux = append(aux, []byte(a)...)
-		switch a.Type() {
-		case 'Z', 'H':
-			aux = append(aux, 0)
-		}
-	}
-	return
-}
-
 type doublets []sam.Doublet
-
-func (np doublets) Bytes() []byte { return *(*[]byte)(unsafe.Pointer(&np)) }
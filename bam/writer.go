@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/sam"
@@ -72,7 +73,9 @@ func (bw *Writer) writeHeader(h *sam.Header) error {
 	return err
 }
 
-// Write writes r to the BAM stream.
+// Write writes r to the BAM stream. If r has more than 65535 CIGAR
+// operations, the CIGAR is written as a <readlen>S<reflen>N placeholder
+// and the complete CIGAR is stored in a CG:B,I auxiliary field.
 func (bw *Writer) Write(r *sam.Record) error {
 	if len(r.Name) == 0 || len(r.Name) > 254 {
 		return errors.New("bam: name absent or too long")
@@ -80,52 +83,30 @@ func (bw *Writer) Write(r *sam.Record) error {
 	if r.Qual != nil && len(r.Qual) != r.Seq.Length {
 		return errors.New("bam: sequence/quality length mismatch")
 	}
-	tags := buildAux(r.AuxFields)
-	recLen := bamFixedRemainder +
-		len(r.Name) + 1 + // Null terminated.
-		len(r.Cigar)<<2 + // CigarOps are 4 bytes.
-		len(r.Seq.Seq) +
-		r.Seq.Length +
-		len(tags)
-
 	bw.buf.Reset()
-	bin := binaryWriter{w: &bw.buf}
-
-	// Write record header data.
-	bin.writeInt32(int32(recLen))
-	bin.writeInt32(int32(r.Ref.ID()))
-	bin.writeInt32(int32(r.Pos))
-	bin.writeUint8(byte(len(r.Name) + 1))
-	bin.writeUint8(r.MapQ)
-	bin.writeUint16(uint16(r.Bin())) // r.bin
-	bin.writeUint16(uint16(len(r.Cigar)))
-	bin.writeUint16(uint16(r.Flags))
-	bin.writeInt32(int32(r.Seq.Length))
-	bin.writeInt32(int32(r.MateRef.ID()))
-	bin.writeInt32(int32(r.MatePos))
-	bin.writeInt32(int32(r.TempLen))
-
-	// Write variable length data.
-	bw.buf.WriteString(r.Name)
-	bw.buf.WriteByte(0)
-	writeCigarOps(&bin, r.Cigar)
-	bw.buf.Write(doublets(r.Seq.Seq).Bytes())
-	if r.Qual != nil {
-		bw.buf.Write(r.Qual)
-	} else {
-		for i := 0; i < r.Seq.Length; i++ {
-			bw.buf.WriteByte(0xff)
-		}
+	err := r.EncodeBinary(&bw.buf, int32(r.Ref.ID()), int32(r.MateRef.ID()))
+	if err != nil {
+		return err
 	}
-	bw.buf.Write(tags)
-	_, err := bw.bg.Write(bw.buf.Bytes())
+	_, err = bw.bg.Write(bw.buf.Bytes())
 	return err
 }
 
-func writeCigarOps(bin *binaryWriter, co []sam.CigarOp) {
-	for _, o := range co {
-		bin.writeUint32(uint32(o))
+// WriteRaw writes the pre-encoded BAM record p to the BAM stream. The
+// record must be complete, including the leading block size field, as
+// returned by Reader.ReadRaw. The record data is not otherwise validated,
+// so the caller must ensure that references in the record are consistent
+// with the Writer's header.
+func (bw *Writer) WriteRaw(p []byte) error {
+	if len(p) < lenFieldSize+bamFixedRemainder {
+		return errors.New("bam: raw record too short")
+	}
+	size := int32(binary.LittleEndian.Uint32(p))
+	if int(size) != len(p)-lenFieldSize {
+		return errors.New("bam: raw record block size mismatch")
 	}
+	_, err := bw.bg.Write(p)
+	return err
 }
 
 // Close closes the writer.
@@ -133,27 +114,196 @@ func (bw *Writer) Close() error {
 	return bw.bg.Close()
 }
 
-type binaryWriter struct {
-	w   *bytes.Buffer
-	buf [4]byte
+// IndexingWriter implements BAM data writing while building a BAI index
+// of the records written.
+type IndexingWriter struct {
+	w  *Writer
+	bw *blockRecorder
+
+	// pending holds records that have been
+	// written, but whose blocks have not yet
+	// been compressed.
+	pending []pendingRecord
+
+	// unplaced indicates that a record
+	// without a reference position has
+	// been written. All following records
+	// must also be unplaced.
+	unplaced bool
+
+	idx    *Index
+	err    error
+	closed bool
 }
 
-func (w *binaryWriter) writeUint8(v uint8) {
-	w.buf[0] = v
-	w.w.Write(w.buf[:1])
+// NewIndexingWriter returns a new IndexingWriter using the given SAM header.
+// Write concurrency is set to wc. Records must be written in coordinate
+// sorted order. The output written to w must not be further compressed, so
+// w may not be a *bgzf.Writer.
+func NewIndexingWriter(w io.Writer, h *sam.Header, wc int) (*IndexingWriter, error) {
+	if _, ok := w.(*bgzf.Writer); ok {
+		return nil, errors.New("bam: indexing writer cannot write to a bgzf.Writer")
+	}
+	bw := &blockRecorder{w: w}
+	bg, err := bgzf.NewWriterLevel(bw, gzip.DefaultCompression, wc)
+	if err != nil {
+		return nil, err
+	}
+	w2, err := NewWriterLevel(bg, h, gzip.DefaultCompression, wc)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexingWriter{
+		w:   w2,
+		bw:  bw,
+		idx: &Index{},
+	}, nil
 }
 
-func (w *binaryWriter) writeUint16(v uint16) {
-	binary.LittleEndian.PutUint16(w.buf[:2], v)
-	w.w.Write(w.buf[:2])
+// Write writes r to the BAM stream and records its location in the index.
+// Since the file offsets of records are only known after their blocks have
+// been compressed, an error from indexing a record may be returned by a
+// later call to Write or by Close. Unplaced records, those without a
+// reference position, are counted by the index's unmapped count and
+// must follow all placed records; an attempt to write a placed record
+// after an unplaced record returns an error without writing the record.
+func (w *IndexingWriter) Write(r *sam.Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	placed := isPlaced(r)
+	if placed && w.unplaced {
+		return errors.New("bam: placed record written after unplaced records")
+	}
+	begin, err := w.position()
+	if err != nil {
+		return err
+	}
+	err = w.w.Write(r)
+	if err != nil {
+		return err
+	}
+	last, err := w.position()
+	if err != nil {
+		return err
+	}
+	w.pending = append(w.pending, pendingRecord{
+		refID:  r.Ref.ID(),
+		start:  r.Start(),
+		end:    r.End(),
+		bin:    uint32(r.Bin()),
+		placed: placed,
+		mapped: isMapped(r),
+		begin:  begin,
+		last:   last,
+	})
+	w.unplaced = !placed
+	w.err = w.resolve()
+	return w.err
+}
+
+// position returns the block position of the next write to the BGZF
+// stream. A record starting at the end of a block is located at that
+// position, which is equivalent to the start of the following block.
+func (w *IndexingWriter) position() (blockPos, error) {
+	block, off, err := w.w.bg.Position()
+	return blockPos{block: block, off: off}, err
+}
+
+// resolve adds pending records whose blocks have been written to the index.
+func (w *IndexingWriter) resolve() error {
+	offsets := w.bw.offsets()
+	var i int
+	for ; i < len(w.pending); i++ {
+		p := w.pending[i]
+		if p.last.block >= int64(len(offsets)) {
+			break
+		}
+		c := bgzf.Chunk{
+			Begin: bgzf.Offset{File: offsets[p.begin.block], Block: uint16(p.begin.off)},
+			End:   bgzf.Offset{File: offsets[p.last.block], Block: uint16(p.last.off)},
+		}
+		err := w.idx.idx.Add(p, p.bin, c, p.placed, p.mapped)
+		if err != nil {
+			return err
+		}
+	}
+	w.pending = w.pending[:copy(w.pending, w.pending[i:])]
+	return nil
+}
+
+// Close closes the IndexingWriter and completes the index.
+func (w *IndexingWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	err := w.w.Close()
+	if w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.resolve()
+	if w.err == nil && len(w.pending) != 0 {
+		w.err = errors.New("bam: unresolved records in index")
+	}
+	return w.err
+}
+
+// Index returns the BAI index of the records written. It returns nil if
+// the IndexingWriter has not been closed or if an error occurred.
+func (w *IndexingWriter) Index() *Index {
+	if !w.closed || w.err != nil {
+		return nil
+	}
+	return w.idx
+}
+
+// blockPos is a position within the uncompressed
+// data of a BGZF block identified by its ordinal.
+type blockPos struct {
+	block int64
+	off   int
+}
+
+// pendingRecord holds the indexing information for a
+// record whose chunk has not yet been resolved.
+type pendingRecord struct {
+	refID, start, end int
+	bin               uint32
+	placed, mapped    bool
+	begin, last       blockPos
+}
+
+func (r pendingRecord) RefID() int { return r.refID }
+func (r pendingRecord) Start() int { return r.start }
+func (r pendingRecord) End() int   { return r.end }
+
+// blockRecorder records the file offset of each BGZF block written
+// to w. It depends on bgzf.Writer writing each block with a single
+// Write call.
+type blockRecorder struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	n     int64
+	start []int64
 }
 
-func (w *binaryWriter) writeInt32(v int32) {
-	binary.LittleEndian.PutUint32(w.buf[:4], uint32(v))
-	w.w.Write(w.buf[:4])
+func (r *blockRecorder) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	r.mu.Lock()
+	r.start = append(r.start, r.n)
+	r.n += int64(n)
+	r.mu.Unlock()
+	return n, err
 }
 
-func (w *binaryWriter) writeUint32(v uint32) {
-	binary.LittleEndian.PutUint32(w.buf[:4], v)
-	w.w.Write(w.buf[:4])
+// offsets returns the file offsets of the blocks written.
+func (r *blockRecorder) offsets() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.start[:len(r.start):len(r.start)]
 }
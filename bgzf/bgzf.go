@@ -9,6 +9,8 @@
 package bgzf
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
 	"io"
 	"os"
@@ -46,7 +48,7 @@ func init() {
 var (
 	ErrClosed            = errors.New("bgzf: use of closed writer")
 	ErrCorrupt           = errors.New("bgzf: corrupt block")
-	ErrBlockOverflow     = errors.New("bgzf: block overflow")
+	ErrBlockOverflow     = errors.New("bgzf: block size exceeded")
 	ErrWrongFileType     = errors.New("bgzf: file is a directory")
 	ErrNoEnd             = errors.New("bgzf: cannot determine offset from end")
 	ErrNotASeeker        = errors.New("bgzf: not a seeker")
@@ -103,3 +105,83 @@ func HasEOF(r io.ReaderAt) (bool, error) {
 	}
 	return true, nil
 }
+
+// DecodeBlock decompresses the single BGZF member held in member and
+// returns its payload and gzip header. The member's BC extra subfield
+// must be present and agree with len(member); ErrNoBlockSize and
+// ErrBlockSizeMismatch are returned otherwise. Data following the end
+// of the gzip member is reported as ErrCorrupt.
+func DecodeBlock(member []byte) ([]byte, gzip.Header, error) {
+	r := bytes.NewReader(member)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, gzip.Header{}, err
+	}
+	gz.Multistream(false)
+	h := gz.Header
+	size := expectedMemberSize(h)
+	if size < 0 {
+		return nil, h, ErrNoBlockSize
+	}
+	if size != len(member) {
+		return nil, h, ErrBlockSizeMismatch
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, h, err
+	}
+	if r.Len() != 0 {
+		return nil, h, ErrCorrupt
+	}
+	return b, h, nil
+}
+
+// IsBGZF reports whether the stream read from r starts with a BGZF member,
+// that is a gzip member with a BC extra subfield. The gzip header bytes
+// consumed from r are re-yielded by the returned io.Reader, followed by
+// the remainder of r, so the returned reader can be passed to NewReader
+// or gzip.NewReader. Streams that are too short to hold a gzip header are
+// reported as not BGZF without error.
+func IsBGZF(r io.Reader) (bool, io.Reader, error) {
+	var buf bytes.Buffer
+	tr := io.TeeReader(r, &buf)
+	result := func(ok bool, err error) (bool, io.Reader, error) {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return ok, io.MultiReader(bytes.NewReader(buf.Bytes()), r), err
+	}
+
+	// Fixed gzip header: ID1 ID2 CM FLG MTIME(4) XFL OS.
+	var hdr [12]byte
+	_, err := io.ReadFull(tr, hdr[:10])
+	if err != nil {
+		return result(false, err)
+	}
+	const fextra = 1 << 2
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3]&fextra == 0 {
+		return result(false, nil)
+	}
+
+	// Extra field: XLEN followed by subfields SI1 SI2 SLEN data.
+	_, err = io.ReadFull(tr, hdr[10:12])
+	if err != nil {
+		return result(false, err)
+	}
+	extra := make([]byte, int(hdr[10])|int(hdr[11])<<8)
+	_, err = io.ReadFull(tr, extra)
+	if err != nil {
+		return result(false, err)
+	}
+	for len(extra) >= 4 {
+		slen := int(extra[2]) | int(extra[3])<<8
+		if 4+slen > len(extra) {
+			break
+		}
+		if extra[0] == 'B' && extra[1] == 'C' && slen == 2 {
+			return result(true, nil)
+		}
+		extra = extra[4+slen:]
+	}
+	return result(false, nil)
+}
@@ -10,16 +10,20 @@ package bgzf_test
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -1244,6 +1248,159 @@ func TestSeekCacheReadahead(t *testing.T) {
 	}
 }
 
+func TestSetMaxBlockSize(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	large := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(large)
+	var second int
+	for i, b := range [][]byte{[]byte("first block"), large} {
+		_, err := w.Write(b)
+		if err != nil {
+			t.Fatalf("unexpected error writing block: %v", err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing block: %v", err)
+		}
+		err = w.Wait()
+		if err != nil {
+			t.Fatalf("unexpected error waiting for block: %v", err)
+		}
+		if i == 0 {
+			second = buf.Len()
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	// Declare an oversized block size for the second member.
+	tampered := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint16(tampered[second+16:], MaxBlockSize-1)
+
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		max     int
+		corrupt bool
+	}{
+		{name: "default", data: buf.Bytes(), max: 0, corrupt: false},
+		{name: "large", data: buf.Bytes(), max: MaxBlockSize + 1, corrupt: false},
+		{name: "limited", data: buf.Bytes(), max: 1024, corrupt: true},
+		{name: "tampered", data: tampered, max: 8192, corrupt: true},
+	} {
+		r, err := NewReader(bytes.NewReader(test.data), 1)
+		if err != nil {
+			t.Fatalf("unexpected error opening reader for %s: %v", test.name, err)
+		}
+		r.SetMaxBlockSize(test.max)
+		got, err := io.ReadAll(r)
+		if test.corrupt {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected corrupt error for %s: got:%v", test.name, err)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", test.name, err)
+			}
+			if want := append([]byte("first block"), large...); !bytes.Equal(got, want) {
+				t.Errorf("unexpected data for %s", test.name)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	for _, b := range []string{"first block", "second block"} {
+		_, err := w.Write([]byte(b))
+		if err != nil {
+			t.Fatalf("unexpected error writing block: %v", err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing block: %v", err)
+		}
+		err = w.Wait()
+		if err != nil {
+			t.Fatalf("unexpected error waiting for block: %v", err)
+		}
+	}
+	end := buf.Len()
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	// Tamper with the ISIZE field of the second member.
+	data := buf.Bytes()
+	data[end-4]++
+
+	for _, verify := range []bool{false, true} {
+		r, err := NewReader(bytes.NewReader(data), 1)
+		if err != nil {
+			t.Fatalf("unexpected error opening reader: %v", err)
+		}
+		r.SetVerify(verify)
+		_, err = io.ReadAll(r)
+		if err == nil {
+			t.Errorf("expected error reading tampered data with verify=%t", verify)
+		}
+		if got := errors.Is(err, ErrCorrupt); got != verify {
+			t.Errorf("unexpected error for verify=%t: got:%v", verify, err)
+		}
+		r.Close()
+	}
+}
+
+func TestWriterStats(t *testing.T) {
+	const flushes = 5
+	block := bytes.Repeat([]byte("repeated"), 50)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 4)
+	for i := 0; i < flushes; i++ {
+		_, err := w.Write(block)
+		if err != nil {
+			t.Fatalf("unexpected error writing block: %v", err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing block: %v", err)
+		}
+	}
+	err := w.Wait()
+	if err != nil {
+		t.Fatalf("unexpected error waiting for writes: %v", err)
+	}
+	stats := w.Stats()
+	if stats.Blocks != flushes {
+		t.Errorf("unexpected number of blocks before close: got:%d want:%d", stats.Blocks, flushes)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	stats = w.Stats()
+	if want := int64(flushes + 1); stats.Blocks != want {
+		t.Errorf("unexpected number of blocks: got:%d want:%d", stats.Blocks, want)
+	}
+	if want := int64(flushes * len(block)); stats.Uncompressed != want {
+		t.Errorf("unexpected number of uncompressed bytes: got:%d want:%d", stats.Uncompressed, want)
+	}
+	if want := int64(buf.Len() - len(MagicBlock)); stats.Compressed != want {
+		t.Errorf("unexpected number of compressed bytes: got:%d want:%d", stats.Compressed, want)
+	}
+	if want := float64(stats.Uncompressed) / float64(stats.Compressed); stats.Ratio != want {
+		t.Errorf("unexpected compression ratio: got:%f want:%f", stats.Ratio, want)
+	}
+}
+
 func BenchmarkWrite(b *testing.B) {
 	bg := NewWriter(io.Discard, *conc)
 	block := bytes.Repeat([]byte("repeated"), 50)
@@ -1286,3 +1443,1489 @@ func BenchmarkRead(b *testing.B) {
 		bg.Close()
 	}
 }
+
+func TestIsBGZF(t *testing.T) {
+	const text = "Hello, BGZF"
+
+	var bgz bytes.Buffer
+	w := NewWriter(&bgz, 1)
+	_, err := io.WriteString(w, text)
+	if err != nil {
+		t.Fatalf("unexpected error writing BGZF: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing BGZF writer: %v", err)
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Extra = []byte("XY\x02\x00ab")
+	_, err = io.WriteString(gw, text)
+	if err != nil {
+		t.Fatalf("unexpected error writing gzip: %v", err)
+	}
+	err = gw.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing gzip writer: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "bgzf", data: bgz.Bytes(), want: true},
+		{name: "gzip", data: gz.Bytes(), want: false},
+		{name: "short", data: []byte("\x1f\x8b"), want: false},
+	} {
+		ok, r, err := IsBGZF(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		if ok != test.want {
+			t.Errorf("unexpected result for %s: got:%t want:%t", test.name, ok, test.want)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("unexpected error reading %s: %v", test.name, err)
+		}
+		if !bytes.Equal(b, test.data) {
+			t.Errorf("returned reader for %s did not re-yield the stream", test.name)
+		}
+		if test.name == "short" {
+			continue
+		}
+
+		if ok {
+			br, err := NewReader(bytes.NewReader(b), 1)
+			if err != nil {
+				t.Fatalf("unexpected error opening %s: %v", test.name, err)
+			}
+			got, err := io.ReadAll(br)
+			if err != nil || string(got) != text {
+				t.Errorf("unexpected BGZF content for %s: got:%q want:%q err:%v", test.name, got, text, err)
+			}
+			br.Close()
+		} else {
+			gr, err := gzip.NewReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("unexpected error opening %s: %v", test.name, err)
+			}
+			got, err := io.ReadAll(gr)
+			if err != nil || string(got) != text {
+				t.Errorf("unexpected gzip content for %s: got:%q want:%q err:%v", test.name, got, text, err)
+			}
+		}
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+
+	_, err := w.Write(make([]byte, 100))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+
+	n, err := w.WriteBlock(make([]byte, BlockSize+1))
+	if err != ErrBlockOverflow {
+		t.Errorf("unexpected error for overflowing block: got:%v want:%v", err, ErrBlockOverflow)
+	}
+	if n != 0 {
+		t.Errorf("unexpected write length for overflowing block: got:%d want:0", n)
+	}
+	next, err := w.Next()
+	if err != nil {
+		t.Fatalf("unexpected error after overflowing block: %v", err)
+	}
+	if next != 100 {
+		t.Errorf("unexpected next offset after overflowing block: got:%d want:100", next)
+	}
+
+	b := bytes.Repeat([]byte{'a'}, BlockSize-50)
+	n, err = w.WriteBlock(b)
+	if err != nil {
+		t.Fatalf("unexpected error writing block: %v", err)
+	}
+	if n != len(b) {
+		t.Errorf("unexpected write length: got:%d want:%d", n, len(b))
+	}
+	next, err = w.Next()
+	if err != nil {
+		t.Fatalf("unexpected error after writing block: %v", err)
+	}
+	if next != len(b) {
+		t.Errorf("block was not written atomically: next offset got:%d want:%d", next, len(b))
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	if got := w.Stats().Blocks; got != 2 {
+		t.Errorf("unexpected number of blocks: got:%d want:2", got)
+	}
+}
+
+func TestResync(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	_, err := io.WriteString(w, "first")
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("unexpected error flushing data: %v", err)
+	}
+	err = w.Wait()
+	if err != nil {
+		t.Fatalf("unexpected error waiting for writer: %v", err)
+	}
+	first := buf.Len()
+	_, err = io.WriteString(w, "second")
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	garbage := []byte("\x1f\x8bgarbage between members\x1f\x8b\x08\x04")
+	data := append(append(append([]byte(nil), buf.Bytes()[:first]...), garbage...), buf.Bytes()[first:]...)
+	want := int64(first + len(garbage))
+
+	for _, conc := range []int{1, 2} {
+		r, err := NewReader(bytes.NewReader(data), conc)
+		if err != nil {
+			t.Fatalf("unexpected error opening reader: %v", err)
+		}
+		p := make([]byte, len("first"))
+		_, err = io.ReadFull(r, p)
+		if err != nil {
+			t.Fatalf("unexpected error reading first member: %v", err)
+		}
+		if string(p) != "first" {
+			t.Errorf("unexpected first member data: got:%q want:%q", p, "first")
+		}
+		_, err = r.Read(p)
+		if err == nil {
+			t.Errorf("expected error reading garbage with concurrency %d", conc)
+		}
+
+		off, err := r.Resync()
+		if err != nil {
+			t.Fatalf("unexpected error resyncing with concurrency %d: %v", conc, err)
+		}
+		if off != (Offset{File: want}) {
+			t.Errorf("unexpected resync offset with concurrency %d: got:%+v want:%+v", conc, off, Offset{File: want})
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Errorf("unexpected error reading after resync with concurrency %d: %v", conc, err)
+		}
+		if string(got) != "second" {
+			t.Errorf("unexpected data after resync with concurrency %d: got:%q want:%q", conc, got, "second")
+		}
+
+		_, err = r.Resync()
+		if err != io.EOF {
+			t.Errorf("unexpected error resyncing at end of stream with concurrency %d: got:%v want:%v", conc, err, io.EOF)
+		}
+		r.Close()
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		want bytes.Buffer
+	)
+	w := NewWriter(&buf, 1)
+	for i := 0; i < 200; i++ {
+		line := fmt.Sprintf("block %03d\n", i)
+		want.WriteString(line)
+		_, err := io.WriteString(w, line)
+		if err != nil {
+			t.Fatalf("unexpected error writing data: %v", err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing data: %v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	data := buf.Bytes()
+
+	for _, conc := range []int{1, 2} {
+		for _, n := range []int{1, 3, 7, 64} {
+			var got bytes.Buffer
+			for i := 0; i < n; i++ {
+				beg := int64(len(data) * i / n)
+				end := int64(len(data) * (i + 1) / n)
+
+				r, err := NewReader(bytes.NewReader(data), conc)
+				if err != nil {
+					t.Fatalf("unexpected error opening reader: %v", err)
+				}
+				off, err := r.SkipTo(beg)
+				if err == io.EOF {
+					r.Close()
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error skipping to %d with concurrency %d: %v", beg, conc, err)
+				}
+				if off.File < beg || off.Block != 0 {
+					t.Errorf("unexpected offset skipping to %d with concurrency %d: got:%+v", beg, conc, off)
+				}
+				if beg == 0 && off.File != 0 {
+					t.Errorf("unexpected offset skipping to start with concurrency %d: got:%+v", conc, off)
+				}
+				for {
+					b, err := r.ReadByte()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("unexpected error reading range [%d,%d) with concurrency %d: %v", beg, end, conc, err)
+					}
+					if r.LastChunk().Begin.File >= end {
+						break
+					}
+					got.WriteByte(b)
+				}
+				r.Close()
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("unexpected data from %d ranges with concurrency %d:\ngot: %q\nwant:%q", n, conc, got.Bytes(), want.Bytes())
+			}
+		}
+	}
+
+	r, err := NewReader(bytes.NewReader(data), 1)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	defer r.Close()
+	_, err = r.SkipTo(int64(len(data)) - int64(len(MagicBlock)) + 1)
+	if err != io.EOF {
+		t.Errorf("unexpected error skipping past last member: got:%v want:%v", err, io.EOF)
+	}
+	_, err = r.SkipTo(-1)
+	if err == nil {
+		t.Error("expected error skipping to negative offset")
+	}
+}
+
+func BenchmarkReadManyBlocks(b *testing.B) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	block := bytes.Repeat([]byte("repeated"), 50)
+	for i := 0; i < 10000; i++ {
+		w.Write(block)
+		if i%10 == 0 {
+			w.Flush()
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		b.Fatalf("bgzf write failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	p := make([]byte, 16384)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bg, err := NewReader(bytes.NewReader(data), 4)
+		if err != nil {
+			b.Fatalf("bgzf open failed: %v", err)
+		}
+		for {
+			_, err = bg.Read(p)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatalf("bgzf read failed: %v", err)
+			}
+		}
+		bg.Close()
+	}
+}
+
+func TestWriteNoBlockSize(t *testing.T) {
+	const data = "plain gzip member without a BC field"
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, *conc)
+	w.NoBlockSize = true
+	_, err := w.Write([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error opening gzip reader: %v", err)
+	}
+	if bytes.Contains(gz.Header.Extra, []byte("BC")) {
+		t.Errorf("unexpected BC subfield in gzip header extra: %q", gz.Header.Extra)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("unexpected error reading gzip stream: %v", err)
+	}
+	if string(got) != data {
+		t.Errorf("unexpected gzip data: got:%q want:%q", got, data)
+	}
+
+	_, err = NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	if err != ErrNoBlockSize {
+		t.Errorf("unexpected error opening BGZF reader: got:%v want:%v", err, ErrNoBlockSize)
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	const data = "data held in a single BGZF member"
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, *conc)
+	w.Comment = "comment"
+	_, err := w.Write([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	member := buf.Bytes()[:buf.Len()-len(MagicBlock)]
+
+	got, h, err := DecodeBlock(member)
+	if err != nil {
+		t.Fatalf("unexpected error decoding block: %v", err)
+	}
+	if string(got) != data {
+		t.Errorf("unexpected payload: got:%q want:%q", got, data)
+	}
+	if h.Comment != "comment" {
+		t.Errorf("unexpected header comment: got:%q want:%q", h.Comment, "comment")
+	}
+
+	got, _, err = DecodeBlock([]byte(MagicBlock))
+	if err != nil {
+		t.Errorf("unexpected error decoding EOF block: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("unexpected payload for EOF block: %q", got)
+	}
+
+	_, _, err = DecodeBlock(buf.Bytes())
+	if err != ErrBlockSizeMismatch {
+		t.Errorf("unexpected error for trailing data: got:%v want:%v", err, ErrBlockSizeMismatch)
+	}
+
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	gz.Write([]byte(data))
+	gz.Close()
+	_, _, err = DecodeBlock(plain.Bytes())
+	if err != ErrNoBlockSize {
+		t.Errorf("unexpected error for plain gzip member: got:%v want:%v", err, ErrNoBlockSize)
+	}
+}
+
+// blockPayloads returns the decompressed payloads of the BGZF members
+// in b, excluding the magic EOF block. Members are walked using the
+// BSIZE field, which follows the fixed header and XLEN, SI1, SI2 and
+// SLEN fields of members written by a Writer.
+func blockPayloads(t *testing.T, b []byte) [][]byte {
+	var payloads [][]byte
+	b = b[:len(b)-len(MagicBlock)]
+	for len(b) != 0 {
+		size := int(b[16]) | int(b[17])<<8 + 1
+		payload, _, err := DecodeBlock(b[:size])
+		if err != nil {
+			t.Fatalf("unexpected error decoding block %d: %v", len(payloads), err)
+		}
+		payloads = append(payloads, payload)
+		b = b[size:]
+	}
+	return payloads
+}
+
+func TestWriterConfig(t *testing.T) {
+	const (
+		blockSize = 1000
+		writeSize = 300
+		writes    = 40
+	)
+	data := make([]byte, writeSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, flush := range []bool{false, true} {
+		var buf bytes.Buffer
+		w, err := NewWriterConfig(&buf, WriterConfig{
+			Level:       gzip.DefaultCompression,
+			Concurrency: *conc,
+			BlockSize:   blockSize,
+			FlushWrites: flush,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error creating writer: %v", err)
+		}
+		for i := 0; i < writes; i++ {
+			_, err = w.Write(data)
+			if err != nil {
+				t.Fatalf("unexpected error writing data: %v", err)
+			}
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing writer: %v", err)
+		}
+
+		payloads := blockPayloads(t, buf.Bytes())
+		var blocks, total int
+		for i, payload := range payloads {
+			if len(payload) > blockSize {
+				t.Errorf("block %d exceeds configured size: %d > %d", i, len(payload), blockSize)
+			}
+			// Close writes the active block even when it is empty.
+			if flush && len(payload) != writeSize && i != len(payloads)-1 {
+				t.Errorf("unexpected block size with flushed writes: got:%d want:%d", len(payload), writeSize)
+			}
+			blocks++
+			total += len(payload)
+		}
+		if total != writes*writeSize {
+			t.Errorf("unexpected total data length: got:%d want:%d", total, writes*writeSize)
+		}
+		perBlock := blockSize / writeSize * writeSize
+		want := (writes*writeSize + perBlock - 1) / perBlock
+		if flush {
+			want = writes + 1
+		}
+		if blocks != want {
+			t.Errorf("unexpected number of blocks with FlushWrites=%t: got:%d want:%d", flush, blocks, want)
+		}
+	}
+
+	for _, size := range []int{-1, BlockSize + 1} {
+		_, err := NewWriterConfig(io.Discard, WriterConfig{BlockSize: size})
+		if err == nil {
+			t.Errorf("expected error for block size %d", size)
+		}
+	}
+}
+
+func TestReaderConcurrency(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	_, err := w.Write([]byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	for _, test := range []struct {
+		rd   int
+		want int
+	}{
+		{rd: 0, want: runtime.GOMAXPROCS(0)},
+		{rd: -1, want: 1},
+		{rd: 1, want: 1},
+		{rd: 2, want: 2},
+		{rd: 8, want: 8},
+	} {
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), test.rd)
+		if err != nil {
+			t.Fatalf("unexpected error creating reader: %v", err)
+		}
+		if got := r.Concurrency(); got != test.want {
+			t.Errorf("unexpected concurrency for rd=%d: got:%d want:%d", test.rd, got, test.want)
+		}
+		r.Close()
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	const (
+		blocks = 64
+		rd     = 8
+	)
+	var (
+		buf     bytes.Buffer
+		offsets []int64
+	)
+	w := NewWriter(&buf, 1)
+	for i := 0; i < blocks; i++ {
+		offsets = append(offsets, int64(buf.Len()))
+		fmt.Fprintf(w, "block %d", i)
+		err := w.Flush()
+		if err == nil {
+			err = w.Wait()
+		}
+		if err != nil {
+			t.Fatalf("unexpected error flushing block: %v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), rd)
+	if err != nil {
+		t.Fatalf("unexpected error creating reader: %v", err)
+	}
+	r.SetCache(cache.NewLRU(4))
+	r.SetMemoryLimit(1)
+
+	done := make(chan error)
+	go func() {
+		defer close(done)
+		b, err := io.ReadAll(r)
+		if err != nil {
+			done <- fmt.Errorf("unexpected error reading: %v", err)
+			return
+		}
+		if want := blocks; bytes.Count(b, []byte("block")) != want {
+			done <- fmt.Errorf("unexpected number of blocks read: got:%d want:%d", bytes.Count(b, []byte("block")), want)
+			return
+		}
+		if got := ActiveDecompressors(r); got != 2 {
+			done <- fmt.Errorf("unexpected number of active decompressors: got:%d want:2", got)
+			return
+		}
+		for _, i := range []int{blocks / 2, 0, blocks - 1, 1} {
+			err = r.Seek(Offset{File: offsets[i]})
+			if err != nil {
+				done <- fmt.Errorf("unexpected error seeking to block %d: %v", i, err)
+				return
+			}
+			p := make([]byte, len(fmt.Sprintf("block %d", i)))
+			_, err = io.ReadFull(r, p)
+			if err != nil {
+				done <- fmt.Errorf("unexpected error reading block %d: %v", i, err)
+				return
+			}
+			if want := fmt.Sprintf("block %d", i); string(p) != want {
+				done <- fmt.Errorf("unexpected data: got:%q want:%q", p, want)
+				return
+			}
+		}
+
+		r.SetMemoryLimit(0)
+		_, err = io.ReadAll(r)
+		if err != nil {
+			done <- fmt.Errorf("unexpected error reading: %v", err)
+			return
+		}
+		done <- r.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("reader deadlocked under memory limit")
+	}
+}
+
+func TestReadBlockInto(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(w, "%s", bytes.Repeat([]byte{'a' + byte(i)}, 1000*i))
+		err := w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing block: %v", err)
+		}
+	}
+	_, err := w.Write(bytes.Repeat([]byte("acgt"), BlockSize))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	for _, rd := range []int{1, 4} {
+		// Collect blocks using the Blocked convention.
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), rd)
+		if err != nil {
+			t.Fatalf("unexpected error creating reader: %v", err)
+		}
+		r.Blocked = true
+		var (
+			want    [][]byte
+			offsets []Offset
+		)
+		p := make([]byte, MaxBlockSize+1)
+		for {
+			var (
+				b     []byte
+				begin Offset
+			)
+			for {
+				n, err := r.Read(p)
+				if b == nil {
+					begin = r.LastChunk().Begin
+				}
+				b = append(b, p[:n]...)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error reading: %v", err)
+				}
+			}
+			if len(b) == 0 {
+				break
+			}
+			want = append(want, b)
+			offsets = append(offsets, begin)
+		}
+		r.Close()
+
+		r, err = NewReader(bytes.NewReader(buf.Bytes()), rd)
+		if err != nil {
+			t.Fatalf("unexpected error creating reader: %v", err)
+		}
+		_, _, err = r.ReadBlockInto(make([]byte, MaxBlockSize-1))
+		if err != io.ErrShortBuffer {
+			t.Errorf("unexpected error for short buffer: got:%v want:%v", err, io.ErrShortBuffer)
+		}
+		p = make([]byte, MaxBlockSize)
+		var i int
+		for ; ; i++ {
+			n, off, err := r.ReadBlockInto(p)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error reading block %d: %v", i, err)
+			}
+			if i >= len(want) {
+				t.Fatalf("too many blocks read for rd=%d", rd)
+			}
+			if !bytes.Equal(p[:n], want[i]) {
+				t.Errorf("unexpected data for block %d with rd=%d: got len %d want len %d", i, rd, n, len(want[i]))
+			}
+			if off != offsets[i] {
+				t.Errorf("unexpected offset for block %d with rd=%d: got:%+v want:%+v", i, rd, off, offsets[i])
+			}
+		}
+		if i != len(want) {
+			t.Errorf("unexpected number of blocks for rd=%d: got:%d want:%d", rd, i, len(want))
+		}
+		r.Close()
+	}
+}
+
+func TestCopyBlock(t *testing.T) {
+	var (
+		srcs [2]bytes.Buffer
+		want bytes.Buffer
+	)
+	for i := range srcs {
+		w := NewWriter(&srcs[i], 1)
+		for j := 0; j < 10; j++ {
+			data := bytes.Repeat([]byte(fmt.Sprintf("file %d block %d;", i, j)), 100*j+1)
+			want.Write(data)
+			_, err := w.Write(data)
+			if err != nil {
+				t.Fatalf("unexpected error writing data: %v", err)
+			}
+			err = w.Flush()
+			if err != nil {
+				t.Fatalf("unexpected error flushing block: %v", err)
+			}
+		}
+		err := w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing writer: %v", err)
+		}
+	}
+
+	var (
+		buf     bytes.Buffer
+		members []byte
+		offsets []Offset
+	)
+	w := NewWriter(&buf, *conc)
+	for i := range srcs {
+		r, err := NewReader(bytes.NewReader(srcs[i].Bytes()), *conc)
+		if err != nil {
+			t.Fatalf("unexpected error creating reader: %v", err)
+		}
+		for {
+			off, err := w.CopyBlock(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error copying block: %v", err)
+			}
+			if off.File != int64(len(members)) {
+				t.Errorf("unexpected offset for copied block: got:%d want:%d", off.File, len(members))
+			}
+			offsets = append(offsets, off)
+			base := r.LastChunk().Begin.File
+			size := w.Stats().Compressed - off.File
+			members = append(members, srcs[i].Bytes()[base:base+size]...)
+		}
+		r.Close()
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	if len(offsets) != 20 {
+		t.Errorf("unexpected number of copied blocks: got:%d want:20", len(offsets))
+	}
+	if !bytes.HasPrefix(buf.Bytes(), members) {
+		t.Error("copied members not written verbatim")
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	if err != nil {
+		t.Fatalf("unexpected error creating reader: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading copied data: %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Error("unexpected decompressed data after copying blocks")
+	}
+	for i, off := range offsets {
+		err = r.Seek(off)
+		if err != nil {
+			t.Fatalf("unexpected error seeking to copied block %d: %v", i, err)
+		}
+		prefix := fmt.Sprintf("file %d block %d;", i/10, i%10)
+		p := make([]byte, len(prefix))
+		_, err = io.ReadFull(r, p)
+		if err != nil {
+			t.Fatalf("unexpected error reading copied block %d: %v", i, err)
+		}
+		if string(p) != prefix {
+			t.Errorf("unexpected data at copied block %d: got:%q want:%q", i, p, prefix)
+		}
+	}
+	r.Close()
+
+	_, err = w.CopyBlock(r)
+	if err != ErrClosed {
+		t.Errorf("unexpected error copying to closed writer: got:%v want:%v", err, ErrClosed)
+	}
+}
+
+type closeTracker struct {
+	*bytes.Reader
+	closed int
+}
+
+func (r *closeTracker) Close() error {
+	r.closed++
+	return nil
+}
+
+// countingCompressor is a Compressor that counts the
+// number of compressor resets and closes.
+type countingCompressor struct {
+	*flate.Writer
+	resets, closes *atomic.Int64
+}
+
+func (c countingCompressor) Reset(w io.Writer) {
+	c.resets.Add(1)
+	c.Writer.Reset(w)
+}
+
+func (c countingCompressor) Close() error {
+	c.closes.Add(1)
+	return c.Writer.Close()
+}
+
+func TestNewWriterWith(t *testing.T) {
+	var created, resets, closes atomic.Int64
+	newCompressor := func(w io.Writer, level int) (Compressor, error) {
+		created.Add(1)
+		fw, err := flate.NewWriter(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return countingCompressor{Writer: fw, resets: &resets, closes: &closes}, nil
+	}
+
+	data := bytes.Repeat([]byte("compressor hook "), 3*BlockSize/16)
+	var custom, std bytes.Buffer
+	w, err := NewWriterWith(&custom, gzip.BestSpeed, 1, newCompressor)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	blocks := w.Stats().Blocks
+	if created.Load() == 0 {
+		t.Error("custom compressor not used")
+	}
+	if got := created.Load() + resets.Load(); got != blocks {
+		t.Errorf("unexpected number of compressor uses: got:%d want:%d", got, blocks)
+	}
+	if got := closes.Load(); got != blocks {
+		t.Errorf("unexpected number of compressor closes: got:%d want:%d", got, blocks)
+	}
+
+	w, err = NewWriterLevel(&std, gzip.BestSpeed, 1)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	w.Write(data)
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	if !bytes.Equal(custom.Bytes(), std.Bytes()) {
+		t.Error("output from wrapped compressor differs from default compressor")
+	}
+
+	r, err := NewReader(bytes.NewReader(custom.Bytes()), *conc)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading data: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("unexpected round trip data")
+	}
+
+	errCompressor := errors.New("no compressor")
+	w, err = NewWriterWith(io.Discard, gzip.DefaultCompression, 1, func(io.Writer, int) (Compressor, error) {
+		return nil, errCompressor
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	w.Write([]byte("data"))
+	err = w.Close()
+	if err != errCompressor {
+		t.Errorf("unexpected error closing writer: got:%v want:%v", err, errCompressor)
+	}
+}
+
+func TestTell(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriterConfig(&buf, WriterConfig{Level: gzip.DefaultCompression, Concurrency: 1, BlockSize: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	var data []byte
+	for i := 0; i < 1000; i++ {
+		data = append(data, fmt.Sprintf("line %d\n", i)...)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	for _, rd := range []int{1, *conc} {
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), rd)
+		if err != nil {
+			t.Fatalf("unexpected error creating reader: %v", err)
+		}
+		// Read to positions within and at the ends of blocks.
+		for _, n := range []int{10, 990, 1500, 500} {
+			before := make([]byte, n)
+			_, err = io.ReadFull(r, before)
+			if err != nil {
+				t.Fatalf("unexpected error reading data: %v", err)
+			}
+			off := r.Tell()
+			if off != r.LastChunk().End {
+				t.Errorf("unexpected Tell offset: got:%+v want:%+v", off, r.LastChunk().End)
+			}
+			want := make([]byte, 100)
+			_, err = io.ReadFull(r, want)
+			if err != nil {
+				t.Fatalf("unexpected error reading data: %v", err)
+			}
+			err = r.Seek(off)
+			if err != nil {
+				t.Fatalf("unexpected error seeking to %+v: %v", off, err)
+			}
+			if r.Tell() != off {
+				t.Errorf("unexpected Tell offset after seek: got:%+v want:%+v", r.Tell(), off)
+			}
+			got := make([]byte, 100)
+			_, err = io.ReadFull(r, got)
+			if err != nil {
+				t.Fatalf("unexpected error reading data: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("unexpected data after seeking to %+v:\ngot: %q\nwant:%q", off, got, want)
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestBeginRecord(t *testing.T) {
+	const blockSize = 1000
+	var buf bytes.Buffer
+	w, err := NewWriterConfig(&buf, WriterConfig{Level: gzip.DefaultCompression, Concurrency: *conc, BlockSize: blockSize})
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	_, err = w.BeginRecord(blockSize + 1)
+	if err != ErrBlockOverflow {
+		t.Errorf("unexpected error for oversized record: got:%v want:%v", err, ErrBlockOverflow)
+	}
+
+	type record struct {
+		off  Offset
+		data []byte
+	}
+	var recs []record
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i%26)}, 1+rnd.Intn(300))
+		if i%50 == 0 {
+			data = bytes.Repeat([]byte{'z'}, blockSize)
+		}
+		off, err := w.BeginRecord(len(data))
+		if err != nil {
+			t.Fatalf("unexpected error beginning record %d: %v", i, err)
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			t.Fatalf("unexpected error writing record %d: %v", i, err)
+		}
+		recs = append(recs, record{off: off, data: data})
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+	if err != nil {
+		t.Fatalf("unexpected error creating reader: %v", err)
+	}
+	for i, rec := range recs {
+		err = r.Seek(rec.off)
+		if err != nil {
+			t.Fatalf("unexpected error seeking to record %d at %+v: %v", i, rec.off, err)
+		}
+		got := make([]byte, len(rec.data))
+		_, err = io.ReadFull(r, got)
+		if err != nil {
+			t.Fatalf("unexpected error reading record %d: %v", i, err)
+		}
+		if !bytes.Equal(got, rec.data) {
+			t.Errorf("unexpected data for record %d", i)
+		}
+		c := r.LastChunk()
+		if c.End.File != rec.off.File || int(c.End.Block) != int(rec.off.Block)+len(rec.data) {
+			t.Errorf("record %d straddles a block boundary: begin:%+v end:%+v", i, rec.off, c.End)
+		}
+	}
+	r.Close()
+}
+
+func TestSeekable(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	_, err := w.Write([]byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		r    io.Reader
+		want bool
+	}{
+		{name: "seekable", r: bytes.NewReader(buf.Bytes()), want: true},
+		{name: "non-seekable", r: struct{ io.Reader }{bytes.NewReader(buf.Bytes())}, want: false},
+	} {
+		r, err := NewReader(test.r, *conc)
+		if err != nil {
+			t.Fatalf("unexpected error creating %s reader: %v", test.name, err)
+		}
+		got := r.Seekable()
+		if got != test.want {
+			t.Errorf("unexpected Seekable result for %s reader: got:%t want:%t", test.name, got, test.want)
+		}
+		err = r.Seek(Offset{})
+		if got && err != nil {
+			t.Errorf("unexpected error seeking %s reader: %v", test.name, err)
+		}
+		if !got && err != ErrNotASeeker {
+			t.Errorf("unexpected error seeking %s reader: got:%v want:%v", test.name, err, ErrNotASeeker)
+		}
+		r.Close()
+	}
+}
+
+func TestSetCloseUnderlying(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	_, err := w.Write([]byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	for _, rd := range []int{1, 4} {
+		for _, set := range []bool{false, true} {
+			cr := &closeTracker{Reader: bytes.NewReader(buf.Bytes())}
+			r, err := NewReader(cr, rd)
+			if err != nil {
+				t.Fatalf("unexpected error creating reader: %v", err)
+			}
+			if set {
+				r.SetCloseUnderlying(true)
+			}
+			_, err = io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error reading: %v", err)
+			}
+			err = r.Close()
+			if err != nil {
+				t.Errorf("unexpected error closing reader: %v", err)
+			}
+			want := 0
+			if set {
+				want = 1
+			}
+			if cr.closed != want {
+				t.Errorf("unexpected number of underlying closes for rd=%d set=%t: got:%d want:%d", rd, set, cr.closed, want)
+			}
+		}
+	}
+}
+
+func TestAutoFlushBytes(t *testing.T) {
+	const (
+		flushSize = 4096
+		writeSize = 100
+		writes    = 1000
+	)
+	data := bytes.Repeat([]byte("acgt"), writeSize/4)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, *conc)
+	w.AutoFlushBytes = flushSize
+	for i := 0; i < writes; i++ {
+		_, err := w.Write(data)
+		if err != nil {
+			t.Fatalf("unexpected error writing data: %v", err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+
+	payloads := blockPayloads(t, buf.Bytes())
+	var total int
+	for i, payload := range payloads {
+		if len(payload) > flushSize {
+			t.Errorf("block %d exceeds auto flush size: %d > %d", i, len(payload), flushSize)
+		}
+		if i != len(payloads)-1 && len(payload) <= flushSize-writeSize {
+			t.Errorf("block %d unexpectedly small: %d", i, len(payload))
+		}
+		total += len(payload)
+	}
+	if total != writes*writeSize {
+		t.Errorf("unexpected total data length: got:%d want:%d", total, writes*writeSize)
+	}
+
+	_, err = NewWriter(io.Discard, 1).WriteBlock(make([]byte, flushSize+1))
+	if err != nil {
+		t.Errorf("unexpected error writing block without auto flush: %v", err)
+	}
+	w = NewWriter(io.Discard, 1)
+	w.AutoFlushBytes = flushSize
+	_, err = w.WriteBlock(make([]byte, flushSize+1))
+	if err != ErrBlockOverflow {
+		t.Errorf("unexpected error writing oversized block: got:%v want:%v", err, ErrBlockOverflow)
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w := NewWriter(io.Discard, *conc)
+	err := w.Reset(io.Discard)
+	if err == nil {
+		t.Error("expected error resetting unclosed writer")
+	}
+
+	var bufs [2]bytes.Buffer
+	for i := range bufs {
+		if i != 0 {
+			err = w.Reset(&bufs[i])
+			if err != nil {
+				t.Fatalf("unexpected error resetting writer: %v", err)
+			}
+		} else {
+			w = NewWriter(&bufs[i], *conc)
+		}
+		data := bytes.Repeat([]byte(fmt.Sprintf("output %d ", i)), 2*BlockSize/9)
+		_, err = w.Write(data)
+		if err != nil {
+			t.Fatalf("unexpected error writing output %d: %v", i, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing output %d: %v", i, err)
+		}
+		stats := w.Stats()
+		if stats.Uncompressed != int64(len(data)) {
+			t.Errorf("unexpected uncompressed size for output %d: got:%d want:%d", i, stats.Uncompressed, len(data))
+		}
+		if stats.Compressed != int64(bufs[i].Len()-len(MagicBlock)) {
+			t.Errorf("unexpected compressed size for output %d: got:%d want:%d", i, stats.Compressed, bufs[i].Len()-len(MagicBlock))
+		}
+
+		r, err := NewReader(bytes.NewReader(bufs[i].Bytes()), *conc)
+		if err != nil {
+			t.Fatalf("unexpected error opening output %d: %v", i, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error reading output %d: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("unexpected data for output %d", i)
+		}
+		if !bytes.HasSuffix(bufs[i].Bytes(), []byte(MagicBlock)) {
+			t.Errorf("missing magic block for output %d", i)
+		}
+	}
+
+	err = w.Reset(errorWriter{})
+	if err != nil {
+		t.Fatalf("unexpected error resetting writer: %v", err)
+	}
+	w.Write([]byte("data"))
+	if w.Close() == nil {
+		t.Error("expected error writing to failing writer")
+	}
+	var buf bytes.Buffer
+	err = w.Reset(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error resetting failed writer: %v", err)
+	}
+	_, err = w.Write([]byte("data"))
+	if err != nil {
+		t.Errorf("unexpected error writing after reset: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("unexpected error closing after reset: %v", err)
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestWriterCloseIdempotent(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, *conc)
+	_, err := w.Write([]byte("data"))
+	if err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	n := buf.Len()
+	err = w.Close()
+	if err != nil {
+		t.Errorf("unexpected error closing writer twice: %v", err)
+	}
+	if buf.Len() != n {
+		t.Errorf("unexpected write on second close: got %d bytes want %d", buf.Len(), n)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte(MagicBlock)) {
+		t.Error("missing magic block")
+	}
+
+	for _, wc := range []int{1, 2, *conc} {
+		w := NewWriter(errorWriter{}, wc)
+		data := make([]byte, 4*BlockSize)
+		_, err := w.Write(data)
+		if err == nil {
+			err = w.Wait()
+		}
+		if err == nil {
+			t.Fatalf("expected error writing to failing writer with concurrency %d", wc)
+		}
+		_, werr := w.Write(data)
+		if werr != err {
+			t.Errorf("unexpected error writing after failure with concurrency %d: got:%v want:%v", wc, werr, err)
+		}
+		for i := 0; i < 2; i++ {
+			cerr := w.Close()
+			if cerr != err {
+				t.Errorf("unexpected error from close %d with concurrency %d: got:%v want:%v", i, wc, cerr, err)
+			}
+		}
+	}
+}
+
+func TestPadToBlock(t *testing.T) {
+	for _, size := range []int{MinPadMember, MinPadMember + 1, 1000, MaxBlockSize} {
+		m := PadMember(size)
+		if len(m) != size {
+			t.Errorf("unexpected padding member length: got:%d want:%d", len(m), size)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(m))
+		if err != nil {
+			t.Errorf("unexpected error opening padding member of size %d: %v", size, err)
+			continue
+		}
+		if got := ExpectedMemberSize(gz.Header); got != size {
+			t.Errorf("unexpected block size for padding member: got:%d want:%d", got, size)
+		}
+		b, err := io.ReadAll(gz)
+		if err != nil || len(b) != 0 {
+			t.Errorf("unexpected result reading padding member of size %d: %q %v", size, b, err)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 100, 1000, BlockSize, 3 * BlockSize / 2, 5 * BlockSize} {
+		data := make([]byte, n)
+		rnd.Read(data[:n/2])
+		var buf bytes.Buffer
+		w := NewWriter(&buf, *conc)
+		w.PadToBlock = true
+		_, err := w.Write(data)
+		if err != nil {
+			t.Fatalf("unexpected error writing %d bytes: %v", n, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing writer for %d bytes: %v", n, err)
+		}
+		if buf.Len()%MaxBlockSize != 0 {
+			t.Errorf("unexpected output size for %d bytes: %d is not a multiple of %d", n, buf.Len(), MaxBlockSize)
+		}
+		ok, err := HasEOF(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("unexpected error checking EOF for %d bytes: %v", n, err)
+		}
+		if !ok {
+			t.Errorf("missing EOF block for %d bytes", n)
+		}
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), *conc)
+		if err != nil {
+			t.Fatalf("unexpected error opening reader for %d bytes: %v", n, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("unexpected error reading %d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("unexpected data read back for %d bytes", n)
+		}
+
+		// The padded output is also valid gzip.
+		gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("unexpected error opening gzip reader for %d bytes: %v", n, err)
+		}
+		got, err = io.ReadAll(gz)
+		if err != nil {
+			t.Errorf("unexpected error reading gzip for %d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("unexpected gzip data read back for %d bytes", n)
+		}
+	}
+}
+
+func TestWriterPosition(t *testing.T) {
+	type pos struct {
+		block int64
+		next  int
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 2)
+	w.AutoFlushBytes = 16
+	check := func(step string, want pos) {
+		t.Helper()
+		block, next, err := w.Position()
+		if err != nil {
+			t.Fatalf("unexpected error getting position after %s: %v", step, err)
+		}
+		if got := (pos{block, next}); got != want {
+			t.Errorf("unexpected position after %s: got:%+v want:%+v", step, got, want)
+		}
+	}
+
+	check("open", pos{0, 0})
+	_, err := w.Write(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	check("short write", pos{0, 10})
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("unexpected error flushing data: %v", err)
+	}
+	check("flush", pos{1, 0})
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("unexpected error flushing data: %v", err)
+	}
+	check("empty flush", pos{1, 0})
+	_, err = w.Write(make([]byte, 36))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	check("spanning write", pos{3, 4})
+	_, err = w.Write(make([]byte, 14))
+	if err != nil {
+		t.Fatalf("unexpected error writing data: %v", err)
+	}
+	check("overflowing write", pos{4, 14})
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	_, _, err = w.Position()
+	if err != ErrClosed {
+		t.Errorf("unexpected error getting position after close: got:%v want:%v", err, ErrClosed)
+	}
+
+	// Each counted block is a member of the output, followed
+	// by the final block and the magic EOF block.
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), 1)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	defer r.Close()
+	var members int64
+	for off := int64(0); ; members++ {
+		o, err := r.SkipTo(off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error skipping members: %v", err)
+		}
+		off = o.File + 1
+	}
+	if want := int64(4 + 2); members != want {
+		t.Errorf("unexpected number of members: got:%d want:%d", members, want)
+	}
+}
+
+func TestWriterDeterministic(t *testing.T) {
+	data := make([]byte, 5*BlockSize/2)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range data {
+		// Compressible but non-trivial data.
+		data[i] = "ACGT"[rnd.Intn(4)]
+	}
+	write := func(wc, level int, mtime time.Time) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriterLevel(&buf, level, wc)
+		if err != nil {
+			t.Fatalf("unexpected error creating writer: %v", err)
+		}
+		w.ModTime = mtime
+		rest := data
+		for _, n := range []int{1, 100, 10000} {
+			_, err = w.Write(rest[:n])
+			if err != nil {
+				t.Fatalf("unexpected error writing: %v", err)
+			}
+			rest = rest[n:]
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing: %v", err)
+		}
+		_, err = w.Write(rest)
+		if err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("unexpected error closing writer: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	stamp := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression} {
+		want := write(1, level, time.Time{})
+		for _, wc := range []int{1, *conc} {
+			got := write(wc, level, time.Time{})
+			if !bytes.Equal(got, want) {
+				t.Errorf("non-deterministic output for level %d with concurrency %d", level, wc)
+			}
+		}
+
+		// A fixed modification time is also reproducible.
+		a, b := write(1, level, stamp), write(*conc, level, stamp)
+		if !bytes.Equal(a, b) {
+			t.Errorf("non-deterministic output for level %d with fixed ModTime", level)
+		}
+		if bytes.Equal(a, want) {
+			t.Errorf("ModTime not recorded for level %d", level)
+		}
+	}
+}
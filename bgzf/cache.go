@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"sync"
 )
 
 // Cache is a Block caching type. Basic cache implementations are provided
@@ -113,6 +114,29 @@ type block struct {
 	data [MaxBlockSize]byte
 }
 
+// blockPool holds blocks that are no longer referenced by a Reader
+// or a Cache. Each block holds a MaxBlockSize data array, so reusing
+// blocks reduces allocation when a Reader without a Cache reads ahead
+// concurrently.
+var blockPool = sync.Pool{New: func() interface{} { return &block{} }}
+
+// newBlock returns a block owned by r, reusing a pooled block if available.
+func newBlock(r *Reader) *block {
+	b := blockPool.Get().(*block)
+	b.setOwner(r)
+	return b
+}
+
+// releaseBlock returns b to the block pool. Only unwrapped blocks are
+// pooled since a Wrapper may retain references to the blocks it wraps.
+// The caller must ensure that b is not referenced elsewhere.
+func releaseBlock(b Block) {
+	if b, ok := b.(*block); ok {
+		b.setOwner(nil)
+		blockPool.Put(b)
+	}
+}
+
 func (b *block) Base() int64 { return b.base }
 
 func (b *block) Used() bool { return b.used }
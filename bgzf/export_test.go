@@ -7,3 +7,9 @@ package bgzf
 const MagicBlock = magicBlock
 
 var ExpectedMemberSize = expectedMemberSize
+
+func ActiveDecompressors(bg *Reader) int { return int(bg.active.Load()) }
+
+var PadMember = padMember
+
+const MinPadMember = minPadMember
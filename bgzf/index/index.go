@@ -30,6 +30,26 @@ type ReferenceStats struct {
 	Unmapped uint64
 }
 
+// Querier is a coordinate sorted index that can be queried for the BGZF
+// chunks overlapping a genomic interval. Querier allows region reading code
+// to be written independently of the index format.
+//
+// Neither *bam.Index nor *csi.Index satisfies Querier directly: bam.Index's
+// Chunks method takes a *sam.Reference and csi.Index's Chunks method does
+// not return an error, and changing either would break existing callers.
+// Their Querier methods return adapters that must be used instead.
+type Querier interface {
+	// Chunks returns the BGZF chunks that may hold
+	// records in the half-open interval [beg, end)
+	// on the reference with the given ID.
+	Chunks(rid, beg, end int) ([]bgzf.Chunk, error)
+
+	// ReferenceStats returns the index statistics
+	// for the reference with the given ID and true
+	// if the statistics are valid.
+	ReferenceStats(rid int) (ReferenceStats, bool)
+}
+
 // Reader wraps a bgzf.Reader to provide a mechanism to read a selection of
 // BGZF chunks.
 type ChunkReader struct {
@@ -0,0 +1,71 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package bgzf
+
+import (
+	"compress/gzip"
+	"io"
+	"iter"
+)
+
+// BlockHeaders returns an iterator over the gzip headers of all the BGZF
+// members in the underlying io.Reader, yielding the virtual offset of the
+// start of each member with its header. Member payloads are not
+// decompressed; the BGZF block size field of each header is used to find
+// the following member. The underlying io.Reader must be an io.ReadSeeker,
+// otherwise nothing is yielded. Iteration stops at the end of the stream
+// or at the first member whose header cannot be read. BlockHeaders does
+// not alter the read position of the Reader.
+//
+//	for off, h := range bg.BlockHeaders() {
+//		fmt.Println(off.File, h.Name, h.ModTime)
+//	}
+func (bg *Reader) BlockHeaders() iter.Seq2[Offset, gzip.Header] {
+	return func(yield func(Offset, gzip.Header) bool) {
+		rs, ok := bg.r.(io.ReadSeeker)
+		if !ok {
+			return
+		}
+		var (
+			gz  gzip.Reader
+			off int64
+		)
+		for {
+			h, err := bg.memberHeader(&gz, rs, off)
+			if err != nil {
+				return
+			}
+			size := expectedMemberSize(h)
+			if size < 0 {
+				return
+			}
+			if !yield(Offset{File: off}, h) {
+				return
+			}
+			off += int64(size)
+		}
+	}
+}
+
+// memberHeader returns the gzip header of the member starting at off
+// in rs, restoring the read head position for the decompressors.
+func (bg *Reader) memberHeader(gz *gzip.Reader, rs io.ReadSeeker, off int64) (gzip.Header, error) {
+	cr := <-bg.head
+	defer func() {
+		cr.seek(rs, cr.offset())
+		bg.head <- cr
+	}()
+	_, err := rs.Seek(off, io.SeekStart)
+	if err != nil {
+		return gzip.Header{}, err
+	}
+	err = gz.Reset(rs)
+	if err != nil {
+		return gzip.Header{}, err
+	}
+	return gz.Header, nil
+}
@@ -0,0 +1,117 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package bgzf_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/biogo/hts/bgzf"
+)
+
+func TestBlockHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1)
+	names := []string{"first", "second", "third"}
+	mtime := time.Unix(1e9, 0)
+	var (
+		offsets []int64
+		mtimes  []time.Time
+	)
+	for i, name := range names {
+		w.Header.Name = name
+		w.Header.Comment = "block " + name
+		w.Header.ModTime = mtime.Add(time.Duration(i) * time.Hour)
+		mtimes = append(mtimes, w.Header.ModTime)
+		_, err := w.Write([]byte(name))
+		if err != nil {
+			t.Fatalf("unexpected error writing block %d: %v", i, err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("unexpected error flushing block %d: %v", i, err)
+		}
+		err = w.Wait()
+		if err != nil {
+			t.Fatalf("unexpected error waiting for block %d: %v", i, err)
+		}
+		offsets = append(offsets, w.Stats().Compressed)
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("unexpected error closing writer: %v", err)
+	}
+	// Member starts are the compressed sizes after the preceding
+	// member. Close writes a final empty data block, still carrying
+	// the last header, followed by the EOF marker block.
+	offsets = append([]int64{0}, offsets...)
+	offsets = append(offsets, w.Stats().Compressed)
+	names = append(names, names[len(names)-1])
+	mtimes = append(mtimes, mtimes[len(mtimes)-1])
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), 1)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	defer r.Close()
+
+	var i int
+	for off, h := range r.BlockHeaders() {
+		if off != (Offset{File: offsets[i]}) {
+			t.Errorf("unexpected offset for member %d: got:%+v want:%+v", i, off, Offset{File: offsets[i]})
+		}
+		if i == len(names) {
+			if h.Name != "" {
+				t.Errorf("unexpected name for EOF member: %q", h.Name)
+			}
+			i++
+			continue
+		}
+		if h.Name != names[i] {
+			t.Errorf("unexpected name for member %d: got:%q want:%q", i, h.Name, names[i])
+		}
+		if h.Comment != "block "+names[i] {
+			t.Errorf("unexpected comment for member %d: got:%q want:%q", i, h.Comment, "block "+names[i])
+		}
+		if !h.ModTime.Equal(mtimes[i]) {
+			t.Errorf("unexpected mtime for member %d: got:%v want:%v", i, h.ModTime, mtimes[i])
+		}
+		i++
+	}
+	if i != len(names)+1 {
+		t.Errorf("unexpected number of members: got:%d want:%d", i, len(names)+1)
+	}
+
+	// The Reader is still positioned at the start of the data.
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error reading data: %v", err)
+	}
+	if string(got) != "firstsecondthird" {
+		t.Errorf("unexpected data: got:%q want:%q", got, "firstsecondthird")
+	}
+
+	var n int
+	for range r.BlockHeaders() {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Errorf("unexpected number of members after break: got:%d want:1", n)
+	}
+
+	r, err = NewReader(struct{ io.Reader }{bytes.NewReader(buf.Bytes())}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	defer r.Close()
+	for range r.BlockHeaders() {
+		t.Error("unexpected member from unseekable reader")
+	}
+}
@@ -9,9 +9,12 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // countReader wraps flate.Reader, adding support for querying current offset.
@@ -124,6 +127,15 @@ func (r *buffer) readLimited(n int, src *countReader) error {
 	return err
 }
 
+// isize returns the gzip ISIZE trailer field of the buffered member.
+// It returns 0 if the buffer is too short to hold a trailer.
+func (r *buffer) isize() uint32 {
+	if r.size < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.data[r.size-4 : r.size])
+}
+
 // equals returns a boolean indicating the equality between
 // the buffered data and the given byte slice.
 func (r *buffer) equals(b []byte) bool { return bytes.Equal(r.data[:r.size], b) }
@@ -169,9 +181,9 @@ func (d *decompressor) ReadByte() (byte, error) {
 func (d *decompressor) lazyBlock() {
 	if d.blk == nil {
 		if w, ok := d.owner.cache.(Wrapper); ok {
-			d.blk = w.Wrap(&block{owner: d.owner})
+			d.blk = w.Wrap(newBlock(d.owner))
 		} else {
-			d.blk = &block{owner: d.owner}
+			d.blk = newBlock(d.owner)
 		}
 		return
 	}
@@ -255,6 +267,18 @@ func (d *decompressor) nextBlockAt(off int64, rs io.ReadSeeker) *decompressor {
 	d.gz.Header = gzip.Header{} // Prevent retention of header field in next use.
 
 	// Decompress data into the decompressor's Block.
+	if d.owner.verifying() {
+		isize := d.buf.isize()
+		go func() {
+			cr := &countReadCloser{rc: &d.gz}
+			d.err = d.blk.readFrom(cr)
+			if uint32(cr.n) != isize {
+				d.err = fmt.Errorf("%w: ISIZE mismatch at offset %d: got:%d want:%d", ErrCorrupt, d.blk.Base(), uint32(cr.n), isize)
+			}
+			d.wg.Done()
+		}()
+		return d
+	}
 	go func() {
 		d.err = d.blk.readFrom(&d.gz)
 		d.wg.Done()
@@ -263,6 +287,20 @@ func (d *decompressor) nextBlockAt(off int64, rs io.ReadSeeker) *decompressor {
 	return d
 }
 
+// countReadCloser wraps an io.ReadCloser, counting the number of bytes read.
+type countReadCloser struct {
+	rc io.ReadCloser
+	n  int64
+}
+
+func (r *countReadCloser) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countReadCloser) Close() error { return r.rc.Close() }
+
 // expectedMemberSize returns the size of the BGZF conformant gzip member.
 // It returns -1 if no BGZF block size field is found.
 func expectedMemberSize(h gzip.Header) int {
@@ -291,6 +329,9 @@ func (d *decompressor) readMember() error {
 	if d.blockSize < 0 {
 		return ErrNoBlockSize
 	}
+	if max := d.owner.maxMemberSize(); d.blockSize > max {
+		return fmt.Errorf("%w: member size %d exceeds limit %d at offset %d", ErrCorrupt, d.blockSize, max, mark)
+	}
 	skipped := int(d.cr.offset() - mark)
 	need := d.blockSize - skipped
 	if need == 0 {
@@ -343,12 +384,20 @@ type Reader struct {
 	// Non-concurrent work decompressor.
 	dec *decompressor
 
+	// conc is the number of decompressors
+	// used by the Reader.
+	conc int
+
 	// Concurrent work fields.
 	waiting chan *decompressor
 	working chan *decompressor
 	control chan int64
 	done    chan struct{}
 
+	// active is the number of decompressors
+	// not parked by the work loop.
+	active atomic.Int32
+
 	current Block
 
 	// cache is the Reader block cache. If Cache is not nil,
@@ -357,6 +406,29 @@ type Reader struct {
 	mu    sync.RWMutex
 	cache Cache
 
+	// cached is whether the Reader has
+	// ever had a non-nil cache.
+	cached bool
+
+	// verify specifies whether the decompressed
+	// length of each member is checked against
+	// its gzip ISIZE trailer field.
+	verify bool
+
+	// maxBlockSize is the largest declared
+	// member size accepted by the Reader.
+	// Zero is MaxBlockSize.
+	maxBlockSize int
+
+	// closeUnderlying specifies whether Close
+	// closes the underlying io.Reader.
+	closeUnderlying bool
+
+	// limit is the memory limit in bytes for
+	// readahead and cached blocks. Zero is no
+	// limit.
+	limit int
+
 	err error
 }
 
@@ -370,8 +442,12 @@ func NewReader(r io.Reader, rd int) (*Reader, error) {
 	if rd == 0 {
 		rd = runtime.GOMAXPROCS(0)
 	}
+	if rd < 1 {
+		rd = 1
+	}
 	bg := &Reader{
-		r: r,
+		r:    r,
+		conc: rd,
 
 		head: make(chan *countReader, 1),
 	}
@@ -403,6 +479,7 @@ func NewReader(r io.Reader, rd int) (*Reader, error) {
 		bg.waiting <- bg.dec
 		bg.dec = nil
 		next := blk.NextBase()
+		bg.active.Store(int32(bg.conc))
 		go func() {
 			defer func() {
 				bg.mu.Lock()
@@ -410,7 +487,28 @@ func NewReader(r io.Reader, rd int) (*Reader, error) {
 				bg.mu.Unlock()
 				close(bg.done)
 			}()
-			for dec := range bg.waiting {
+			// parked holds decompressors withheld
+			// from readahead by the memory limit.
+			var parked []*decompressor
+			for {
+				var dec *decompressor
+				depth := bg.readaheadDepth()
+				if n := len(parked); n != 0 && bg.conc-n < depth {
+					dec, parked = parked[n-1], parked[:n-1]
+				} else {
+					var ok bool
+					dec, ok = <-bg.waiting
+					if !ok {
+						return
+					}
+					if bg.conc-len(parked) > depth {
+						parked = append(parked, dec)
+						bg.active.Store(int32(bg.conc - len(parked)))
+						continue
+					}
+				}
+				bg.active.Store(int32(bg.conc - len(parked)))
+
 				var open bool
 				if next < 0 {
 					next, open = <-bg.control
@@ -440,9 +538,126 @@ func NewReader(r io.Reader, rd int) (*Reader, error) {
 func (bg *Reader) SetCache(c Cache) {
 	bg.mu.Lock()
 	bg.cache = c
+	bg.cached = bg.cached || c != nil
+	bg.mu.Unlock()
+}
+
+// release returns b to the block pool if the Reader has never had a
+// cache. Caches may hold references to the current Block, so Blocks
+// are only reused by Readers that have not used a cache.
+func (bg *Reader) release(b Block) {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+	if !bg.cached {
+		releaseBlock(b)
+	}
+}
+
+// SetVerify sets whether the Reader checks the decompressed length of each
+// BGZF member against the member's gzip ISIZE trailer field. If verification
+// is enabled, a length mismatch results in an error wrapping ErrCorrupt that
+// reports the file offset of the failing member. SetVerify only applies to
+// members decompressed after the call, so blocks already read or held in
+// readahead are not verified.
+func (bg *Reader) SetVerify(v bool) {
+	bg.mu.Lock()
+	bg.verify = v
+	bg.mu.Unlock()
+}
+
+// verifying returns whether the Reader is verifying member lengths.
+func (bg *Reader) verifying() bool {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+	return bg.verify
+}
+
+// SetMaxBlockSize sets the largest BGZF member size, as declared by the
+// member's block size field, that the Reader will accept. Members declaring
+// a larger size result in an error wrapping ErrCorrupt. The default limit
+// is MaxBlockSize, the largest size that the block size field can describe,
+// so n may only lower the limit; values of n less than 1 or greater than
+// MaxBlockSize restore the default. Like SetVerify, SetMaxBlockSize only
+// applies to members read after the call.
+func (bg *Reader) SetMaxBlockSize(n int) {
+	if n < 1 || n > MaxBlockSize {
+		n = 0
+	}
+	bg.mu.Lock()
+	bg.maxBlockSize = n
+	bg.mu.Unlock()
+}
+
+// maxMemberSize returns the largest member size accepted by the Reader.
+func (bg *Reader) maxMemberSize() int {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+	if bg.maxBlockSize == 0 {
+		return MaxBlockSize
+	}
+	return bg.maxBlockSize
+}
+
+// SetMemoryLimit sets an approximate limit in bytes on the memory used
+// by the Reader for readahead and for blocks held in its cache. Each
+// readahead decompressor is counted as 2*MaxBlockSize bytes, and each
+// cached block as MaxBlockSize bytes if the cache has a Len method, as
+// the caches in the bgzf/cache package do. When the limit is exceeded
+// the readahead depth is reduced, down to a minimum of two blocks for
+// a concurrent Reader, and it is restored when memory becomes available
+// again. Changes take effect as blocks are read. The cache is never
+// shrunk by the Reader, so a cache set with SetCache should be sized to
+// fit within the limit; readahead only uses the memory that remains. A
+// limit less than or equal to zero removes the limit. SetMemoryLimit has
+// no effect on a Reader with a concurrency of one.
+func (bg *Reader) SetMemoryLimit(bytes int) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	bg.mu.Lock()
+	bg.limit = bytes
 	bg.mu.Unlock()
 }
 
+// readaheadDepth returns the number of decompressors the work loop
+// may use under the Reader's memory limit.
+func (bg *Reader) readaheadDepth() int {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+	if bg.limit == 0 {
+		return bg.conc
+	}
+	avail := bg.limit
+	if c, ok := bg.cache.(interface{ Len() int }); ok {
+		avail -= c.Len() * MaxBlockSize
+	}
+	depth := avail / (2 * MaxBlockSize)
+	// Two decompressors are needed so that Seek can obtain one
+	// while the work loop holds another waiting for a new offset.
+	if depth < 2 {
+		depth = 2
+	}
+	if depth > bg.conc {
+		depth = bg.conc
+	}
+	return depth
+}
+
+// Concurrency returns the number of block decompressors used by the
+// Reader. A Reader with a concurrency of one reads blocks synchronously
+// without readahead.
+func (bg *Reader) Concurrency() int {
+	return bg.conc
+}
+
+// Seekable returns whether the Reader's underlying io.Reader is an
+// io.ReadSeeker, and so whether Seek, Resync and other random access
+// methods are available.
+func (bg *Reader) Seekable() bool {
+	_, ok := bg.r.(io.ReadSeeker)
+	return ok
+}
+
 // Seek performs a seek operation to the given virtual offset.
 func (bg *Reader) Seek(off Offset) error {
 	rs, ok := bg.r.(io.ReadSeeker)
@@ -507,26 +722,185 @@ func (bg *Reader) Seek(off Offset) error {
 	return bg.err
 }
 
+// Resync scans the underlying io.ReadSeeker forward for the next BGZF
+// member after the start of the member holding the end of the last chunk,
+// and seeks to that member. The virtual offset of the member found is
+// returned. Resync allows data following a corrupt region of a BGZF stream
+// to be recovered. If the found member cannot be read, the offset is
+// returned with the error, and a subsequent call to Resync will continue
+// scanning past the member. If no further member is found, Resync returns
+// io.EOF.
+func (bg *Reader) Resync() (Offset, error) {
+	return bg.seekMember(bg.lastChunk.End.File + 1)
+}
+
+// SkipTo scans the underlying io.ReadSeeker forward from the file offset
+// fileOffset for the first BGZF member starting at or after fileOffset, and
+// seeks to that member. The virtual offset of the member found is returned.
+// SkipTo allows a BGZF stream to be divided into approximately equal byte
+// ranges for parallel processing without an index; a member starting in
+// the range [a, b) is found by SkipTo(a) and not by SkipTo(b). If no further
+// member is found, SkipTo returns io.EOF.
+func (bg *Reader) SkipTo(fileOffset int64) (Offset, error) {
+	if fileOffset < 0 {
+		return Offset{}, fmt.Errorf("bgzf: negative file offset: %d", fileOffset)
+	}
+	return bg.seekMember(fileOffset)
+}
+
+// seekMember seeks to the first BGZF member at or after the file offset from,
+// returning the virtual offset of the member.
+func (bg *Reader) seekMember(from int64) (Offset, error) {
+	rs, ok := bg.r.(io.ReadSeeker)
+	if !ok {
+		return Offset{}, ErrNotASeeker
+	}
+
+	cr := <-bg.head
+	base, err := nextMember(rs, from)
+	if err == nil {
+		err = cr.seek(rs, base)
+	} else {
+		// Restore the read head position for the decompressors.
+		cr.seek(rs, cr.offset())
+	}
+	bg.head <- cr
+	if err != nil {
+		return Offset{}, err
+	}
+
+	off := Offset{File: base}
+	err = bg.Seek(off)
+	if err != nil {
+		bg.lastChunk = Chunk{Begin: off, End: off}
+	}
+	return off, err
+}
+
+// rawMember returns the compressed bytes of the BGZF member holding the
+// Reader's current block, advancing to the next non-empty block if the
+// current block has been fully read. The remaining data in the block is
+// discarded.
+func (bg *Reader) rawMember() ([]byte, error) {
+	rs, ok := bg.r.(io.ReadSeeker)
+	if !ok {
+		return nil, ErrNotASeeker
+	}
+	if bg.err != nil {
+		return nil, bg.err
+	}
+	for bg.current.len() == 0 {
+		bg.err = bg.nextBlock()
+		if bg.err != nil {
+			return nil, bg.err
+		}
+	}
+
+	base := bg.current.Base()
+	size := expectedMemberSize(bg.current.header())
+	if size < 0 {
+		return nil, ErrNoBlockSize
+	}
+	if next := bg.current.NextBase(); next >= 0 && next-base != int64(size) {
+		return nil, ErrBlockSizeMismatch
+	}
+
+	member := make([]byte, size)
+	cr := <-bg.head
+	_, err := rs.Seek(base, io.SeekStart)
+	if err == nil {
+		_, err = io.ReadFull(rs, member)
+	}
+	// Restore the read head position for the decompressors.
+	serr := cr.seek(rs, cr.offset())
+	bg.head <- cr
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bg.lastChunk.Begin = bg.current.txOffset()
+	_, bg.err = io.Copy(io.Discard, bg.current)
+	bg.lastChunk.End = bg.current.txOffset()
+	return member, bg.err
+}
+
+// nextMember returns the offset of the first BGZF member header found in rs
+// at or after the offset from.
+func nextMember(rs io.ReadSeeker, from int64) (int64, error) {
+	_, err := rs.Seek(from, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// A BGZF member header is a gzip header with the FEXTRA flag set,
+	// followed by an XLEN and the BC subfield.
+	const headerLen = 16
+	buf := make([]byte, MaxBlockSize)
+	var n int
+	for {
+		var r int
+		r, err = io.ReadFull(rs, buf[n:])
+		n += r
+		for i := 0; i+headerLen <= n; i++ {
+			b := buf[i : i+headerLen]
+			if b[0] == 0x1f && b[1] == 0x8b && b[2] == 8 && b[3]&0x04 != 0 && bytes.Equal(b[12:], bgzfExtraPrefix) {
+				return from + int64(i), nil
+			}
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		keep := headerLen - 1
+		copy(buf, buf[n-keep:n])
+		from += int64(n - keep)
+		n = keep
+	}
+}
+
 // LastChunk returns the region of the BGZF file read by the last
 // successful read operation or the resulting virtual offset of
 // the last successful seek operation.
 func (bg *Reader) LastChunk() Chunk { return bg.lastChunk }
 
+// Tell returns the virtual offset of the next byte to be read, the End
+// of the last chunk. The returned Offset may be passed to Seek to return
+// to the current position.
+func (bg *Reader) Tell() Offset { return bg.lastChunk.End }
+
 // BlockLen returns the number of bytes remaining to be read from the
 // current BGZF block.
 func (bg *Reader) BlockLen() int { return bg.current.len() }
 
-// Close closes the reader and releases resources.
+// SetCloseUnderlying sets whether Close also closes the Reader's underlying
+// io.Reader if it implements io.Closer. By default the underlying io.Reader
+// is not closed.
+func (bg *Reader) SetCloseUnderlying(c bool) {
+	bg.closeUnderlying = c
+}
+
+// Close closes the reader and releases resources. If SetCloseUnderlying has
+// been called with true, the underlying io.Reader is also closed if it is an
+// io.Closer.
 func (bg *Reader) Close() error {
 	if bg.control != nil {
 		close(bg.control)
 		close(bg.waiting)
 		<-bg.done
 	}
-	if bg.err == io.EOF {
-		return nil
+	var err error
+	if c, ok := bg.r.(io.Closer); ok && bg.closeUnderlying {
+		err = c.Close()
 	}
-	return bg.err
+	if bg.err != nil && bg.err != io.EOF {
+		return bg.err
+	}
+	return err
 }
 
 // Read implements the io.Reader interface.
@@ -575,6 +949,45 @@ func (bg *Reader) Read(p []byte) (int, error) {
 	return n, bg.err
 }
 
+// ReadBlockInto reads the remaining decompressed data of the current BGZF
+// block into p, returning the number of bytes read and the virtual offset
+// of the first byte. If the current block has been fully read, the next
+// non-empty block is read. Each call returns data from exactly one block,
+// so a Reader positioned at the start of a block returns the whole block
+// regardless of the Blocked field. ReadBlockInto returns io.ErrShortBuffer
+// if len(p) is less than MaxBlockSize, and io.EOF when no blocks remain.
+func (bg *Reader) ReadBlockInto(p []byte) (int, Offset, error) {
+	if len(p) < MaxBlockSize {
+		return 0, Offset{}, io.ErrShortBuffer
+	}
+	if bg.err != nil {
+		return 0, Offset{}, bg.err
+	}
+
+	for bg.current.len() == 0 {
+		bg.err = bg.nextBlock()
+		if bg.err != nil {
+			return 0, Offset{}, bg.err
+		}
+	}
+
+	off := bg.current.txOffset()
+	bg.lastChunk.Begin = off
+
+	var n int
+	for bg.current.len() != 0 {
+		_n, err := bg.current.Read(p[n:])
+		n += _n
+		if err != nil && err != io.EOF {
+			bg.err = err
+			break
+		}
+	}
+
+	bg.lastChunk.End = bg.current.txOffset()
+	return n, off, bg.err
+}
+
 // ReadByte implements the io.ByteReader interface.
 func (bg *Reader) ReadByte() (byte, error) {
 	if bg.err != nil {
@@ -626,6 +1039,7 @@ func (bg *Reader) nextBlock() error {
 		bg.dec.using(bg.current).nextBlockAt(base, nil)
 		bg.current, err = bg.dec.wait()
 	} else {
+		prev := bg.current
 		var ok bool
 		for i := 0; i < cap(bg.working); i++ {
 			dec := <-bg.working
@@ -643,6 +1057,9 @@ func (bg *Reader) nextBlock() error {
 		if !ok {
 			panic("bgzf: unexpected block")
 		}
+		if prev != nil && prev != bg.current {
+			bg.release(prev)
+		}
 	}
 	if err != nil {
 		return err
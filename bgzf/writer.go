@@ -6,10 +6,15 @@ package bgzf
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"sync"
+	"time"
 )
 
 // Writer implements BGZF blocked gzip compression.
@@ -19,10 +24,61 @@ import (
 // its output is to be read by another BGZF decompressor implementation.
 type Writer struct {
 	gzip.Header
+
+	// NoBlockSize specifies that blocks are written as plain gzip
+	// members without the BGZF BC extra subfield. Output written
+	// with NoBlockSize set is valid gzip, but it is not BGZF and
+	// cannot be read or seeked by a BGZF Reader; Readers return
+	// ErrNoBlockSize for such members. It is intended for testing
+	// and for producing mixed gzip/BGZF files. NoBlockSize should
+	// be set before the first write and not changed afterwards.
+	NoBlockSize bool
+
+	// AutoFlushBytes specifies the number of
+	// uncompressed bytes at which a block is
+	// automatically flushed. If AutoFlushBytes
+	// is zero or not less than the Writer's
+	// block size, blocks are flushed when they
+	// reach the block size. Smaller blocks allow
+	// finer grained seeking at the cost of
+	// compression.
+	AutoFlushBytes int
+
+	// PadToBlock specifies that Close pads
+	// the output with empty BGZF members so
+	// that the total size of the output,
+	// including the magic EOF block, is a
+	// multiple of MaxBlockSize. Empty members
+	// hold no data, so the padding is not
+	// visible to a Reader.
+	PadToBlock bool
+
 	w io.Writer
 
+	// blockSize is the maximum uncompressed
+	// size of data in each block.
+	blockSize int
+
+	// flushWrites specifies that each
+	// Write is followed by a Flush.
+	flushWrites bool
+
+	// level is the compression level and
+	// pool holds the compressors used by the
+	// Writer so they can be reused by Reset.
+	level int
+	pool  []compressor
+
+	// newCompressor returns the DEFLATE
+	// compressors used by the pool.
+	newCompressor func(io.Writer, int) (Compressor, error)
+
 	active *compressor
 
+	// blocks is the number of blocks
+	// queued for writing.
+	blocks int64
+
 	queue chan *compressor
 	qwg   sync.WaitGroup
 
@@ -32,14 +88,40 @@ type Writer struct {
 
 	closed bool
 
-	m   sync.Mutex
-	err error
+	m     sync.Mutex
+	err   error
+	stats WriterStats
+}
+
+// WriterStats holds statistics describing the output of a Writer.
+type WriterStats struct {
+	// Blocks is the number of BGZF blocks
+	// written, excluding the magic EOF block.
+	Blocks int64
+
+	// Uncompressed and Compressed are the total
+	// number of bytes of data written before and
+	// after compression.
+	Uncompressed int64
+	Compressed   int64
+
+	// Ratio is the ratio of Uncompressed to
+	// Compressed bytes. It is zero if no block
+	// has been written.
+	Ratio float64
 }
 
 // NewWriter returns a new Writer. Writes to the returned writer are
 // compressed and written to w.
 //
 // The number of concurrent write compressors is specified by wc.
+//
+// The output of a Writer is deterministic. Each block is compressed
+// independently and blocks are written in order, so the same sequence of
+// writes and flushes with the same compression level and gzip.Header
+// produces identical bytes for any value of wc, including 1. The header
+// modification time is only written if ModTime is set after the Unix
+// epoch, so by default no time is recorded in the output.
 func NewWriter(w io.Writer, wc int) *Writer {
 	bg, _ := NewWriterLevel(w, gzip.DefaultCompression, wc)
 	return bg
@@ -51,72 +133,205 @@ func NewWriter(w io.Writer, wc int) *Writer {
 //
 // The number of concurrent write compressors is specified by wc.
 func NewWriterLevel(w io.Writer, level, wc int) (*Writer, error) {
-	if level < gzip.DefaultCompression || level > gzip.BestCompression {
-		return nil, fmt.Errorf("bgzf: invalid compression level: %d", level)
+	return NewWriterConfig(w, WriterConfig{Level: level, Concurrency: wc})
+}
+
+// WriterConfig holds the configuration for a Writer created by
+// NewWriterConfig.
+type WriterConfig struct {
+	// Level is the compression level, as for
+	// NewWriterLevel. Note that the zero value is
+	// gzip.NoCompression, not gzip.DefaultCompression.
+	Level int
+
+	// Concurrency is the number of concurrent
+	// write compressors.
+	Concurrency int
+
+	// BlockSize is the maximum size of uncompressed
+	// data held in each block. Smaller blocks give
+	// finer grained random access at the cost of
+	// compression. If BlockSize is zero, the
+	// package BlockSize constant is used. It must
+	// not be greater than the BlockSize constant.
+	BlockSize int
+
+	// FlushWrites specifies that the data from each
+	// call to Write is flushed to a block, so no
+	// block holds data from more than one Write
+	// unless the Write spans blocks.
+	FlushWrites bool
+
+	// NewCompressor returns a DEFLATE Compressor
+	// writing to w at the given compression level.
+	// If NewCompressor is nil, compress/flate is
+	// used.
+	NewCompressor func(w io.Writer, level int) (Compressor, error)
+}
+
+// Compressor is a raw DEFLATE compressor used by a Writer to compress
+// block data. The Writer writes the gzip member header and trailer around
+// the compressed data. *flate.Writer satisfies Compressor.
+type Compressor interface {
+	io.WriteCloser
+
+	// Flush writes any pending data
+	// to the underlying io.Writer.
+	Flush() error
+
+	// Reset discards the Compressor's
+	// state and makes it equivalent to
+	// a new Compressor writing to w.
+	Reset(w io.Writer)
+}
+
+// NewWriterWith returns a new Writer using the specified compression level
+// and compressors returned by newCompressor instead of compress/flate.
+// This allows alternative DEFLATE implementations to be used. The number
+// of concurrent write compressors is specified by wc.
+func NewWriterWith(w io.Writer, level, wc int, newCompressor func(w io.Writer, level int) (Compressor, error)) (*Writer, error) {
+	return NewWriterConfig(w, WriterConfig{Level: level, Concurrency: wc, NewCompressor: newCompressor})
+}
+
+// newFlateCompressor returns a compress/flate Compressor.
+func newFlateCompressor(w io.Writer, level int) (Compressor, error) {
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// NewWriterConfig returns a new Writer configured by cfg. Writes to the
+// returned writer are compressed and written to w.
+func NewWriterConfig(w io.Writer, cfg WriterConfig) (*Writer, error) {
+	if cfg.Level < gzip.DefaultCompression || cfg.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("bgzf: invalid compression level: %d", cfg.Level)
+	}
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = BlockSize
 	}
-	wc++ // We count one for the active compressor.
+	if cfg.BlockSize < 0 || cfg.BlockSize > BlockSize {
+		return nil, fmt.Errorf("bgzf: invalid block size: %d", cfg.BlockSize)
+	}
+	wc := cfg.Concurrency + 1 // We count one for the active compressor.
 	if wc < 2 {
 		wc = 2
 	}
 	bg := &Writer{
-		w:       w,
-		waiting: make(chan *compressor, wc),
-		queue:   make(chan *compressor, wc),
+		w:           w,
+		blockSize:   cfg.BlockSize,
+		flushWrites: cfg.FlushWrites,
+		level:       cfg.Level,
+		pool:        make([]compressor, wc),
+
+		newCompressor: cfg.NewCompressor,
+	}
+	if bg.newCompressor == nil {
+		bg.newCompressor = newFlateCompressor
 	}
 	bg.Header.OS = 0xff // Set default OS to unknown.
+	bg.start()
+
+	return bg, nil
+}
 
-	c := make([]compressor, wc)
-	for i := range c {
-		c[i].Header = &bg.Header
-		c[i].level = level
-		c[i].waiting = bg.waiting
-		c[i].flush = make(chan *compressor, 1)
-		c[i].qwg = &bg.qwg
-		bg.waiting <- &c[i]
+// start prepares the Writer's compressor pool and
+// starts the goroutine writing compressed blocks.
+func (bg *Writer) start() {
+	wc := len(bg.pool)
+	bg.waiting = make(chan *compressor, wc)
+	bg.queue = make(chan *compressor, wc)
+	for i := range bg.pool {
+		c := &bg.pool[i]
+		c.Header = &bg.Header
+		c.noBlockSize = &bg.NoBlockSize
+		c.level = bg.level
+		c.newCompressor = bg.newCompressor
+		c.waiting = bg.waiting
+		c.flush = make(chan *compressor, 1)
+		c.qwg = &bg.qwg
+		c.next = 0
+		c.buf.Reset()
+		c.err = nil
+		bg.waiting <- c
 	}
 	bg.active = <-bg.waiting
 
 	bg.wg.Add(1)
 	go func() {
 		defer bg.wg.Done()
+		// The queue is drained even after a failure so that
+		// all compressors are returned to the waiting pool.
 		for qw := range bg.queue {
-			if !writeOK(bg, <-qw.flush) {
-				break
-			}
+			writeCompressed(bg, <-qw.flush)
 		}
 	}()
+}
 
-	return bg, nil
+// Reset discards the Writer's state and makes it equivalent to the
+// result of its original construction with w as the destination, but
+// reusing the Writer's compressors. The Writer's configuration, including
+// its gzip.Header, NoBlockSize and AutoFlushBytes fields, is retained
+// while its error state and statistics are cleared. Reset must only be
+// called after Close; calling Reset on an open Writer returns an error
+// and leaves the Writer unchanged.
+func (bg *Writer) Reset(w io.Writer) error {
+	if !bg.closed {
+		return errors.New("bgzf: reset of unclosed writer")
+	}
+	bg.w = w
+	bg.closed = false
+	bg.err = nil
+	bg.stats = WriterStats{}
+	bg.blocks = 0
+	bg.qwg = sync.WaitGroup{}
+	bg.start()
+	return nil
 }
 
-func writeOK(bg *Writer, c *compressor) bool {
-	defer func() { bg.waiting <- c }()
+func writeCompressed(bg *Writer, c *compressor) {
+	defer func() {
+		bg.qwg.Done()
+		bg.waiting <- c
+	}()
 
 	if c.err != nil {
 		bg.setErr(c.err)
-		return false
+		return
+	}
+	if bg.Error() != nil {
+		// Discard blocks queued after a failure.
+		return
 	}
 	if c.buf.Len() == 0 {
-		return true
+		return
 	}
 
-	_, err := io.Copy(bg.w, &c.buf)
-	bg.qwg.Done()
+	n, err := io.Copy(bg.w, &c.buf)
 	if err != nil {
 		bg.setErr(err)
-		return false
+		return
 	}
 	c.next = 0
 
-	return true
+	bg.m.Lock()
+	bg.stats.Blocks++
+	bg.stats.Uncompressed += int64(c.size)
+	bg.stats.Compressed += n
+	bg.m.Unlock()
 }
 
 type compressor struct {
 	*gzip.Header
-	gz    *gzip.Writer
-	level int
+	noBlockSize *bool
+	level       int
+
+	newCompressor func(io.Writer, int) (Compressor, error)
+	fw            Compressor
 
 	next  int
+	size  int // Size of the uncompressed data in the last written block.
 	block [BlockSize]byte
 	buf   bytes.Buffer
 
@@ -131,32 +346,48 @@ type compressor struct {
 func (c *compressor) writeBlock() {
 	defer func() { c.flush <- c }()
 
-	if c.gz == nil {
-		c.gz, c.err = gzip.NewWriterLevel(&c.buf, c.level)
-		if c.err != nil {
-			return
-		}
-	} else {
-		c.gz.Reset(&c.buf)
+	extra := append([]byte(bgzfExtra), c.Extra...)
+	if *c.noBlockSize {
+		extra = c.Extra
 	}
-	c.gz.Header = gzip.Header{
+	c.err = writeHeader(&c.buf, gzip.Header{
 		Comment: c.Comment,
-		Extra:   append([]byte(bgzfExtra), c.Extra...),
+		Extra:   extra,
 		ModTime: c.ModTime,
 		Name:    c.Name,
 		OS:      c.OS,
+	}, c.level)
+	if c.err != nil {
+		return
+	}
+
+	if c.fw == nil {
+		c.fw, c.err = c.newCompressor(&c.buf, c.level)
+		if c.err != nil {
+			return
+		}
+	} else {
+		c.fw.Reset(&c.buf)
 	}
 
-	_, c.err = c.gz.Write(c.block[:c.next])
+	c.size = c.next
+	_, c.err = c.fw.Write(c.block[:c.next])
 	if c.err != nil {
 		return
 	}
-	c.err = c.gz.Close()
+	c.err = c.fw.Close()
 	if c.err != nil {
 		return
 	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], crc32.ChecksumIEEE(c.block[:c.next]))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(c.next))
+	c.buf.Write(trailer[:])
 	c.next = 0
 
+	if *c.noBlockSize {
+		return
+	}
 	b := c.buf.Bytes()
 	i := bytes.Index(b, bgzfExtraPrefix)
 	if i < 0 {
@@ -171,6 +402,53 @@ func (c *compressor) writeBlock() {
 	b[i+4], b[i+5] = byte(size), byte(size>>8)
 }
 
+// writeHeader writes the RFC1952 member header described by h to buf
+// in the same way as a gzip.Writer with the given compression level.
+func writeHeader(buf *bytes.Buffer, h gzip.Header, level int) error {
+	var hdr [10]byte
+	hdr[0], hdr[1], hdr[2] = 0x1f, 0x8b, 8 // gzip ID and the deflate method.
+	if h.Extra != nil {
+		hdr[3] |= 0x04
+	}
+	if h.Name != "" {
+		hdr[3] |= 0x08
+	}
+	if h.Comment != "" {
+		hdr[3] |= 0x10
+	}
+	if h.ModTime.After(time.Unix(0, 0)) {
+		binary.LittleEndian.PutUint32(hdr[4:8], uint32(h.ModTime.Unix()))
+	}
+	switch level {
+	case gzip.BestCompression:
+		hdr[8] = 2
+	case gzip.BestSpeed:
+		hdr[8] = 4
+	}
+	hdr[9] = h.OS
+	buf.Write(hdr[:])
+	if h.Extra != nil {
+		if len(h.Extra) > 0xffff {
+			return errors.New("bgzf: extra data is too large")
+		}
+		buf.Write([]byte{byte(len(h.Extra)), byte(len(h.Extra) >> 8)})
+		buf.Write(h.Extra)
+	}
+	for _, s := range []string{h.Name, h.Comment} {
+		if s == "" {
+			continue
+		}
+		for _, r := range s {
+			if r == 0 || r > 0xff {
+				return errors.New("bgzf: non-Latin-1 header string")
+			}
+			buf.WriteByte(byte(r))
+		}
+		buf.WriteByte(0)
+	}
+	return nil
+}
+
 // Next returns the index of the start of the next write within the
 // decompressed data block.
 func (bg *Writer) Next() (int, error) {
@@ -184,6 +462,22 @@ func (bg *Writer) Next() (int, error) {
 	return bg.active.next, nil
 }
 
+// Position returns the ordinal of the block currently being filled by the
+// Writer and the index of the start of the next write within its
+// decompressed data. Blocks are numbered from zero in the order they are
+// written to the underlying io.Writer, including blocks that are pending
+// compression, so Position allows the location of written data to be
+// recorded before its compressed offset is known.
+func (bg *Writer) Position() (block int64, next int, err error) {
+	if bg.closed {
+		return 0, 0, ErrClosed
+	}
+	if err := bg.Error(); err != nil {
+		return 0, 0, err
+	}
+	return bg.blocks, bg.active.next, nil
+}
+
 // Write writes the compressed form of b to the underlying io.Writer.
 // Decompressed data blocks are limited to BlockSize, so individual
 // byte slices may span block boundaries, however the Writer attempts
@@ -198,17 +492,19 @@ func (bg *Writer) Write(b []byte) (int, error) {
 	}
 
 	c := bg.active
+	size := bg.limit()
 	var n int
 	for ; len(b) > 0 && err == nil; err = bg.Error() {
 		var _n int
-		if c.next == 0 || c.next+len(b) <= len(c.block) {
-			_n = copy(c.block[c.next:], b)
+		if c.next == 0 || c.next+len(b) <= size {
+			_n = copy(c.block[c.next:size], b)
 			b = b[_n:]
 			c.next += _n
 			n += _n
 		}
 
-		if c.next == len(c.block) || _n == 0 {
+		if c.next >= size || _n == 0 {
+			bg.blocks++
 			bg.queue <- c
 			bg.qwg.Add(1)
 			go c.writeBlock()
@@ -217,9 +513,69 @@ func (bg *Writer) Write(b []byte) (int, error) {
 	}
 	bg.active = c
 
+	if bg.flushWrites && err == nil {
+		err = bg.Flush()
+		if err != nil {
+			return n, err
+		}
+	}
 	return n, bg.Error()
 }
 
+// WriteBlock writes the compressed form of b to the underlying io.Writer,
+// ensuring that b is held entirely within a single data block. If b does
+// not fit in the remaining space of the current block, the current block
+// is flushed before b is written. If len(b) is greater than the Writer's
+// block size, or AutoFlushBytes if it is set and smaller, nothing is written
+// and ErrBlockOverflow is returned.
+func (bg *Writer) WriteBlock(b []byte) (int, error) {
+	if len(b) > bg.limit() {
+		return 0, ErrBlockOverflow
+	}
+	return bg.Write(b)
+}
+
+// BeginRecord prepares the Writer to write a record of size bytes held
+// entirely within a single block, and returns the virtual offset at which
+// the record will start. If the record does not fit in the remaining space
+// of the current block, the current block is flushed. The record must then
+// be written before any other data. If size is greater than the Writer's
+// block size, or AutoFlushBytes if it is set and smaller, ErrBlockOverflow
+// is returned. The returned offset is relative to the start of the Writer's
+// output. Since the offset depends on the compressed size of all preceding
+// blocks, BeginRecord waits for pending writes to complete, so using it
+// reduces write concurrency.
+func (bg *Writer) BeginRecord(size int) (Offset, error) {
+	if bg.closed {
+		return Offset{}, ErrClosed
+	}
+	if size < 0 || size > bg.limit() {
+		return Offset{}, ErrBlockOverflow
+	}
+	if bg.active.next+size > bg.limit() {
+		err := bg.Flush()
+		if err != nil {
+			return Offset{}, err
+		}
+	}
+	err := bg.Wait()
+	if err != nil {
+		return Offset{}, err
+	}
+	bg.m.Lock()
+	defer bg.m.Unlock()
+	return Offset{File: bg.stats.Compressed, Block: uint16(bg.active.next)}, nil
+}
+
+// limit returns the maximum number of uncompressed
+// bytes to hold in a block.
+func (bg *Writer) limit() int {
+	if 0 < bg.AutoFlushBytes && bg.AutoFlushBytes < bg.blockSize {
+		return bg.AutoFlushBytes
+	}
+	return bg.blockSize
+}
+
 // Flush writes unwritten data to the underlying io.Writer. Flush does not block.
 func (bg *Writer) Flush() error {
 	if bg.closed {
@@ -235,6 +591,7 @@ func (bg *Writer) Flush() error {
 
 	var c *compressor
 	c, bg.active = bg.active, <-bg.waiting
+	bg.blocks++
 	bg.queue <- c
 	bg.qwg.Add(1)
 	go c.writeBlock()
@@ -252,6 +609,62 @@ func (bg *Writer) Wait() error {
 	return bg.Error()
 }
 
+// Stats returns statistics for the blocks that have been written to the
+// underlying io.Writer. Blocks that are pending compression are not included
+// until they have been written; calling Wait before Stats ensures that all
+// flushed data is counted.
+func (bg *Writer) Stats() WriterStats {
+	bg.m.Lock()
+	defer bg.m.Unlock()
+	s := bg.stats
+	if s.Compressed != 0 {
+		s.Ratio = float64(s.Uncompressed) / float64(s.Compressed)
+	}
+	return s
+}
+
+// CopyBlock copies the compressed BGZF member holding the current block of
+// src to the Writer's output without recompression, and returns the offset
+// of the copied member within the Writer's output. Any data buffered by the
+// Writer is flushed first. If the current block of src has been completely
+// read, src is advanced to its next non-empty block, so empty members and
+// the magic EOF block are not copied. After the copy, src is positioned at
+// the end of the copied block. The member is copied verbatim, including its
+// header and BGZF block size field, so the Writer's gzip.Header is not
+// applied. The src Reader's underlying io.Reader must be an io.ReadSeeker.
+func (bg *Writer) CopyBlock(src *Reader) (Offset, error) {
+	if bg.closed {
+		return Offset{}, ErrClosed
+	}
+	err := bg.Flush()
+	if err != nil {
+		return Offset{}, err
+	}
+	err = bg.Wait()
+	if err != nil {
+		return Offset{}, err
+	}
+
+	member, err := src.rawMember()
+	if err != nil {
+		return Offset{}, err
+	}
+	n, err := bg.w.Write(member)
+	if err != nil {
+		bg.setErr(err)
+		return Offset{}, err
+	}
+	bg.blocks++
+
+	bg.m.Lock()
+	defer bg.m.Unlock()
+	off := Offset{File: bg.stats.Compressed}
+	bg.stats.Blocks++
+	bg.stats.Uncompressed += int64(binary.LittleEndian.Uint32(member[len(member)-4:]))
+	bg.stats.Compressed += int64(n)
+	return off, nil
+}
+
 // Error returns the error state of the Writer.
 func (bg *Writer) Error() error {
 	bg.m.Lock()
@@ -267,8 +680,54 @@ func (bg *Writer) setErr(err error) {
 	}
 }
 
+// pad writes empty members to bring the total output size, after the
+// magic EOF block has been written, to a multiple of MaxBlockSize.
+func (bg *Writer) pad() error {
+	need := int(-(bg.stats.Compressed + int64(len(magicBlock))) & (MaxBlockSize - 1))
+	if need == 0 {
+		return nil
+	}
+	if need < minPadMember {
+		need += MaxBlockSize
+	}
+	sizes := []int{need}
+	if need > MaxBlockSize {
+		sizes = []int{need / 2, need - need/2}
+	}
+	for _, size := range sizes {
+		_, err := bg.w.Write(padMember(size))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minPadMember is the size of the smallest padding member: an empty
+// BGZF member with a zero-length padding subfield.
+const minPadMember = len(magicBlock) + 4
+
+// padMember returns an empty BGZF member of the given size, which must be
+// in [minPadMember, MaxBlockSize]. The member is padded with a zero-filled
+// extra subfield following the BC subfield.
+func padMember(size int) []byte {
+	b := make([]byte, size)
+	copy(b, magicBlock[:16])
+	xlen := size - len(magicBlock) + len(bgzfExtra)
+	binary.LittleEndian.PutUint16(b[10:12], uint16(xlen))
+	binary.LittleEndian.PutUint16(b[16:18], uint16(size-1))
+	// Padding subfield.
+	b[18], b[19] = 'P', 'D'
+	binary.LittleEndian.PutUint16(b[20:22], uint16(xlen-len(bgzfExtra)-4))
+	// Empty DEFLATE stream followed by zero CRC32 and ISIZE.
+	copy(b[size-10:], magicBlock[len(magicBlock)-10:])
+	return b
+}
+
 // Close closes the Writer, waiting for any pending writes before returning
-// the final error of the Writer.
+// the final error of the Writer. Close is idempotent; subsequent calls
+// return the same error without writing to the underlying io.Writer.
+// The BGZF EOF marker block is only written if no error has occurred.
 func (bg *Writer) Close() error {
 	if !bg.closed {
 		c := bg.active
@@ -279,6 +738,9 @@ func (bg *Writer) Close() error {
 		bg.closed = true
 		close(bg.queue)
 		bg.wg.Wait()
+		if bg.err == nil && bg.PadToBlock {
+			bg.err = bg.pad()
+		}
 		if bg.err == nil {
 			_, bg.err = bg.w.Write([]byte(magicBlock))
 		}
@@ -0,0 +1,278 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cram
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/sam"
+)
+
+// CompressionHeader is a CRAM compression header.
+//
+// See CRAM spec section 8.4.
+type CompressionHeader struct {
+	// ReadNamesIncluded, APDelta, ReferenceRequired,
+	// SubstitutionMatrix and TagDictionary hold the
+	// RN, AP, RR, SM and TD preservation map values.
+	ReadNamesIncluded  bool
+	APDelta            bool
+	ReferenceRequired  bool
+	SubstitutionMatrix [5]byte
+	TagDictionary      [][]TagKey
+
+	// DataSeries holds the encodings for the data
+	// series keyed by the two letter data series
+	// name, for example "BF" for BAM bit flags.
+	DataSeries map[string]Encoding
+
+	// Tags holds the encodings for auxiliary tags.
+	Tags map[TagKey]Encoding
+}
+
+// TagKey is a CRAM auxiliary tag identifier.
+type TagKey struct {
+	Tag  sam.Tag
+	Type byte
+}
+
+// String returns the string representation of the TagKey.
+func (k TagKey) String() string {
+	return fmt.Sprintf("%s:%c", k.Tag, k.Type)
+}
+
+// tagKey returns the TagKey represented by the
+// ITF-8 encoded tag encoding map key k.
+func tagKey(k int32) TagKey {
+	return TagKey{Tag: sam.Tag{byte(k >> 16), byte(k >> 8)}, Type: byte(k)}
+}
+
+// Encoding is a CRAM data series or tag encoding.
+//
+// See CRAM spec section 13.
+type Encoding struct {
+	Codec Codec
+
+	// Params holds the undecoded
+	// codec parameters.
+	Params []byte
+}
+
+// Codec is a CRAM encoding codec ID.
+type Codec int32
+
+// Codec IDs defined in the CRAM spec section 13.
+const (
+	NullCodec Codec = iota
+	ExternalCodec
+	GolombCodec
+	HuffmanCodec
+	ByteArrayLenCodec
+	ByteArrayStopCodec
+	BetaCodec
+	SubexpCodec
+	GolombRiceCodec
+	GammaCodec
+)
+
+var codecNames = []string{
+	NullCodec:          "NULL",
+	ExternalCodec:      "EXTERNAL",
+	GolombCodec:        "GOLOMB",
+	HuffmanCodec:       "HUFFMAN",
+	ByteArrayLenCodec:  "BYTE_ARRAY_LEN",
+	ByteArrayStopCodec: "BYTE_ARRAY_STOP",
+	BetaCodec:          "BETA",
+	SubexpCodec:        "SUBEXP",
+	GolombRiceCodec:    "GOLOMB_RICE",
+	GammaCodec:         "GAMMA",
+}
+
+// String returns the CRAM spec name of the Codec.
+func (c Codec) String() string {
+	if c < 0 || int(c) >= len(codecNames) {
+		return fmt.Sprintf("Codec(%d)", int32(c))
+	}
+	return codecNames[c]
+}
+
+// CompressionHeader returns the CRAM compression header held by the Block.
+// It returns an error if the Block is not a compression header block.
+func (b *Block) CompressionHeader() (*CompressionHeader, error) {
+	if b.typ != compressionHeader {
+		return nil, fmt.Errorf("cram: not a compression header block: type %d", b.typ)
+	}
+	data := b.blockData
+	if b.method&0x80 == 0 {
+		var err error
+		data, err = b.expandBlockdata()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var h CompressionHeader
+	err := h.readFrom(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// readFrom populates a CompressionHeader from the given io.Reader.
+func (h *CompressionHeader) readFrom(r io.Reader) error {
+	*h = CompressionHeader{
+		ReadNamesIncluded: true,
+		APDelta:           true,
+		ReferenceRequired: true,
+	}
+	er := errorReader{r: r}
+
+	// Preservation map.
+	mr, n := er.mapReader()
+	for i := int32(0); i < n && mr.err == nil; i++ {
+		var key [2]byte
+		io.ReadFull(mr, key[:])
+		switch string(key[:]) {
+		case "RN":
+			h.ReadNamesIncluded = mr.bool()
+		case "AP":
+			h.APDelta = mr.bool()
+		case "RR":
+			h.ReferenceRequired = mr.bool()
+		case "SM":
+			io.ReadFull(mr, h.SubstitutionMatrix[:])
+		case "TD":
+			h.TagDictionary = tagDictionary(mr.bytes())
+		default:
+			if mr.err == nil {
+				return fmt.Errorf("cram: unknown preservation map key %q", key)
+			}
+		}
+	}
+	err := mr.done()
+	if err != nil {
+		return err
+	}
+
+	// Data series encoding map.
+	mr, n = er.mapReader()
+	h.DataSeries = make(map[string]Encoding, max(n, 0))
+	for i := int32(0); i < n && mr.err == nil; i++ {
+		var key [2]byte
+		io.ReadFull(mr, key[:])
+		h.DataSeries[string(key[:])] = mr.encoding()
+	}
+	err = mr.done()
+	if err != nil {
+		return err
+	}
+
+	// Tag encoding map.
+	mr, n = er.mapReader()
+	h.Tags = make(map[TagKey]Encoding, max(n, 0))
+	for i := int32(0); i < n && mr.err == nil; i++ {
+		key := mr.itf8()
+		h.Tags[tagKey(key)] = mr.encoding()
+	}
+	return mr.done()
+}
+
+// tagDictionary returns the tag ID lists held in the TD
+// preservation map value b. Each list is a sequence of
+// three byte tag IDs terminated by a NUL.
+func tagDictionary(b []byte) [][]TagKey {
+	var td [][]TagKey
+	for _, l := range bytes.Split(b, []byte{0}) {
+		if len(l) == 0 {
+			continue
+		}
+		keys := make([]TagKey, 0, len(l)/3)
+		for i := 0; i+2 < len(l); i += 3 {
+			keys = append(keys, TagKey{Tag: sam.Tag{l[i], l[i+1]}, Type: l[i+2]})
+		}
+		td = append(td, keys)
+	}
+	return td
+}
+
+// mapReader is an errorReader limited to the extent of a CRAM map.
+type mapReader struct {
+	errorReader
+	lr *io.LimitedReader
+}
+
+// mapReader returns a mapReader for the CRAM map at the current
+// reader position and the number of entries in the map.
+func (r *errorReader) mapReader() (*mapReader, int32) {
+	size := r.itf8()
+	if r.err == nil && size < 0 {
+		r.err = errors.New("cram: invalid map size")
+	}
+	lr := &io.LimitedReader{R: r, N: int64(size)}
+	mr := &mapReader{errorReader: errorReader{r: lr, err: r.err}, lr: lr}
+	n := mr.itf8()
+	if mr.err == nil && n < 0 {
+		mr.err = errors.New("cram: invalid map entry count")
+	}
+	return mr, n
+}
+
+// done returns any error encountered while reading the map, and checks
+// that the map was completely read.
+func (r *mapReader) done() error {
+	if r.err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if r.lr.N != 0 {
+		return errors.New("cram: map size mismatch")
+	}
+	return nil
+}
+
+// bool returns the boolean encoded at the current reader position.
+func (r *errorReader) bool() bool {
+	var b [1]byte
+	io.ReadFull(r, b[:])
+	return b[0] != 0
+}
+
+// bytes returns the byte array encoded at the current reader position.
+func (r *errorReader) bytes() []byte {
+	n := r.itf8()
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 {
+		r.err = errors.New("cram: invalid byte array length")
+		return nil
+	}
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, int64(n))
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return buf.Bytes()
+}
+
+// encoding returns the Encoding at the current reader position.
+func (r *errorReader) encoding() Encoding {
+	var e Encoding
+	e.Codec = Codec(r.itf8())
+	e.Params = r.bytes()
+	return e
+}
+
+func max(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
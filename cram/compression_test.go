@@ -0,0 +1,114 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cram
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestCompressionHeaderEOF(t *testing.T) {
+	var c Container
+	err := c.readFrom(bytes.NewReader(cramEOFmarker))
+	if err != nil {
+		t.Fatalf("failed to read container: %v", err)
+	}
+	if !c.Next() {
+		t.Fatalf("failed to read block: %v", c.Err())
+	}
+	got, err := c.Block().CompressionHeader()
+	if err != nil {
+		t.Fatalf("failed to read compression header: %v", err)
+	}
+	want := &CompressionHeader{
+		ReadNamesIncluded: true,
+		APDelta:           true,
+		ReferenceRequired: true,
+		DataSeries:        map[string]Encoding{},
+		Tags:              map[TagKey]Encoding{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected compression header:\ngot: %#v\nwant:%#v", got, want)
+	}
+}
+
+// cramMap returns the CRAM map encoding of n entries held in data.
+func cramMap(n byte, data ...byte) []byte {
+	return append([]byte{byte(len(data) + 1), n}, data...)
+}
+
+func TestCompressionHeader(t *testing.T) {
+	var data []byte
+	data = append(data, cramMap(4,
+		'R', 'N', 0,
+		'S', 'M', 0x1b, 0x1b, 0x1b, 0x1b, 0x1b,
+		'T', 'D', 11, 'N', 'M', 'c', 'M', 'D', 'Z', 0, 'R', 'G', 'Z', 0,
+		'A', 'P', 0,
+	)...)
+	data = append(data, cramMap(3,
+		'B', 'F', byte(ExternalCodec), 1, 1,
+		'R', 'L', byte(HuffmanCodec), 4, 1, 100, 1, 0,
+		'R', 'N', byte(ByteArrayStopCodec), 2, '\t', 2,
+	)...)
+	data = append(data, cramMap(1,
+		// NM:c is 0x4e4d63, which is ITF-8 encoded in four bytes.
+		0xe0, 0x4e, 0x4d, 0x63, byte(ExternalCodec), 1, 7,
+	)...)
+
+	b := Block{
+		method:         rawMethod,
+		typ:            compressionHeader,
+		compressedSize: int32(len(data)),
+		rawSize:        int32(len(data)),
+		blockData:      data,
+	}
+	got, err := b.CompressionHeader()
+	if err != nil {
+		t.Fatalf("failed to read compression header: %v", err)
+	}
+	nm := TagKey{Tag: sam.NewTag("NM"), Type: 'c'}
+	want := &CompressionHeader{
+		ReadNamesIncluded:  false,
+		APDelta:            false,
+		ReferenceRequired:  true,
+		SubstitutionMatrix: [5]byte{0x1b, 0x1b, 0x1b, 0x1b, 0x1b},
+		TagDictionary: [][]TagKey{
+			{nm, {Tag: sam.NewTag("MD"), Type: 'Z'}},
+			{{Tag: sam.NewTag("RG"), Type: 'Z'}},
+		},
+		DataSeries: map[string]Encoding{
+			"BF": {Codec: ExternalCodec, Params: []byte{1}},
+			"RL": {Codec: HuffmanCodec, Params: []byte{1, 100, 1, 0}},
+			"RN": {Codec: ByteArrayStopCodec, Params: []byte{'\t', 2}},
+		},
+		Tags: map[TagKey]Encoding{
+			nm: {Codec: ExternalCodec, Params: []byte{7}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected compression header:\ngot: %#v\nwant:%#v", got, want)
+	}
+	if s := got.DataSeries["RL"].Codec.String(); s != "HUFFMAN" {
+		t.Errorf("unexpected codec name: got:%q want:%q", s, "HUFFMAN")
+	}
+
+	// Truncating the header must result in an error.
+	for i := range data {
+		b.blockData = data[:i]
+		_, err = b.CompressionHeader()
+		if err == nil {
+			t.Errorf("expected error for header truncated at %d", i)
+		}
+	}
+
+	b.typ = mappedSliceHeader
+	_, err = b.CompressionHeader()
+	if err == nil {
+		t.Error("expected error for non-compression header block")
+	}
+}
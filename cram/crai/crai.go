@@ -0,0 +1,135 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package crai implements CRAM index reading.
+//
+// See CRAM spec section 12.
+package crai
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Entry is a CRAM index entry describing the location of a slice.
+type Entry struct {
+	// RefID is the reference ID of the slice.
+	// It is -1 for slices of unmapped records
+	// and -2 for multiple reference slices.
+	RefID int
+
+	// Start is the 1-based alignment start
+	// and Span is the alignment span of the
+	// slice on the reference.
+	Start int
+	Span  int
+
+	// Container is the file offset of
+	// the container holding the slice.
+	Container int64
+
+	// Slice is the offset of the slice from
+	// the end of the container header and
+	// SliceSize is the size of the slice in
+	// bytes.
+	Slice     int64
+	SliceSize int64
+}
+
+// Index is a CRAM index.
+type Index struct {
+	Entries []Entry
+}
+
+// ReadFrom reads the gzip compressed CRAM index from the given io.Reader.
+func ReadFrom(r io.Reader) (*Index, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var idx Index
+	sc := bufio.NewScanner(gz)
+	for line := 1; sc.Scan(); line++ {
+		b := sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		e, err := parseEntry(b)
+		if err != nil {
+			return nil, fmt.Errorf("crai: line %d: %w", line, err)
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+	err = sc.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// parseEntry returns the Entry held in the tab-separated line b.
+func parseEntry(b []byte) (Entry, error) {
+	f := bytes.Split(b, []byte{'\t'})
+	if len(f) != 6 {
+		return Entry{}, fmt.Errorf("invalid number of fields: %d", len(f))
+	}
+	var v [6]int64
+	for i, s := range f {
+		var err error
+		v[i], err = strconv.ParseInt(string(s), 10, 64)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	return Entry{
+		RefID:     int(v[0]),
+		Start:     int(v[1]),
+		Span:      int(v[2]),
+		Container: v[3],
+		Slice:     v[4],
+		SliceSize: v[5],
+	}, nil
+}
+
+// Slices returns the entries for slices on the reference with the given ID
+// that overlap the 0-based half-open interval [beg, end), ordered by file
+// offset.
+func (i *Index) Slices(refID, beg, end int) []Entry {
+	var entries []Entry
+	for _, e := range i.Entries {
+		if e.RefID != refID {
+			continue
+		}
+		if s := e.Start - 1; s < end && s+e.Span > beg {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Container != entries[j].Container {
+			return entries[i].Container < entries[j].Container
+		}
+		return entries[i].Slice < entries[j].Slice
+	})
+	return entries
+}
+
+// Containers returns the file offsets of the containers holding slices on
+// the reference with the given ID that overlap the 0-based half-open interval
+// [beg, end), in ascending order.
+func (i *Index) Containers(refID, beg, end int) []int64 {
+	var offsets []int64
+	for _, e := range i.Slices(refID, beg, end) {
+		if len(offsets) == 0 || offsets[len(offsets)-1] != e.Container {
+			offsets = append(offsets, e.Container)
+		}
+	}
+	return offsets
+}
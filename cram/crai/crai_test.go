@@ -0,0 +1,86 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package crai
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+)
+
+const testIndex = `0	1	1000	26	281	5000
+0	900	2000	5400	283	6000
+1	1	500	11800	281	4000
+-1	0	0	16000	281	300
+`
+
+func gzipped(t *testing.T, s string) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(s))
+	if err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatalf("failed to close index: %v", err)
+	}
+	return &buf
+}
+
+func TestReadFrom(t *testing.T) {
+	idx, err := ReadFrom(gzipped(t, testIndex))
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	want := []Entry{
+		{RefID: 0, Start: 1, Span: 1000, Container: 26, Slice: 281, SliceSize: 5000},
+		{RefID: 0, Start: 900, Span: 2000, Container: 5400, Slice: 283, SliceSize: 6000},
+		{RefID: 1, Start: 1, Span: 500, Container: 11800, Slice: 281, SliceSize: 4000},
+		{RefID: -1, Start: 0, Span: 0, Container: 16000, Slice: 281, SliceSize: 300},
+	}
+	if !reflect.DeepEqual(idx.Entries, want) {
+		t.Errorf("unexpected entries:\ngot: %+v\nwant:%+v", idx.Entries, want)
+	}
+
+	for _, bad := range []string{
+		"0\t1\t1000\t26\t281\n",
+		"0\t1\t1000\t26\t281\tx\n",
+	} {
+		_, err = ReadFrom(gzipped(t, bad))
+		if err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	_, err = ReadFrom(bytes.NewReader([]byte(testIndex)))
+	if err == nil {
+		t.Error("expected error for uncompressed index")
+	}
+}
+
+func TestContainers(t *testing.T) {
+	idx, err := ReadFrom(gzipped(t, testIndex))
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	for _, test := range []struct {
+		refID, beg, end int
+		want            []int64
+	}{
+		{refID: 0, beg: 0, end: 10, want: []int64{26}},
+		{refID: 0, beg: 950, end: 1000, want: []int64{26, 5400}},
+		{refID: 0, beg: 1000, end: 1100, want: []int64{5400}},
+		{refID: 0, beg: 2899, end: 3000, want: nil},
+		{refID: 1, beg: 0, end: 1 << 29, want: []int64{11800}},
+		{refID: 2, beg: 0, end: 1 << 29, want: nil},
+	} {
+		got := idx.Containers(test.refID, test.beg, test.end)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("unexpected containers for %d:[%d,%d): got:%v want:%v",
+				test.refID, test.beg, test.end, got, test.want)
+		}
+	}
+}
@@ -4,8 +4,10 @@
 
 // Package cram is a WIP CRAM reader implementation.
 //
-// Currently the package implements container, block and slice retrieval, and
-// SAM header values can be retrieved from blocks.
+// Currently the package implements container, block and slice retrieval,
+// SAM header values can be retrieved from blocks and compression headers
+// can be decoded into their data series and tag encodings. Containers
+// can be accessed randomly using a CRAM index read by the crai package.
 //
 // See https://samtools.github.io/hts-specs/CRAMv3.pdf for the CRAM
 // specification.
@@ -124,6 +126,24 @@ func (r *Reader) Next() bool {
 	return r.err == nil
 }
 
+// SeekContainer moves the Reader to the CRAM container starting at the given file
+// offset, for example an offset obtained from a CRAM index. The next call
+// to Next will read the container at that offset. The underlying
+// io.Reader must be an io.Seeker.
+func (r *Reader) SeekContainer(off int64) error {
+	rs, ok := r.r.(io.Seeker)
+	if !ok {
+		return errors.New("cram: reader not seekable")
+	}
+	_, err := rs.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.c = nil
+	r.err = nil
+	return nil
+}
+
 // Container returns the current CRAM container. The returned Container
 // is only valid after a previous call to Next has returned true.
 func (r *Reader) Container() *Container {
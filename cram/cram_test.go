@@ -6,14 +6,21 @@ package cram
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"reflect"
 	"testing"
 
 	"github.com/kortschak/utter"
+
+	"github.com/biogo/hts/cram/crai"
+	"github.com/biogo/hts/cram/encoding/itf8"
+	"github.com/biogo/hts/cram/encoding/ltf8"
 )
 
 func TestReadDefinition(t *testing.T) {
@@ -157,6 +164,19 @@ func TestRead(t *testing.T) {
 		c.blockData = blockData
 
 		for c.Next() {
+			if c.Block().typ == compressionHeader {
+				h, err := c.Block().CompressionHeader()
+				if err != nil {
+					t.Errorf("failed to get compression header: %v", err)
+				} else if c.nRec != 0 {
+					// Every record has BAM bit flags and CRAM bit flags.
+					for _, ds := range []string{"BF", "CF"} {
+						if _, ok := h.DataSeries[ds]; !ok {
+							t.Errorf("missing %s data series encoding", ds)
+						}
+					}
+				}
+			}
 			v, err := c.Block().Value()
 			if err != nil {
 				t.Errorf("failed to get value: %v", err)
@@ -172,6 +192,98 @@ func TestRead(t *testing.T) {
 	}
 }
 
+// testContainer returns a CRAM container holding the single
+// block of the CRAM EOF marker with the given reference ID,
+// start and span.
+func testContainer(refID, start, span int32) []byte {
+	blocks := cramEOFmarker[23:]
+	var buf [9]byte
+	h := binary.LittleEndian.AppendUint32(nil, uint32(len(blocks)))
+	for _, v := range []int32{refID, start, span, 0} {
+		h = append(h, buf[:itf8.Encode(buf[:], v)]...)
+	}
+	for _, v := range []int64{0, 0} {
+		h = append(h, buf[:ltf8.Encode(buf[:], v)]...)
+	}
+	for _, v := range []int32{1, 0} { // One block and no landmarks.
+		h = append(h, buf[:itf8.Encode(buf[:], v)]...)
+	}
+	h = binary.LittleEndian.AppendUint32(h, crc32.ChecksumIEEE(h))
+	return append(h, blocks...)
+}
+
+func TestSeekContainer(t *testing.T) {
+	var cram bytes.Buffer
+	cram.Write([]byte{'C', 'R', 'A', 'M', 3, 0, 19: 's', 'e', 'e', 'k'})
+	var offsets []int64
+	for _, c := range []struct{ refID, start, span int32 }{
+		{refID: 0, start: 1, span: 100},
+		{refID: 1, start: 501, span: 200},
+	} {
+		offsets = append(offsets, int64(cram.Len()))
+		cram.Write(testContainer(c.refID, c.start, c.span))
+	}
+	cram.Write(cramEOFmarker)
+
+	var index bytes.Buffer
+	gz := gzip.NewWriter(&index)
+	fmt.Fprintf(gz, "0\t1\t100\t%d\t0\t15\n", offsets[0])
+	fmt.Fprintf(gz, "1\t501\t200\t%d\t0\t15\n", offsets[1])
+	gz.Close()
+	idx, err := crai.ReadFrom(&index)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+
+	cr, err := NewReader(bytes.NewReader(cram.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to open cram: %v", err)
+	}
+	containers := idx.Containers(1, 600, 650)
+	if len(containers) != 1 || containers[0] != offsets[1] {
+		t.Fatalf("unexpected containers: got:%v want:[%d]", containers, offsets[1])
+	}
+	for i := 0; i < 2; i++ {
+		err = cr.SeekContainer(containers[0])
+		if err != nil {
+			t.Fatalf("failed to seek: %v", err)
+		}
+		if !cr.Next() {
+			t.Fatalf("failed to read container: %v", cr.Err())
+		}
+		c := cr.Container()
+		if c.refID != 1 || c.start != 501 || c.span != 200 {
+			t.Errorf("unexpected container: got refID=%d start=%d span=%d want refID=1 start=501 span=200",
+				c.refID, c.start, c.span)
+		}
+		if !c.Next() {
+			t.Errorf("failed to read block: %v", c.Err())
+		}
+	}
+
+	// The container following the seek target is the EOF container.
+	if !cr.Next() {
+		t.Fatalf("failed to read EOF container: %v", cr.Err())
+	}
+	if c := cr.Container(); c.refID != -1 {
+		t.Errorf("unexpected reference ID for EOF container: got:%d want:-1", c.refID)
+	}
+	if cr.Next() {
+		t.Error("unexpected container after EOF container")
+	}
+	if cr.Err() != nil {
+		t.Errorf("unexpected error: %v", cr.Err())
+	}
+
+	cr, err = NewReader(io.MultiReader(bytes.NewReader(cram.Bytes())))
+	if err != nil {
+		t.Fatalf("failed to open cram: %v", err)
+	}
+	if cr.SeekContainer(offsets[1]) == nil {
+		t.Error("expected error seeking unseekable reader")
+	}
+}
+
 func get(url string) (*bytes.Reader, error) {
 	resp, err := http.Get(url)
 	if err != nil {
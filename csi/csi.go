@@ -95,6 +95,38 @@ func (i *Index) NumRefs() int {
 	return len(i.refs)
 }
 
+// BinInfo describes a bin of a CSI index.
+type BinInfo struct {
+	// Bin is the bin number.
+	Bin uint32
+
+	// Left is the virtual file offset of the
+	// first record overlapping the bin.
+	Left bgzf.Offset
+
+	// Chunks is the number of chunks
+	// held by the bin.
+	Chunks int
+}
+
+// RefBins returns a description of each bin held by the index for the
+// reference with the given ID, in the order the bins are stored. It returns
+// nil if rid is out of range.
+func (i *Index) RefBins(rid int) []BinInfo {
+	if rid < 0 || rid >= len(i.refs) {
+		return nil
+	}
+	bins := i.refs[rid].bins
+	if len(bins) == 0 {
+		return nil
+	}
+	info := make([]BinInfo, len(bins))
+	for j, b := range bins {
+		info[j] = BinInfo{Bin: b.bin, Left: b.left, Chunks: len(b.chunks)}
+	}
+	return info
+}
+
 // ReferenceStats returns the index statistics for the given reference and true
 // if the statistics are valid.
 func (i *Index) ReferenceStats(id int) (stats index.ReferenceStats, ok bool) {
@@ -232,6 +264,26 @@ func (i *Index) Chunks(rid int, beg, end int) []bgzf.Chunk {
 
 var adjacent = index.Adjacent
 
+// Querier returns an index.Querier that queries the Index by reference ID.
+// The Chunks method of the returned index.Querier returns index.ErrNoReference
+// if the reference ID is not present in the Index.
+func (i *Index) Querier() index.Querier { return csiQuerier{i} }
+
+var _ index.Querier = csiQuerier{}
+
+// csiQuerier is an index.Querier adapter for a CSI Index.
+type csiQuerier struct {
+	*Index
+}
+
+// Chunks returns a []bgzf.Chunk that corresponds to the given interval.
+func (q csiQuerier) Chunks(rid, beg, end int) ([]bgzf.Chunk, error) {
+	if rid < 0 || rid >= len(q.refs) {
+		return nil, index.ErrNoReference
+	}
+	return q.Index.Chunks(rid, beg, end), nil
+}
+
 func (i *Index) sort() {
 	if !i.isSorted {
 		for _, ref := range i.refs {
@@ -300,7 +352,7 @@ func reg2bin(beg, end int64, minShift, depth uint32) uint32 {
 			return t + uint32(offset)
 		}
 		s += nextBinShift
-		t -= 1 << (level * nextBinShift)
+		t -= 1 << ((level - 1) * nextBinShift)
 	}
 	return 0
 }
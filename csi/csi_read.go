@@ -5,6 +5,7 @@
 package csi
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,6 +16,16 @@ import (
 	"github.com/biogo/hts/bgzf/index"
 )
 
+const (
+	// maxDepth is the deepest index depth for which
+	// bin numbers can be represented in a uint32.
+	maxDepth = 10
+
+	// maxCoordShift is the largest bit width of
+	// coordinates that can be indexed.
+	maxCoordShift = 62
+)
+
 // ReadFrom reads the CSI index from the given io.Reader. Note that
 // the csi specification states that the index is stored as BGZF, but
 // ReadFrom does not perform decompression.
@@ -44,29 +55,41 @@ func ReadFrom(r io.Reader) (*Index, error) {
 	if err != nil {
 		return nil, err
 	}
-	if int32(idx.minShift) < 0 {
-		return nil, errors.New("csi: invalid minimum shift value")
+	if int32(idx.minShift) <= 0 {
+		return nil, fmt.Errorf("csi: invalid minimum shift value: %d", int32(idx.minShift))
 	}
 	err = binary.Read(r, binary.LittleEndian, &idx.depth)
 	if err != nil {
 		return nil, err
 	}
-	if int32(idx.depth) < 0 {
-		return nil, errors.New("csi: invalid index depth value")
+	if int32(idx.depth) <= 0 || idx.depth > maxDepth {
+		return nil, fmt.Errorf("csi: invalid index depth value: %d", int32(idx.depth))
+	}
+	if idx.minShift+idx.depth*nextBinShift > maxCoordShift {
+		return nil, fmt.Errorf("csi: minimum shift and depth out of range: %d+3*%d > %d", idx.minShift, idx.depth, maxCoordShift)
 	}
 	var n int32
 	err = binary.Read(r, binary.LittleEndian, &n)
 	if err != nil {
 		return nil, err
 	}
+	if n < 0 {
+		return nil, fmt.Errorf("csi: invalid auxiliary data length: %d", n)
+	}
 	if n > 0 {
-		idx.Auxilliary = make([]byte, n)
-		_, err = io.ReadFull(r, idx.Auxilliary)
+		// Read incrementally so that a corrupt length does
+		// not cause a large allocation.
+		var buf bytes.Buffer
+		_, err = io.CopyN(&buf, r, int64(n))
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return nil, err
 		}
+		idx.Auxilliary = buf.Bytes()
 	}
-	binLimit := uint32(((1 << ((idx.depth + 1) * nextBinShift)) - 1) / 7)
+	binLimit := uint32(((uint64(1) << ((idx.depth + 1) * nextBinShift)) - 1) / 7)
 	idx.refs, err = readIndices(r, idx.Version, binLimit)
 	if err != nil {
 		return nil, err
@@ -91,16 +114,32 @@ func readIndices(r io.Reader, version byte, binLimit uint32) ([]refIndex, error)
 	if n == 0 {
 		return nil, nil
 	}
-	idx := make([]refIndex, n)
-	for i := range idx {
-		idx[i].bins, idx[i].stats, err = readBins(r, version, binLimit)
+	if n < 0 {
+		return nil, fmt.Errorf("csi: invalid reference count: %d", n)
+	}
+	idx := make([]refIndex, 0, initialCap(n))
+	for i := 0; i < int(n); i++ {
+		var ref refIndex
+		ref.bins, ref.stats, err = readBins(r, version, binLimit)
 		if err != nil {
 			return nil, err
 		}
+		idx = append(idx, ref)
 	}
 	return idx, nil
 }
 
+// initialCap returns the initial capacity to use for a slice
+// with n elements read from a possibly corrupt index. The slice
+// is grown as elements are successfully read.
+func initialCap(n int32) int {
+	const maxInitialCap = 1 << 10
+	if n > maxInitialCap {
+		return maxInitialCap
+	}
+	return int(n)
+}
+
 func readBins(r io.Reader, version byte, binLimit uint32) ([]bin, *index.ReferenceStats, error) {
 	var nBins int32
 	err := binary.Read(r, binary.LittleEndian, &nBins)
@@ -114,10 +153,11 @@ func readBins(r io.Reader, version byte, binLimit uint32) ([]bin, *index.Referen
 		return nil, nil, fmt.Errorf("csi: invalid bin count: %d > %d", nBins, binLimit)
 	}
 	var stats *index.ReferenceStats
-	bins := make([]bin, nBins)
+	bins := make([]bin, 0, initialCap(nBins))
 	statsDummyBin := binLimit + 1
-	for i := 0; i < len(bins); i++ {
-		err = binary.Read(r, binary.LittleEndian, &bins[i].bin)
+	for i := 0; i < int(nBins); i++ {
+		var b bin
+		err = binary.Read(r, binary.LittleEndian, &b.bin)
 		if err != nil {
 			return nil, nil, fmt.Errorf("csi: failed to read bin number: %w", err)
 		}
@@ -126,9 +166,9 @@ func readBins(r io.Reader, version byte, binLimit uint32) ([]bin, *index.Referen
 		if err != nil {
 			return nil, nil, fmt.Errorf("csi: failed to read left virtual offset: %w", err)
 		}
-		bins[i].left = makeOffset(vOff)
+		b.left = makeOffset(vOff)
 		if version == 0x2 {
-			err = binary.Read(r, binary.LittleEndian, &bins[i].records)
+			err = binary.Read(r, binary.LittleEndian, &b.records)
 			if err != nil {
 				return nil, nil, fmt.Errorf("csi: failed to read record count: %w", err)
 			}
@@ -138,7 +178,10 @@ func readBins(r io.Reader, version byte, binLimit uint32) ([]bin, *index.Referen
 		if err != nil {
 			return nil, nil, fmt.Errorf("csi: failed to read bin count: %w", err)
 		}
-		if bins[i].bin == statsDummyBin {
+		if b.bin >= binLimit && b.bin != statsDummyBin {
+			return nil, nil, fmt.Errorf("csi: invalid bin number: %d >= %d", b.bin, binLimit)
+		}
+		if b.bin == statsDummyBin {
 			if nChunks != 2 {
 				return nil, nil, errors.New("csi: malformed dummy bin header")
 			}
@@ -146,14 +189,13 @@ func readBins(r io.Reader, version byte, binLimit uint32) ([]bin, *index.Referen
 			if err != nil {
 				return nil, nil, err
 			}
-			bins = bins[:len(bins)-1]
-			i--
 			continue
 		}
-		bins[i].chunks, err = readChunks(r, nChunks)
+		b.chunks, err = readChunks(r, nChunks)
 		if err != nil {
 			return nil, nil, err
 		}
+		bins = append(bins, b)
 	}
 	if !sort.IsSorted(byBinNumber(bins)) {
 		sort.Sort(byBinNumber(bins))
@@ -165,22 +207,27 @@ func readChunks(r io.Reader, n int32) ([]bgzf.Chunk, error) {
 	if n == 0 {
 		return nil, nil
 	}
+	if n < 0 {
+		return nil, fmt.Errorf("csi: invalid chunk count: %d", n)
+	}
 	var (
 		vOff uint64
 		err  error
 	)
-	chunks := make([]bgzf.Chunk, n)
-	for i := range chunks {
+	chunks := make([]bgzf.Chunk, 0, initialCap(n))
+	for i := 0; i < int(n); i++ {
+		var c bgzf.Chunk
 		err = binary.Read(r, binary.LittleEndian, &vOff)
 		if err != nil {
 			return nil, fmt.Errorf("csi: failed to read chunk begin virtual offset: %w", err)
 		}
-		chunks[i].Begin = makeOffset(vOff)
+		c.Begin = makeOffset(vOff)
 		err = binary.Read(r, binary.LittleEndian, &vOff)
 		if err != nil {
 			return nil, fmt.Errorf("csi: failed to read chunk end virtual offset: %w", err)
 		}
-		chunks[i].End = makeOffset(vOff)
+		c.End = makeOffset(vOff)
+		chunks = append(chunks, c)
 	}
 	if !sort.IsSorted(byBeginOffset(chunks)) {
 		sort.Sort(byBeginOffset(chunks))
@@ -10,6 +10,7 @@ import (
 
 	"github.com/biogo/hts/bgzf"
 	"github.com/biogo/hts/bgzf/index"
+	"github.com/biogo/hts/internal"
 
 	"gopkg.in/check.v1"
 )
@@ -144,6 +145,28 @@ func (s *S) TestConceptualCSIv1(c *check.C) {
 	c.Check(unmapped, check.Equals, uint64(0))
 }
 
+func (s *S) TestRefBins(c *check.C) {
+	csi, err := ReadFrom(bytes.NewReader(conceptualCSIv1data))
+	c.Assert(err, check.Equals, nil)
+
+	c.Check(csi.NumRefs(), check.Equals, 1)
+	bins := csi.RefBins(0)
+	c.Check(bins, check.DeepEquals, []BinInfo{
+		{Bin: 0, Left: bgzf.Offset{File: 101, Block: 0}, Chunks: 1},
+	})
+	var chunks int
+	for _, b := range bins {
+		chunks += b.Chunks
+	}
+	c.Check(chunks, check.Equals, 1)
+	c.Check(csi.RefBins(-1), check.IsNil)
+	c.Check(csi.RefBins(1), check.IsNil)
+
+	// The returned bins are copies.
+	bins[0].Chunks = 10
+	c.Check(csi.RefBins(0)[0].Chunks, check.Equals, 1)
+}
+
 // conceptualCSIv2data is an uncompressed CSIv1 for the alignments in the BAM
 // corresponding to:
 //
@@ -416,3 +439,92 @@ func (s *S) TestCSIRoundtrip(c *check.C) {
 		}
 	}
 }
+
+func (s *S) TestReg2Bin(c *check.C) {
+	// With the default shift and depth, CSI binning is equivalent
+	// to BAI binning.
+	for _, test := range []struct{ beg, end int }{
+		{beg: 0, end: 1},
+		{beg: 62914560, end: 69206016},
+		{beg: 73400320, end: 79691776},
+		{beg: 76546048, end: 78643200},
+		{beg: 1 << 20, end: 1<<20 + 1<<17},
+		{beg: 1<<29 - 1, end: 1 << 29},
+	} {
+		got := reg2bin(int64(test.beg), int64(test.end), DefaultShift, DefaultDepth)
+		c.Check(got, check.Equals, internal.BinFor(test.beg, test.end),
+			check.Commentf("Unexpected bin for [%d,%d).", test.beg, test.end),
+		)
+	}
+}
+
+func (s *S) TestReadFromCorrupt(c *check.C) {
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "zero depth",
+			data: withUint32(conceptualCSIv1data, 8, 0),
+			err:  "csi: invalid index depth value: 0",
+		},
+		{
+			name: "deep index",
+			data: withUint32(conceptualCSIv1data, 8, 11),
+			err:  "csi: invalid index depth value: 11",
+		},
+		{
+			name: "zero shift",
+			data: withUint32(conceptualCSIv1data, 4, 0),
+			err:  "csi: invalid minimum shift value: 0",
+		},
+		{
+			name: "wide coordinates",
+			data: withUint32(conceptualCSIv1data, 4, 50),
+			err:  `csi: minimum shift and depth out of range: 50\+3\*5 > 62`,
+		},
+		{
+			name: "invalid bin",
+			data: withUint32(conceptualCSIv1data, 24, 1<<20),
+			err:  "csi: invalid bin number: 1048576 >= 37449",
+		},
+	} {
+		_, err := ReadFrom(bytes.NewReader(test.data))
+		c.Check(err, check.ErrorMatches, test.err, check.Commentf("Test %q", test.name))
+	}
+
+	// Truncated and garbled indexes must not cause a panic
+	// when read or queried.
+	query := func(data []byte) {
+		defer func() {
+			r := recover()
+			c.Check(r, check.Equals, nil, check.Commentf("panic for % x", data))
+		}()
+		idx, err := ReadFrom(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for rid := 0; rid < idx.NumRefs(); rid++ {
+			idx.Querier().Chunks(rid, 0, 1<<26)
+			idx.ReferenceStats(rid)
+		}
+	}
+	for i := range conceptualCSIv1data {
+		query(conceptualCSIv1data[:i])
+		for _, b := range []byte{0x00, 0x7f, 0x80, 0xff} {
+			data := append([]byte(nil), conceptualCSIv1data...)
+			data[i] = b
+			query(data)
+		}
+	}
+}
+
+func withUint32(data []byte, off int, v uint32) []byte {
+	data = append([]byte(nil), data...)
+	data[off] = byte(v)
+	data[off+1] = byte(v >> 8)
+	data[off+2] = byte(v >> 16)
+	data[off+3] = byte(v >> 24)
+	return data
+}
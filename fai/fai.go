@@ -32,6 +32,38 @@ type Index map[string]Record
 // NewIndex returns a new Index constructed from the FASTA sequence
 // in the provided io.Reader.
 func NewIndex(fasta io.Reader) (Index, error) {
+	idx := make(Index)
+	ixr := NewIndexer(fasta)
+	for ixr.Next() {
+		rec := ixr.Record()
+		idx[rec.Name] = rec
+	}
+	err := ixr.Err()
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Indexer constructs FAI index records from a FASTA sequence stream,
+// returning each Record as the end of its sequence is reached.
+type Indexer struct {
+	sc *bufio.Scanner
+
+	seen map[string]bool
+
+	rec    Record
+	next   Record
+	offset int64
+
+	wantDescLine bool
+	done         bool
+
+	err error
+}
+
+// NewIndexer returns a new Indexer that reads FASTA sequence from fasta.
+func NewIndexer(fasta io.Reader) *Indexer {
 	sc := bufio.NewScanner(fasta)
 	sc.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -45,70 +77,107 @@ func NewIndex(fasta io.Reader) (Index, error) {
 		}
 		return 0, nil, nil
 	})
+	return &Indexer{sc: sc, seen: make(map[string]bool)}
+}
 
-	idx := make(Index)
-	var (
-		rec          Record
-		offset       int64
-		wantDescLine bool
-	)
-	for sc.Scan() {
-		b := bytes.TrimSpace(sc.Bytes())
-		if len(b) == 0 {
-			continue
+// Next advances the Indexer to the next complete sequence, which will then
+// be available through the Record method. It returns false when the
+// end of the input is reached or an error occurs. Records completed before
+// an invalid line are returned before Next returns false. After Next returns
+// false, the Err method will return any error that occurred during indexing.
+func (ixr *Indexer) Next() bool {
+	if ixr.done {
+		return false
+	}
+	for ixr.sc.Scan() {
+		ok := ixr.line(ixr.sc.Bytes())
+		if ixr.err != nil {
+			ixr.done = true
+			return ok
 		}
-		if bytes.Equal(b, []byte{'>'}) {
-			return nil, fmt.Errorf("fai: missing sequence name at %d", offset)
+		if ok {
+			return true
 		}
-		if b[0] == '>' {
-			if rec.Name != "" {
-				idx[rec.Name] = rec
-				rec = Record{}
-			}
-			lenID := bytes.IndexAny(b, " \t")
-			if lenID < 0 {
-				rec.Name = string(b[1:])
-			} else {
-				rec.Name = string(b[1:lenID])
-			}
-			if _, exists := idx[rec.Name]; exists {
-				return nil, fmt.Errorf("fai: duplicate sequence identifier %s at %d", rec.Name, offset)
-			}
-			rec.Start = offset + int64(len(sc.Bytes()))
-			wantDescLine = false
+	}
+	ixr.done = true
+	ixr.err = ixr.sc.Err()
+	if ixr.err != nil || ixr.rec.Name == "" {
+		return false
+	}
+	ixr.next, ixr.rec = ixr.rec, Record{}
+	return true
+}
+
+// line processes a single line of FASTA input. It returns true if the
+// line completes a sequence record, even if the line is itself invalid.
+func (ixr *Indexer) line(line []byte) (complete bool) {
+	defer func() { ixr.offset += int64(len(line)) }()
+
+	b := bytes.TrimSpace(line)
+	if len(b) == 0 {
+		return false
+	}
+	rec := &ixr.rec
+	if bytes.Equal(b, []byte{'>'}) {
+		ixr.err = fmt.Errorf("fai: missing sequence name at %d", ixr.offset)
+		return false
+	}
+	if b[0] == '>' {
+		if rec.Name != "" {
+			ixr.next = *rec
+			*rec = Record{}
+			complete = true
+		}
+		lenID := bytes.IndexAny(b, " \t")
+		if lenID < 0 {
+			rec.Name = string(b[1:])
 		} else {
-			if wantDescLine {
-				return nil, fmt.Errorf("fai: unexpected short line before offset %d", offset)
-			}
-			switch {
-			case rec.BytesPerLine == 0:
-				rec.BytesPerLine = len(sc.Bytes())
-			case len(sc.Bytes()) > rec.BytesPerLine:
-				return nil, fmt.Errorf("fai: unexpected long line at offset %d", offset)
-			case len(sc.Bytes()) < rec.BytesPerLine:
-				wantDescLine = true
-			}
-			switch {
-			case len(b) == 0:
-				// Do nothing.
-			case rec.BasesPerLine == 0:
-				rec.BasesPerLine = len(b)
-			case len(b) > rec.BasesPerLine:
-				return nil, fmt.Errorf("fai: unexpected long line at offset %d", offset)
-			case len(b) < rec.BasesPerLine:
-				wantDescLine = true
-			}
-			rec.Length += len(b)
+			rec.Name = string(b[1:lenID])
+		}
+		if ixr.seen[rec.Name] {
+			ixr.err = fmt.Errorf("fai: duplicate sequence identifier %s at %d", rec.Name, ixr.offset)
+			return complete
 		}
-		offset += int64(len(sc.Bytes()))
+		ixr.seen[rec.Name] = true
+		rec.Start = ixr.offset + int64(len(line))
+		ixr.wantDescLine = false
+		return complete
 	}
-	if rec.Name != "" {
-		idx[rec.Name] = rec
-		rec = Record{}
+
+	if ixr.wantDescLine {
+		ixr.err = fmt.Errorf("fai: unexpected short line before offset %d", ixr.offset)
+		return false
 	}
-	return idx, sc.Err()
+	switch {
+	case rec.BytesPerLine == 0:
+		rec.BytesPerLine = len(line)
+	case len(line) > rec.BytesPerLine:
+		ixr.err = fmt.Errorf("fai: unexpected long line at offset %d", ixr.offset)
+		return false
+	case len(line) < rec.BytesPerLine:
+		ixr.wantDescLine = true
+	}
+	switch {
+	case len(b) == 0:
+		// Do nothing.
+	case rec.BasesPerLine == 0:
+		rec.BasesPerLine = len(b)
+	case len(b) > rec.BasesPerLine:
+		ixr.err = fmt.Errorf("fai: unexpected long line at offset %d", ixr.offset)
+		return false
+	case len(b) < rec.BasesPerLine:
+		ixr.wantDescLine = true
+	}
+	rec.Length += len(b)
+	return false
 }
 
+// Record returns the most recent index Record completed by a call to Next.
+func (ixr *Indexer) Record() Record { return ixr.next }
+
+// Err returns the first error that was encountered by the Indexer.
+func (ixr *Indexer) Err() error { return ixr.err }
+
 // Record is a single FAI index record.
 type Record struct {
 	// Name is the name of the sequence.
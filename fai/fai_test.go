@@ -166,6 +166,53 @@ TTCATTAATCATTTGTACTGGATCTGTTCG
 	}
 }
 
+func TestIndexer(t *testing.T) {
+	const fasta = `>FN654386.1
+TTTTTCAAAGACGTTAAGAGCATCAAACAGAATCATTTTGTTCTCGGATGAGAAGCTGAA
+TCGTGTTGCTTCTCGGTCATACCAAAGACC
+>FN654386.2
+GCGAACTGATGGTCAAGCACAGCTAGAACGTCTTCGTTTGAAGCTGGAGACGATTGCCGCGCAGGCAACT
+CGCCGACATCGACGATGTCTTCGTAGTCAT
+>FN654386.3
+CTGAATTGAGCCGCGGGCGATTGATTCGTGTCGGCGCGTCAGGAGGAAGTTCAAGTCGGAATCTCGCGTT
+TTCATTAATCATTTGTACTGGATCTGTTCG
+`
+	want := []Record{
+		{Name: "FN654386.1", Length: 90, Start: 12, BasesPerLine: 60, BytesPerLine: 61},
+		{Name: "FN654386.2", Length: 100, Start: 116, BasesPerLine: 70, BytesPerLine: 71},
+		{Name: "FN654386.3", Length: 100, Start: 230, BasesPerLine: 70, BytesPerLine: 71},
+	}
+
+	var got []Record
+	ixr := NewIndexer(strings.NewReader(fasta))
+	for ixr.Next() {
+		got = append(got, ixr.Record())
+	}
+	if err := ixr.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected records:\ngot: %#v\nwant:%#v", got, want)
+	}
+	if ixr.Next() {
+		t.Error("unexpected record after end of input")
+	}
+
+	// Records completed before an error are returned.
+	ixr = NewIndexer(strings.NewReader(fasta + ">FN654386.2\nACGT\n"))
+	got = got[:0]
+	for ixr.Next() {
+		got = append(got, ixr.Record())
+	}
+	wantErr := errors.New("fai: duplicate sequence identifier FN654386.2 at 332")
+	if !reflect.DeepEqual(ixr.Err(), wantErr) {
+		t.Errorf("unexpected error: got:%v want:%v", ixr.Err(), wantErr)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected records before error:\ngot: %#v\nwant:%#v", got, want)
+	}
+}
+
 func TestReadFrom(t *testing.T) {
 	for i, test := range []struct {
 		in  string
@@ -0,0 +1,105 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// auxValueSize is the size of fixed-size
+// BAM auxiliary field values by type.
+var auxValueSize = [256]int{
+	'A': 1,
+	'c': 1, 'C': 1,
+	's': 2, 'S': 2,
+	'i': 4, 'I': 4,
+	'f': 4,
+}
+
+// ParseAux returns the auxiliary fields held in the BAM encoded auxiliary
+// data in aux. Each returned field holds the tag, type and value of the
+// field, without the terminating zero of Z and H fields. The returned
+// fields share memory with aux. Errors returned by ParseAux are not
+// prefixed with a package name.
+func ParseAux[T ~[]byte](aux []byte) ([]T, error) {
+	if len(aux) == 0 {
+		return nil, nil
+	}
+
+	// Heuristically pre-allocate enough slots for the byte data.
+	// Value chosen by experimentation and will not fit all inputs,
+	// with the cost being over-allocation.
+	aa := make([]T, 0, len(aux)/4)
+
+	for len(aux) != 0 {
+		if len(aux) < 3 {
+			return nil, errors.New("truncated aux field")
+		}
+		var n int
+		switch t := aux[2]; t {
+		case 'Z', 'H':
+			n = bytes.IndexByte(aux[3:], 0)
+			if n < 0 {
+				return nil, errors.New("invalid zero terminated aux data: no zero")
+			}
+			aa = append(aa, T(aux[:3+n:3+n]))
+			aux = aux[3+n+1:]
+			continue
+		case 'B':
+			if len(aux) < 8 || auxValueSize[aux[3]] == 0 {
+				return nil, errors.New("invalid aux array")
+			}
+			n = 5 + int(binary.LittleEndian.Uint32(aux[4:]))*auxValueSize[aux[3]]
+		default:
+			n = auxValueSize[t]
+			if n == 0 {
+				return nil, fmt.Errorf("unrecognised aux field type: %q", t)
+			}
+		}
+		if n < 0 || len(aux) < 3+n {
+			return nil, errors.New("truncated aux field")
+		}
+		aa = append(aa, T(aux[:3+n:3+n]))
+		aux = aux[3+n:]
+	}
+	return aa, nil
+}
+
+// ExpandLongCigar returns the CIGAR held in a CG:B,I auxiliary field of aux,
+// and aux with that field removed, if cigar is a <seqLen>S<reflen>N
+// placeholder. BAM records can hold at most 65535 CIGAR operations, so
+// records with more operations are stored with a placeholder CIGAR and the
+// true CIGAR in a CG:B,I field. Otherwise cigar and aux are returned
+// unaltered. The backing array of aux is modified when the field is removed.
+func ExpandLongCigar[C ~uint32, A ~[]byte](cigar []C, seqLen int, aux []A) ([]C, []A) {
+	const softClip = 4 // BAM encoding of the S operation.
+	if len(cigar) == 0 {
+		return cigar, aux
+	}
+	if first := cigar[0]; first&0xf != softClip || int(first>>4) != seqLen {
+		return cigar, aux
+	}
+	for i, a := range aux {
+		if len(a) < 3 || a[0] != 'C' || a[1] != 'G' {
+			continue
+		}
+		if len(a) < 8 || a[2] != 'B' || a[3] != 'I' {
+			return cigar, aux
+		}
+		n := int(binary.LittleEndian.Uint32(a[4:8]))
+		if len(a) != 8+n*4 {
+			return cigar, aux
+		}
+		co := make([]C, n)
+		for j := range co {
+			co[j] = C(binary.LittleEndian.Uint32(a[8+j*4:]))
+		}
+		return co, append(aux[:i], aux[i+1:]...)
+	}
+	return cigar, aux
+}
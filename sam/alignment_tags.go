@@ -0,0 +1,214 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sam
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OriginalAlignment is an alignment held in an OA auxiliary field,
+// recording the alignment of a record before it was realigned.
+type OriginalAlignment struct {
+	// Ref is the name of the reference.
+	Ref string
+
+	// Pos is the 0-based leftmost
+	// position of the alignment.
+	Pos int
+
+	// Strand is 1 for a forward strand
+	// alignment and -1 for a reverse
+	// strand alignment.
+	Strand int8
+
+	Cigar Cigar
+	MapQ  byte
+
+	// NM is the edit distance of the
+	// alignment, or -1 if it is absent.
+	NM int
+}
+
+// SupplementaryAlignment is an alignment segment held in an SA auxiliary
+// field, describing another part of a chimeric alignment.
+type SupplementaryAlignment struct {
+	// Ref is the name of the reference.
+	Ref string
+
+	// Pos is the 0-based leftmost
+	// position of the alignment.
+	Pos int
+
+	// Strand is 1 for a forward strand
+	// alignment and -1 for a reverse
+	// strand alignment.
+	Strand int8
+
+	Cigar Cigar
+	MapQ  byte
+
+	// NM is the edit distance of
+	// the alignment.
+	NM int
+}
+
+var (
+	oaTag = NewTag("OA")
+	saTag = NewTag("SA")
+)
+
+// ParseOA returns the original alignments held in the OA:Z auxiliary
+// field a. Each alignment in the field is a comma-separated list of
+// RNAME, POS, strand, CIGAR, MAPQ and NM, terminated by a semicolon,
+// where the NM value may be empty.
+func ParseOA(a Aux) ([]OriginalAlignment, error) {
+	return parseAlignments(a, oaTag, true)
+}
+
+// FormatOA returns an OA:Z auxiliary field holding the given original
+// alignments. It is the inverse of ParseOA.
+func FormatOA(alns []OriginalAlignment) (Aux, error) {
+	return formatAlignments(oaTag, alns, true)
+}
+
+// ParseSA returns the chimeric alignment segments held in the SA:Z
+// auxiliary field a. Each segment in the field is a comma-separated
+// list of RNAME, POS, strand, CIGAR, MAPQ and NM, terminated by a
+// semicolon.
+func ParseSA(a Aux) ([]SupplementaryAlignment, error) {
+	if len(a) > 3 && a[len(a)-1] != ';' {
+		return nil, errors.New("sam: missing terminal semicolon in SA aux field")
+	}
+	alns, err := parseAlignments(a, saTag, false)
+	if err != nil {
+		return nil, err
+	}
+	segs := make([]SupplementaryAlignment, len(alns))
+	for i, aln := range alns {
+		segs[i] = SupplementaryAlignment(aln)
+	}
+	return segs, nil
+}
+
+// FormatSA returns an SA:Z auxiliary field holding the given chimeric
+// alignment segments. It is the inverse of ParseSA.
+func FormatSA(segs []SupplementaryAlignment) (Aux, error) {
+	alns := make([]OriginalAlignment, len(segs))
+	for i, seg := range segs {
+		alns[i] = OriginalAlignment(seg)
+	}
+	return formatAlignments(saTag, alns, false)
+}
+
+// parseAlignments returns the alignments held in the Z type auxiliary
+// field a with the given tag. If optionalNM is true, alignments may have
+// an empty NM value, which is returned as -1.
+func parseAlignments(a Aux, tag Tag, optionalNM bool) ([]OriginalAlignment, error) {
+	if len(a) < 3 || a.Tag() != tag || a.Type() != 'Z' {
+		return nil, fmt.Errorf("sam: not an %s:Z aux field", tag)
+	}
+	text := a[3:]
+	if len(text) == 0 {
+		return nil, fmt.Errorf("sam: empty %s aux field", tag)
+	}
+	text = bytes.TrimSuffix(text, []byte{';'})
+	var alns []OriginalAlignment
+	for _, e := range bytes.Split(text, []byte{';'}) {
+		aln, err := parseAlignment(e, tag, optionalNM)
+		if err != nil {
+			return nil, err
+		}
+		alns = append(alns, aln)
+	}
+	return alns, nil
+}
+
+// parseAlignment returns the alignment described by a single entry of
+// an auxiliary field with the given tag, with the trailing semicolon
+// removed.
+func parseAlignment(e []byte, tag Tag, optionalNM bool) (OriginalAlignment, error) {
+	f := bytes.Split(e, []byte{','})
+	if len(f) != 6 {
+		return OriginalAlignment{}, fmt.Errorf("sam: invalid number of fields in %s entry %q: %d", tag, e, len(f))
+	}
+	if len(f[0]) == 0 {
+		return OriginalAlignment{}, fmt.Errorf("sam: missing reference name in %s entry %q", tag, e)
+	}
+	aln := OriginalAlignment{Ref: string(f[0]), NM: -1}
+
+	pos, err := strconv.Atoi(string(f[1]))
+	if err != nil || !validPos(pos-1) {
+		return OriginalAlignment{}, fmt.Errorf("sam: invalid position in %s entry %q", tag, e)
+	}
+	aln.Pos = pos - 1
+
+	switch string(f[2]) {
+	case "+":
+		aln.Strand = 1
+	case "-":
+		aln.Strand = -1
+	default:
+		return OriginalAlignment{}, fmt.Errorf("sam: invalid strand in %s entry %q", tag, e)
+	}
+
+	aln.Cigar, err = ParseCigar(f[3])
+	if err != nil {
+		return OriginalAlignment{}, fmt.Errorf("sam: invalid cigar in %s entry %q: %w", tag, e, err)
+	}
+
+	mapQ, err := strconv.ParseUint(string(f[4]), 10, 8)
+	if err != nil {
+		return OriginalAlignment{}, fmt.Errorf("sam: invalid mapping quality in %s entry %q", tag, e)
+	}
+	aln.MapQ = byte(mapQ)
+
+	if len(f[5]) != 0 || !optionalNM {
+		aln.NM, err = strconv.Atoi(string(f[5]))
+		if err != nil || aln.NM < 0 {
+			return OriginalAlignment{}, fmt.Errorf("sam: invalid edit distance in %s entry %q", tag, e)
+		}
+	}
+	return aln, nil
+}
+
+// formatAlignments returns a Z type auxiliary field with the given tag
+// holding the given alignments. If optionalNM is true, alignments with
+// an NM of -1 are written with an empty NM value.
+func formatAlignments(tag Tag, alns []OriginalAlignment, optionalNM bool) (Aux, error) {
+	if len(alns) == 0 {
+		return nil, fmt.Errorf("sam: no alignments for %s aux field", tag)
+	}
+	var buf bytes.Buffer
+	for i, aln := range alns {
+		if aln.Ref == "" || strings.ContainsAny(aln.Ref, ",;") {
+			return nil, fmt.Errorf("sam: invalid reference name for %s alignment %d: %q", tag, i, aln.Ref)
+		}
+		if !validPos(aln.Pos) {
+			return nil, fmt.Errorf("sam: invalid position for %s alignment %d: %d", tag, i, aln.Pos)
+		}
+		var strand byte
+		switch aln.Strand {
+		case 1:
+			strand = '+'
+		case -1:
+			strand = '-'
+		default:
+			return nil, fmt.Errorf("sam: invalid strand for %s alignment %d: %d", tag, i, aln.Strand)
+		}
+		if aln.NM < 0 && !(optionalNM && aln.NM == -1) {
+			return nil, fmt.Errorf("sam: invalid edit distance for %s alignment %d: %d", tag, i, aln.NM)
+		}
+		fmt.Fprintf(&buf, "%s,%d,%c,%v,%d,", aln.Ref, aln.Pos+1, strand, aln.Cigar, aln.MapQ)
+		if aln.NM >= 0 {
+			buf.WriteString(strconv.Itoa(aln.NM))
+		}
+		buf.WriteByte(';')
+	}
+	return NewAux(tag, buf.String())
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"unsafe"
 )
@@ -339,6 +340,7 @@ var (
 	versionTag    = Tag{'V', 'N'}
 	sortOrderTag  = Tag{'S', 'O'}
 	groupOrderTag = Tag{'G', 'O'}
+	subSortTag    = Tag{'S', 'S'}
 
 	refDictTag       = Tag{'S', 'Q'}
 	refNameTag       = Tag{'S', 'N'}
@@ -394,6 +396,83 @@ func (a Aux) Type() byte { return a[2] }
 // Returned values are in {'A', 'i', 'f', 'Z', 'H', 'B'}.
 func (a Aux) Kind() byte { return auxKind[a[2]] }
 
+// SetValue sets the value of the auxiliary tag to v, returning the updated
+// Aux. Acceptable value types are those accepted by NewAux. If a is an
+// integer tag and v is an integer that fits within the range of a's type,
+// or the encoding of v has the same length as a, v is written into a's
+// backing array and a is returned. Otherwise a newly allocated Aux holding
+// v is returned and a is not altered.
+func (a Aux) SetValue(v interface{}) (Aux, error) {
+	if a.Kind() == 'i' {
+		if i, ok := auxInt(v); ok && fitsAuxInt(a.Type(), i) {
+			switch a.Type() {
+			case 'c', 'C':
+				a[3] = byte(i)
+			case 's', 'S':
+				binary.LittleEndian.PutUint16(a[3:5], uint16(i))
+			case 'i', 'I':
+				binary.LittleEndian.PutUint32(a[3:7], uint32(i))
+			}
+			return a, nil
+		}
+	}
+	n, err := NewAux(a.Tag(), v)
+	if err != nil {
+		return nil, err
+	}
+	if len(n) != len(a) {
+		return n, nil
+	}
+	copy(a, n)
+	return a, nil
+}
+
+// auxInt returns the value of v as an int64 if v is an integer type
+// accepted by NewAux.
+func auxInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case uint:
+		if v > math.MaxUint32 {
+			return 0, false
+		}
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// fitsAuxInt returns whether i can be represented by the integer aux type typ.
+func fitsAuxInt(typ byte, i int64) bool {
+	switch typ {
+	case 'c':
+		return math.MinInt8 <= i && i <= math.MaxInt8
+	case 'C':
+		return 0 <= i && i <= math.MaxUint8
+	case 's':
+		return math.MinInt16 <= i && i <= math.MaxInt16
+	case 'S':
+		return 0 <= i && i <= math.MaxUint16
+	case 'i':
+		return math.MinInt32 <= i && i <= math.MaxInt32
+	case 'I':
+		return 0 <= i && i <= math.MaxUint32
+	}
+	return false
+}
+
 // Value returns v containing the value of the auxiliary tag.
 func (a Aux) Value() interface{} {
 	switch t := a.Type(); t {
@@ -468,6 +547,17 @@ func (a Aux) Value() interface{} {
 	}
 }
 
+// equalAux returns whether a and b have the same tag and value.
+// Integer values are compared independently of their encoded width.
+func equalAux(a, b Aux) bool {
+	if a.Kind() == 'i' && b.Kind() == 'i' {
+		av, _ := auxInt(a.Value())
+		bv, _ := auxInt(b.Value())
+		return a.Tag() == b.Tag() && av == bv
+	}
+	return bytes.Equal(a, b)
+}
+
 func (a Aux) matches(tag []byte) bool {
 	return a[1] == tag[1] && a[0] == tag[0]
 }
@@ -485,3 +575,18 @@ func (a AuxFields) Get(tag Tag) Aux {
 	}
 	return nil
 }
+
+// SortByTag sorts the auxiliary fields by their two byte tag. Sorting is
+// stable and considers only the tag, so the payload of fields does not
+// affect the resulting order.
+func (a AuxFields) SortByTag() {
+	sort.Stable(auxByTag(a))
+}
+
+type auxByTag []Aux
+
+func (a auxByTag) Len() int { return len(a) }
+func (a auxByTag) Less(i, j int) bool {
+	return a[i][0] < a[j][0] || (a[i][0] == a[j][0] && a[i][1] < a[j][1])
+}
+func (a auxByTag) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
@@ -12,6 +12,36 @@ import (
 // Cigar is a set of CIGAR operations.
 type Cigar []CigarOp
 
+// NewCigar returns a Cigar holding the given CIGAR operations.
+func NewCigar(ops ...CigarOp) Cigar {
+	return append(Cigar(nil), ops...)
+}
+
+// CigarPair is a CIGAR operation type and length pair used to
+// build a Cigar with BuildCigar.
+type CigarPair struct {
+	Op  CigarOpType
+	Len int
+}
+
+// BuildCigar returns a Cigar built from the given operation type and
+// length pairs. Unlike NewCigarOp, BuildCigar returns an error rather
+// than panicking if a length is not positive or does not fit in the
+// 28-bit BAM length field, or if an operation type is invalid.
+func BuildCigar(pairs ...CigarPair) (Cigar, error) {
+	c := make(Cigar, len(pairs))
+	for i, p := range pairs {
+		if p.Op >= lastCigar {
+			return nil, fmt.Errorf("sam: invalid CIGAR operation type at %d: %d", i, p.Op)
+		}
+		if p.Len < 1 || p.Len > 1<<28-1 {
+			return nil, fmt.Errorf("sam: CIGAR operation length out of range at %d: %d", i, p.Len)
+		}
+		c[i] = NewCigarOp(p.Op, p.Len)
+	}
+	return c, nil
+}
+
 // IsValid returns whether the CIGAR string is valid for a record of the given
 // sequence length. Validity is defined by the sum of query consuming operations
 // matching the given length, clipping operations only being present at the ends
@@ -39,6 +69,41 @@ func (c Cigar) IsValid(length int) bool {
 	return length == 0
 }
 
+// EqualTo returns whether c and o describe the same alignment after
+// adjacent operations of the same type are merged and zero-length
+// operations are removed, so 10M5M is equal to 15M. Operation types
+// are compared exactly, so 15M is not equal to 15=.
+func (c Cigar) EqualTo(o Cigar) bool {
+	a, b := c.merged(), o.merged()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// merged returns the operations of c with adjacent operations of the same
+// type merged and zero-length operations removed. Lengths are held as int
+// since merged operations may exceed the length limit of a CigarOp.
+func (c Cigar) merged() []CigarPair {
+	var m []CigarPair
+	for _, co := range c {
+		if co.Len() == 0 {
+			continue
+		}
+		if n := len(m); n != 0 && m[n-1].Op == co.Type() {
+			m[n-1].Len += co.Len()
+			continue
+		}
+		m = append(m, CigarPair{Op: co.Type(), Len: co.Len()})
+	}
+	return m
+}
+
 // String returns the CIGAR string for c.
 func (c Cigar) String() string {
 	if len(c) == 0 {
@@ -64,6 +129,27 @@ func (c Cigar) Lengths() (ref, read int) {
 	return ref, read
 }
 
+// Counts returns the number of operations of each CigarOpType in the Cigar.
+// Operation types not present in c are not included in the returned map.
+func (c Cigar) Counts() map[CigarOpType]int {
+	n := make(map[CigarOpType]int)
+	for _, co := range c {
+		n[co.Type()]++
+	}
+	return n
+}
+
+// BaseCounts returns the total length of operations of each CigarOpType
+// in the Cigar. Operation types not present in c are not included in the
+// returned map.
+func (c Cigar) BaseCounts() map[CigarOpType]int {
+	n := make(map[CigarOpType]int)
+	for _, co := range c {
+		n[co.Type()] += co.Len()
+	}
+	return n
+}
+
 // CigarOp is a single CIGAR operation including the operation type and the
 // length of the operation.
 type CigarOp uint32
@@ -131,11 +217,51 @@ func (ct CigarOpType) String() string {
 	return cigarOps[ct]
 }
 
+// ConsumeFor returns the CIGAR operation alignment consumption characteristics
+// for the CigarOpType t. It differs from t.Consumes in returning the zero Consume
+// for invalid CigarOpTypes rather than panicking.
+func ConsumeFor(t CigarOpType) Consume {
+	if t > lastCigar {
+		t = lastCigar
+	}
+	return consume[t]
+}
+
 // Consume describes how CIGAR operations consume alignment bases.
 type Consume struct {
 	Query, Reference int
 }
 
+// String returns a string representation of the Consume, naming the
+// consumed sequences joined by a '+', for example "query+ref" for
+// CigarMatch. Backward consumption is prefixed with a '-' and a Consume
+// that consumes neither sequence is represented as "none".
+func (c Consume) String() string {
+	var s string
+	for _, part := range []struct {
+		name string
+		n    int
+	}{
+		{name: "query", n: c.Query},
+		{name: "ref", n: c.Reference},
+	} {
+		if part.n == 0 {
+			continue
+		}
+		if s != "" {
+			s += "+"
+		}
+		if part.n < 0 {
+			s += "-"
+		}
+		s += part.name
+	}
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
 // A few years ago, Complete Genomics (CG) proposed to add a new CIGAR
 // operator 'B' for an operation of moving backward along the reference
 // genome. It is the opposite of the 'N', the reference skip. In a later
@@ -175,7 +301,7 @@ type Consume struct {
 // not need to care too much about how remove_B() is implemented.
 //
 // http://sourceforge.net/p/samtools/mailman/message/28463294/
-var consume = []Consume{
+var consume = [...]Consume{
 	CigarMatch:       {Query: 1, Reference: 1},
 	CigarInsertion:   {Query: 1, Reference: 0},
 	CigarDeletion:    {Query: 0, Reference: 1},
@@ -221,8 +347,10 @@ func atoi(b []byte) (int, error) {
 // ParseCigar returns a Cigar parsed from the provided byte slice.
 // ParseCigar will break CIGAR operations longer than 2^28-1 into
 // multiple operations summing to the same length.
+// An absent CIGAR, either "*" or an empty or nil slice, is returned
+// as a nil Cigar and a nil error.
 func ParseCigar(b []byte) (Cigar, error) {
-	if len(b) == 1 && b[0] == '*' {
+	if len(b) == 0 || (len(b) == 1 && b[0] == '*') {
 		return nil, nil
 	}
 	var (
@@ -58,3 +58,39 @@ func (f Flags) String() string {
 
 	return string(b)
 }
+
+// IsPaired returns whether the Paired flag is set.
+func (f Flags) IsPaired() bool { return f&Paired != 0 }
+
+// IsProperPair returns whether the ProperPair flag is set.
+func (f Flags) IsProperPair() bool { return f&ProperPair != 0 }
+
+// IsUnmapped returns whether the Unmapped flag is set.
+func (f Flags) IsUnmapped() bool { return f&Unmapped != 0 }
+
+// IsMateUnmapped returns whether the MateUnmapped flag is set.
+func (f Flags) IsMateUnmapped() bool { return f&MateUnmapped != 0 }
+
+// IsReverse returns whether the Reverse flag is set.
+func (f Flags) IsReverse() bool { return f&Reverse != 0 }
+
+// IsMateReverse returns whether the MateReverse flag is set.
+func (f Flags) IsMateReverse() bool { return f&MateReverse != 0 }
+
+// IsRead1 returns whether the Read1 flag is set.
+func (f Flags) IsRead1() bool { return f&Read1 != 0 }
+
+// IsRead2 returns whether the Read2 flag is set.
+func (f Flags) IsRead2() bool { return f&Read2 != 0 }
+
+// IsSecondary returns whether the Secondary flag is set.
+func (f Flags) IsSecondary() bool { return f&Secondary != 0 }
+
+// IsQCFail returns whether the QCFail flag is set.
+func (f Flags) IsQCFail() bool { return f&QCFail != 0 }
+
+// IsDuplicate returns whether the Duplicate flag is set.
+func (f Flags) IsDuplicate() bool { return f&Duplicate != 0 }
+
+// IsSupplementary returns whether the Supplementary flag is set.
+func (f Flags) IsSupplementary() bool { return f&Supplementary != 0 }
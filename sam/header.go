@@ -10,6 +10,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -23,6 +26,7 @@ var (
 	errInvalidReadGroup = errors.New("sam: read group not owned by header")
 	errInvalidProgram   = errors.New("sam: program not owned by header")
 	errBadLen           = errors.New("sam: reference length out of range")
+	errEmptyRefName     = errors.New("sam: empty reference name")
 )
 
 // SortOrder indicates the sort order of a SAM or BAM file.
@@ -82,7 +86,9 @@ var (
 	}
 )
 
-// String returns the string representation of a GroupOrder.
+// String returns the string representation of a GroupOrder. Both
+// GroupUnspecified and GroupNone are represented as "none"; a Header
+// distinguishes them by only emitting a GO tag for GroupNone.
 func (g GroupOrder) String() string {
 	if g < GroupNone || g > GroupReference {
 		return groupOrder[GroupUnspecified]
@@ -97,7 +103,16 @@ type Header struct {
 	Version    string
 	SortOrder  SortOrder
 	GroupOrder GroupOrder
-	otherTags  []tagPair
+
+	// SubSort is the sub-sorting order of
+	// alignments, specified by the SS tag as
+	// the sort order followed by a colon and
+	// the sub-sort scheme, for example
+	// "coordinate:queryname". SubSort is
+	// empty if no sub-sorting is specified.
+	SubSort string
+
+	otherTags []tagPair
 
 	refs       []*Reference
 	rgs        []*ReadGroup
@@ -126,9 +141,15 @@ func NewHeader(text []byte, r []*Reference) (*Header, error) {
 		seenProgs:  set{},
 	}
 	for i, r := range bh.refs {
+		if _, ok := bh.seenRefs[r.name]; ok {
+			return nil, errDupReference
+		}
 		if r.owner != nil || r.id >= 0 {
 			return nil, errUsedReference
 		}
+		bh.seenRefs[r.name] = int32(i)
+	}
+	for i, r := range bh.refs {
 		r.owner = bh
 		r.id = int32(i)
 	}
@@ -141,8 +162,90 @@ func NewHeader(text []byte, r []*Reference) (*Header, error) {
 	return bh, nil
 }
 
+// NewHeaderFull returns a new Header based on the given text and lists
+// of References, ReadGroups and Programs. All the References, ReadGroups
+// and Programs are checked for prior use and for name uniqueness before
+// any are added to the Header. If there is a conflict between the text
+// and the given References, ReadGroups or Programs NewHeaderFull will
+// return a non-nil error. On error none of the References, ReadGroups
+// or Programs are left owned by a Header, so they may be reused.
+func NewHeaderFull(text []byte, refs []*Reference, rgs []*ReadGroup, progs []*Program) (*Header, error) {
+	seenRefs := make(set, len(refs))
+	for i, r := range refs {
+		if _, ok := seenRefs[r.name]; ok {
+			return nil, errDupReference
+		}
+		if r.owner != nil || r.id >= 0 {
+			return nil, errUsedReference
+		}
+		seenRefs[r.name] = int32(i)
+	}
+	seenGroups := make(set, len(rgs))
+	for i, rg := range rgs {
+		if _, ok := seenGroups[rg.name]; ok {
+			return nil, errDupReadGroup
+		}
+		if rg.owner != nil || rg.id >= 0 {
+			return nil, errUsedReadGroup
+		}
+		seenGroups[rg.name] = int32(i)
+	}
+	seenProgs := make(set, len(progs))
+	for i, p := range progs {
+		if _, ok := seenProgs[p.uid]; ok {
+			return nil, errDupProgram
+		}
+		if p.owner != nil || p.id >= 0 {
+			return nil, errUsedProgram
+		}
+		seenProgs[p.uid] = int32(i)
+	}
+
+	bh := &Header{
+		refs:       refs,
+		rgs:        rgs,
+		progs:      progs,
+		seenRefs:   seenRefs,
+		seenGroups: seenGroups,
+		seenProgs:  seenProgs,
+	}
+	for i, r := range refs {
+		r.owner = bh
+		r.id = int32(i)
+	}
+	for i, rg := range rgs {
+		rg.owner = bh
+		rg.id = int32(i)
+	}
+	for i, p := range progs {
+		p.owner = bh
+		p.id = int32(i)
+	}
+	if text != nil {
+		err := bh.UnmarshalText(text)
+		if err != nil {
+			// Release the given values so that
+			// they may be used by another Header.
+			for _, r := range refs {
+				r.owner = nil
+				r.id = -1
+			}
+			for _, rg := range rgs {
+				rg.owner = nil
+				rg.id = -1
+			}
+			for _, p := range progs {
+				p.owner = nil
+				p.id = -1
+			}
+			return nil, err
+		}
+	}
+	return bh, nil
+}
+
 // Tags applies the function fn to each of the tag-value pairs of the Header.
-// The SO and GO tags are only used if they are set to the non-default values.
+// The SO, GO and SS tags are only used if they are set to the non-default values.
 // The function fn must not add or delete tags held by the receiver during
 // iteration.
 func (bh *Header) Tags(fn func(t Tag, value string)) {
@@ -153,14 +256,52 @@ func (bh *Header) Tags(fn func(t Tag, value string)) {
 	if bh.SortOrder != UnknownOrder {
 		fn(sortOrderTag, bh.SortOrder.String())
 	}
-	if bh.GroupOrder != GroupNone {
+	if bh.GroupOrder != GroupUnspecified {
 		fn(groupOrderTag, bh.GroupOrder.String())
 	}
+	if bh.SubSort != "" {
+		fn(subSortTag, bh.SubSort)
+	}
 	for _, tp := range bh.otherTags {
 		fn(tp.tag, tp.value)
 	}
 }
 
+// VersionNumber returns the major and minor components of the Header's
+// Version. If the Version is not of the form MAJOR.MINOR, where both
+// components are decimal integers, ok is false. Malformed versions are
+// not rejected when a header is parsed, so VersionNumber may be used to
+// check the version before relying on version-dependent features.
+func (bh *Header) VersionNumber() (major, minor int, ok bool) {
+	majorText, minorText, found := strings.Cut(bh.Version, ".")
+	if !found {
+		return 0, 0, false
+	}
+	major, ok = parseVersionComponent(majorText)
+	if !ok {
+		return 0, 0, false
+	}
+	minor, ok = parseVersionComponent(minorText)
+	if !ok {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// parseVersionComponent parses a non-empty string of decimal digits.
+func parseVersionComponent(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, c := range []byte(s) {
+		if c < '0' || '9' < c {
+			return 0, false
+		}
+	}
+	v, err := strconv.Atoi(s)
+	return v, err == nil
+}
+
 // Get returns the string representation of the value associated with the
 // given header line tag. If the tag is not present the empty string is returned.
 func (bh *Header) Get(t Tag) string {
@@ -170,7 +311,12 @@ func (bh *Header) Get(t Tag) string {
 	case sortOrderTag:
 		return bh.SortOrder.String()
 	case groupOrderTag:
+		if bh.GroupOrder == GroupUnspecified {
+			return ""
+		}
 		return bh.GroupOrder.String()
+	case subSortTag:
+		return bh.SubSort
 	}
 	for _, tp := range bh.otherTags {
 		if t == tp.tag {
@@ -211,6 +357,8 @@ func (bh *Header) Set(t Tag, value string) error {
 			return errBadHeader
 		}
 		bh.GroupOrder = groupOrder
+	case subSortTag:
+		bh.SubSort = value
 	default:
 		if value == "" {
 			for i, tp := range bh.otherTags {
@@ -239,6 +387,7 @@ func (bh *Header) Clone() *Header {
 		Version:    bh.Version,
 		SortOrder:  bh.SortOrder,
 		GroupOrder: bh.GroupOrder,
+		SubSort:    bh.SubSort,
 		otherTags:  append([]tagPair(nil), bh.otherTags...),
 		Comments:   append([]string(nil), bh.Comments...),
 		seenRefs:   make(set, len(bh.seenRefs)),
@@ -289,9 +438,9 @@ func (bh *Header) Clone() *Header {
 // MergeHeaders returns a new Header resulting from the merge of the
 // source Headers, and a mapping between the references in the source
 // and the References in the returned Header. Sort order is set to
-// unknown and group order is set to none. If a single Header is passed
-// to MergeHeaders, the mapping between source and destination headers,
-// reflink, is returned as nil.
+// unknown, group order is set to none and sub-sort order is cleared.
+// If a single Header is passed to MergeHeaders, the mapping between
+// source and destination headers, reflink, is returned as nil.
 // The returned Header contains the read groups and programs of the
 // first Header in src.
 func MergeHeaders(src []*Header) (h *Header, reflinks [][]*Reference, err error) {
@@ -305,6 +454,7 @@ func MergeHeaders(src []*Header) (h *Header, reflinks [][]*Reference, err error)
 	h = src[0].Clone()
 	h.SortOrder = UnknownOrder
 	h.GroupOrder = GroupUnspecified
+	h.SubSort = ""
 	for i, add := range src {
 		if i == 0 {
 			reflinks[i] = h.refs
@@ -337,31 +487,55 @@ func MergeHeaders(src []*Header) (h *Header, reflinks [][]*Reference, err error)
 
 // MarshalText implements the encoding.TextMarshaler interface.
 func (bh *Header) MarshalText() ([]byte, error) {
+	return bh.marshalText(false), nil
+}
+
+// MarshalTextSorted returns the SAM text encoding of the Header as for
+// MarshalText, but with the non-standard tags of each @HD, @SQ, @RG and
+// @PG line sorted by tag. This gives reproducible output for equal
+// Headers that were constructed with tags set in different orders.
+func (bh *Header) MarshalTextSorted() ([]byte, error) {
+	return bh.marshalText(true), nil
+}
+
+func (bh *Header) marshalText(sorted bool) []byte {
 	var buf bytes.Buffer
 	if bh.Version != "" {
-		if bh.GroupOrder == GroupUnspecified {
-			fmt.Fprintf(&buf, "@HD\tVN:%s\tSO:%s", bh.Version, bh.SortOrder)
-		} else {
-			fmt.Fprintf(&buf, "@HD\tVN:%s\tSO:%s\tGO:%s", bh.Version, bh.SortOrder, bh.GroupOrder)
+		fmt.Fprintf(&buf, "@HD\tVN:%s\tSO:%s", bh.Version, bh.SortOrder)
+		if bh.SubSort != "" {
+			fmt.Fprintf(&buf, "\tSS:%s", bh.SubSort)
 		}
-		for _, tp := range bh.otherTags {
-			fmt.Fprintf(&buf, "\t%s:%s", tp.tag, tp.value)
+		if bh.GroupOrder != GroupUnspecified {
+			fmt.Fprintf(&buf, "\tGO:%s", bh.GroupOrder)
 		}
+		writeTagPairs(&buf, bh.otherTags, sorted)
 		buf.WriteByte('\n')
 	}
 	for _, r := range bh.refs {
-		fmt.Fprintf(&buf, "%s\n", r)
+		fmt.Fprintf(&buf, "%s\n", r.format(sorted))
 	}
 	for _, rg := range bh.rgs {
-		fmt.Fprintf(&buf, "%s\n", rg)
+		fmt.Fprintf(&buf, "%s\n", rg.format(sorted))
 	}
 	for _, p := range bh.progs {
-		fmt.Fprintf(&buf, "%s\n", p)
+		fmt.Fprintf(&buf, "%s\n", p.format(sorted))
 	}
 	for _, co := range bh.Comments {
 		fmt.Fprintf(&buf, "@CO\t%s\n", co)
 	}
-	return buf.Bytes(), nil
+	return buf.Bytes()
+}
+
+// writeTagPairs writes the tab-separated tag pairs in tags to buf,
+// in tag order if sorted is true and otherwise in the order held.
+func writeTagPairs(buf *bytes.Buffer, tags []tagPair, sorted bool) {
+	if sorted && len(tags) > 1 {
+		tags = append(tagPairs(nil), tags...)
+		sort.Stable(tagPairs(tags))
+	}
+	for _, tp := range tags {
+		fmt.Fprintf(buf, "\t%s:%s", tp.tag, tp.value)
+	}
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler.
@@ -466,20 +640,84 @@ func (bh *Header) Refs() []*Reference {
 	return bh.refs
 }
 
+// ReferenceByName returns the Reference in the Header with the given
+// name and true. If no Reference has the name, nil and false are returned.
+func (bh *Header) ReferenceByName(name string) (*Reference, bool) {
+	id, ok := bh.seenRefs[name]
+	if !ok || id < 0 || int(id) >= len(bh.refs) {
+		return nil, false
+	}
+	return bh.refs[id], true
+}
+
 // RGs returns the Header's list of ReadGroups. The returned slice
 // should not be altered.
 func (bh *Header) RGs() []*ReadGroup {
 	return bh.rgs
 }
 
+// ReadGroupsBySample returns the Header's ReadGroups keyed by their
+// sample (SM) names. Read groups without a sample name are held under
+// the empty string. The order of ReadGroups for each sample follows
+// the order of the Header's read groups.
+func (bh *Header) ReadGroupsBySample() map[string][]*ReadGroup {
+	m := make(map[string][]*ReadGroup)
+	for _, rg := range bh.rgs {
+		m[rg.sample] = append(m[rg.sample], rg)
+	}
+	return m
+}
+
+// IsUnaligned returns whether the Header has no References, as is the case
+// for unaligned SAM and BAM (uBAM) files.
+func (bh *Header) IsUnaligned() bool {
+	return len(bh.refs) == 0
+}
+
 // Progs returns the Header's list of Programs. The returned slice
 // should not be altered.
 func (bh *Header) Progs() []*Program {
 	return bh.progs
 }
 
-// AddReference adds r to the Header.
+// ProcessedBy returns the Program in the Header with the given program
+// name (PN) and true, indicating that the named program has processed
+// the data. If more than one Program has the name, the one furthest along
+// its chain of previous program (PP) links is returned, with ties broken
+// by the latest in the header. If no Program has the name, nil and false
+// are returned.
+func (bh *Header) ProcessedBy(name string) (*Program, bool) {
+	byUID := make(map[string]*Program, len(bh.progs))
+	for _, p := range bh.progs {
+		byUID[p.uid] = p
+	}
+	var (
+		found *Program
+		depth = -1
+	)
+	for _, p := range bh.progs {
+		if p.name != name {
+			continue
+		}
+		// Count the links to the start of the chain,
+		// guarding against cycles.
+		d := 0
+		for q := byUID[p.previous]; q != nil && d < len(bh.progs); q = byUID[q.previous] {
+			d++
+		}
+		if d >= depth {
+			found, depth = p, d
+		}
+	}
+	return found, found != nil
+}
+
+// AddReference adds r to the Header. References with an empty name
+// are rejected.
 func (bh *Header) AddReference(r *Reference) error {
+	if r.name == "" {
+		return errEmptyRefName
+	}
 	if dupID, dup := bh.seenRefs[r.name]; dup {
 		er := bh.refs[dupID]
 		if equalRefs(er, r) {
@@ -512,6 +750,19 @@ func (bh *Header) AddReference(r *Reference) error {
 	return nil
 }
 
+// AddReferenceClone adds a clone of r to the Header and returns the
+// Reference held by the Header. Unlike AddReference, r is not altered,
+// so it may be shared between Headers. If the Header already holds an
+// equal Reference with the same name, that Reference is returned.
+func (bh *Header) AddReferenceClone(r *Reference) (*Reference, error) {
+	cr := r.Clone()
+	err := bh.AddReference(cr)
+	if err != nil {
+		return nil, err
+	}
+	return bh.refs[bh.seenRefs[cr.name]], nil
+}
+
 // RemoveReference removes r from the Header and makes it
 // available to add to another Header.
 func (bh *Header) RemoveReference(r *Reference) error {
@@ -519,8 +770,9 @@ func (bh *Header) RemoveReference(r *Reference) error {
 		return errInvalidReference
 	}
 	bh.refs = append(bh.refs[:r.id], bh.refs[r.id+1:]...)
-	for i := range bh.refs[r.id:] {
-		bh.refs[i+int(r.id)].id--
+	for _, rf := range bh.refs[r.id:] {
+		rf.id--
+		bh.seenRefs[rf.name] = rf.id
 	}
 	r.id = -1
 	delete(bh.seenRefs, r.name)
@@ -0,0 +1,131 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package sam
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+var errNotGrouped = errors.New("sam: records not grouped by reference")
+
+// ByReference returns an iterator over the references of the records
+// remaining in the SAM stream, yielding each reference with a pull
+// function that returns the successive records aligned to it. The pull
+// function returns io.EOF when the records for the reference have been
+// exhausted. Records are read from the underlying stream by the pull
+// function, so it is only valid until the loop body returns, and any
+// records not pulled are skipped. Records collected in the loop body may
+// be handed to workers for concurrent processing. Unplaced records are
+// grouped under a nil Reference.
+//
+// The input must have all the records for each reference contiguous, as
+// is the case for coordinate-sorted SAM. If a record for an earlier
+// reference is found after the group has ended, the pull function returns
+// an error and iteration stops. If an error is found when reading the
+// first record or while skipping unpulled records, a final reference is
+// yielded with a pull function that returns the error.
+//
+//	for ref, pull := range r.ByReference() {
+//		var recs []*sam.Record
+//		for {
+//			rec, err := pull()
+//			if err == io.EOF {
+//				break
+//			}
+//			if err != nil {
+//				return err
+//			}
+//			recs = append(recs, rec)
+//		}
+//		go process(ref, recs)
+//	}
+func (r *Reader) ByReference() iter.Seq2[*Reference, func() (*Record, error)] {
+	return func(yield func(*Reference, func() (*Record, error)) bool) {
+		g := refGroup{r: r, seen: make(map[*Reference]bool)}
+		var err error
+		g.next, err = r.Read()
+		for {
+			if err != nil {
+				if err != io.EOF {
+					yield(nil, func() (*Record, error) { return nil, err })
+				}
+				return
+			}
+			g.ref = g.next.Ref
+			g.seen[g.ref] = true
+			g.done = false
+			g.err = nil
+			if !yield(g.ref, g.pull) {
+				return
+			}
+
+			// Skip any records that were not pulled.
+			pulled := g.done
+			for !g.done {
+				g.pull()
+			}
+			switch {
+			case g.err != io.EOF && pulled:
+				// The error has been returned by pull.
+				return
+			case g.err != io.EOF:
+				err = g.err
+				ref := g.ref
+				if g.next != nil {
+					ref = g.next.Ref
+				}
+				yield(ref, func() (*Record, error) { return nil, err })
+				return
+			case g.next == nil:
+				return
+			}
+		}
+	}
+}
+
+// refGroup holds the state of a reference group for ByReference.
+type refGroup struct {
+	r    *Reader
+	ref  *Reference
+	seen map[*Reference]bool
+
+	// next is the record read ahead
+	// from r that is yet to be returned.
+	next *Record
+
+	// done and err indicate the end of
+	// the group and its cause. err is
+	// io.EOF at the normal end of a group.
+	done bool
+	err  error
+}
+
+func (g *refGroup) pull() (*Record, error) {
+	if g.done {
+		return nil, g.err
+	}
+	if g.next == nil {
+		rec, err := g.r.Read()
+		if err != nil {
+			g.done, g.err = true, err
+			return nil, err
+		}
+		g.next = rec
+	}
+	if g.next.Ref != g.ref {
+		g.done, g.err = true, io.EOF
+		if g.seen[g.next.Ref] {
+			g.err = errNotGrouped
+		}
+		return nil, g.err
+	}
+	rec := g.next
+	g.next = nil
+	return rec, nil
+}
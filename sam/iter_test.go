@@ -0,0 +1,128 @@
+// Copyright ©2026 The bíogo Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package sam
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestByReference(c *check.C) {
+	const header = "@HD\tVN:1.6\tSO:coordinate\n" +
+		"@SQ\tSN:chr1\tLN:1000\n" +
+		"@SQ\tSN:chr2\tLN:1000\n"
+	rec := func(name, ref string, pos int) string {
+		if ref == "*" {
+			return name + "\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n"
+		}
+		return name + "\t0\t" + ref + "\t" + strconv.Itoa(pos) + "\t30\t4M\t*\t0\t0\tACGT\t*\n"
+	}
+
+	type group struct {
+		ref   string
+		names []string
+	}
+	for _, test := range []struct {
+		name string
+		data string
+
+		// skip is the group whose records are not pulled.
+		skip string
+
+		want []group
+		err  error
+	}{
+		{
+			name: "spec",
+			data: string(specExamples.data),
+			want: []group{{ref: "ref", names: []string{"r001", "r002", "r003", "r004", "r003", "r001"}}},
+		},
+		{
+			name: "grouped",
+			data: header +
+				rec("a", "chr1", 1) + rec("b", "chr1", 2) +
+				rec("c", "chr2", 1) +
+				rec("d", "*", 0) + rec("e", "*", 0),
+			want: []group{
+				{ref: "chr1", names: []string{"a", "b"}},
+				{ref: "chr2", names: []string{"c"}},
+				{ref: "*", names: []string{"d", "e"}},
+			},
+		},
+		{
+			name: "skipped",
+			data: header +
+				rec("a", "chr1", 1) + rec("b", "chr1", 2) +
+				rec("c", "chr2", 1),
+			skip: "chr1",
+			want: []group{
+				{ref: "chr1", names: nil},
+				{ref: "chr2", names: []string{"c"}},
+			},
+		},
+		{
+			name: "ungrouped",
+			data: header +
+				rec("a", "chr1", 1) +
+				rec("b", "chr2", 1) +
+				rec("c", "chr1", 2),
+			want: []group{
+				{ref: "chr1", names: []string{"a"}},
+				{ref: "chr2", names: []string{"b"}},
+			},
+			err: errNotGrouped,
+		},
+		{
+			name: "ungrouped skipped",
+			data: header +
+				rec("a", "chr1", 1) +
+				rec("b", "chr2", 1) + rec("c", "chr2", 2) +
+				rec("d", "chr1", 2),
+			skip: "chr2",
+			want: []group{
+				{ref: "chr1", names: []string{"a"}},
+				{ref: "chr2", names: nil},
+				{ref: "chr1", names: nil},
+			},
+			err: errNotGrouped,
+		},
+	} {
+		r, err := NewReader(bytes.NewReader([]byte(test.data)))
+		c.Assert(err, check.Equals, nil, check.Commentf("%s", test.name))
+
+		var (
+			got    []group
+			gotErr error
+		)
+		for ref, pull := range r.ByReference() {
+			g := group{ref: "*"}
+			if ref != nil {
+				g.ref = ref.Name()
+			}
+			if g.ref == test.skip {
+				got = append(got, g)
+				continue
+			}
+			for {
+				rec, err := pull()
+				if err != nil {
+					if err != io.EOF {
+						gotErr = err
+					}
+					break
+				}
+				g.names = append(g.names, rec.Name)
+			}
+			got = append(got, g)
+		}
+		c.Check(got, check.DeepEquals, test.want, check.Commentf("%s", test.name))
+		c.Check(gotErr, check.Equals, test.err, check.Commentf("%s", test.name))
+	}
+}
@@ -111,6 +111,9 @@ func readRefRecords(r io.Reader, n int32) ([]*Reference, error) {
 		if n != int(lName) || name[n-1] != 0 {
 			return nil, errors.New("sam: truncated reference name")
 		}
+		if n == 1 {
+			return nil, errEmptyRefName
+		}
 		rr[i].name = string(name[:n-1])
 		err = binary.Read(r, binary.LittleEndian, &rr[i].lRef)
 		if err != nil {
@@ -202,6 +205,11 @@ func headerLine(l []byte, bh *Header) error {
 				return errBadHeader
 			}
 			bh.GroupOrder = groupOrderMap[fs]
+		case subSortTag:
+			if bh.SubSort != "" {
+				return errBadHeader
+			}
+			bh.SubSort = fs
 		default:
 			bh.otherTags = append(bh.otherTags, tagPair{tag: t, value: fs})
 		}
@@ -241,6 +249,9 @@ func referenceLine(l []byte, bh *Header) error {
 		fs := string(f[3:])
 		switch t {
 		case refNameTag:
+			if fs == "" {
+				return errEmptyRefName
+			}
 			dupID, dup = bh.seenRefs[fs]
 			rf.name = fs
 			nok = true
@@ -204,6 +204,12 @@ func (p *Program) Set(t Tag, value string) error {
 // String returns a string representation of the program according to the
 // SAM specification section 1.3.
 func (p *Program) String() string {
+	return p.format(false)
+}
+
+// format returns the SAM header line for the program, with the
+// non-standard tags sorted by tag if sorted is true.
+func (p *Program) format(sorted bool) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "@PG\tID:%s", p.uid)
 	if p.name != "" {
@@ -218,8 +224,6 @@ func (p *Program) String() string {
 	if p.version != "" {
 		fmt.Fprintf(&buf, "\tVN:%s", p.version)
 	}
-	for _, tp := range p.otherTags {
-		fmt.Fprintf(&buf, "\t%s:%s", tp.tag, tp.value)
-	}
+	writeTagPairs(&buf, p.otherTags, sorted)
 	return buf.String()
 }
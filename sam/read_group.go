@@ -274,6 +274,12 @@ func (r *ReadGroup) Set(t Tag, value string) error {
 // String returns a string representation of the read group according to the
 // SAM specification section 1.3.
 func (r *ReadGroup) String() string {
+	return r.format(false)
+}
+
+// format returns the SAM header line for the read group, with the
+// non-standard tags sorted by tag if sorted is true.
+func (r *ReadGroup) format(sorted bool) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "@RG\tID:%s", r.name)
 	if r.center != "" {
@@ -309,8 +315,6 @@ func (r *ReadGroup) String() string {
 	if r.sample != "" {
 		fmt.Fprintf(&buf, "\tSM:%s", r.sample)
 	}
-	for _, tp := range r.otherTags {
-		fmt.Fprintf(&buf, "\t%s:%s", tp.tag, tp.value)
-	}
+	writeTagPairs(&buf, r.otherTags, sorted)
 	return buf.String()
 }
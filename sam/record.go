@@ -6,14 +6,22 @@ package sam
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
+	"unsafe"
 
 	"github.com/biogo/hts/internal"
 )
 
 // Record represents a SAM/BAM record.
+//
+// Pos and MatePos are 0-based, as in the BAM encoding, while the POS and PNEXT
+// fields of SAM text are 1-based. An unplaced record has a Pos of -1. The Pos1
+// and End1 methods return 1-based coordinates for use with 1-based tools.
 type Record struct {
 	Name      string
 	Ref       *Reference
@@ -75,11 +83,29 @@ func NewRecord(name string, ref, mRef *Reference, p, mPos, tLen int, mapQ byte,
 	return r, nil
 }
 
+// NewUnmappedRecord returns an unplaced, unmapped Record with the given name,
+// sequence and quality. The returned Record has the Unmapped flag set, nil
+// reference and mate reference, and position and mate position of -1. The
+// quality scores, if not nil, must be the same length as seq and be valid
+// as described by Record.SeqQualValid.
+func NewUnmappedRecord(name string, seq, qual []byte) (*Record, error) {
+	r, err := NewRecord(name, nil, nil, -1, -1, 0, 0, nil, seq, qual, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ok, reason := r.SeqQualValid(); !ok {
+		return nil, fmt.Errorf("sam: %s", reason)
+	}
+	r.Flags = Unmapped
+	return r, nil
+}
+
 // IsValidRecord returns whether the record satisfies the conditions that
 // it has the Unmapped flag set if it not placed; that the MateUnmapped
 // flag is set if it paired its mate is unplaced; that the CIGAR length
-// matches the sequence and quality string lengths if they are non-zero; and
-// that the Paired, ProperPair, Unmapped and MateUnmapped flags are consistent.
+// matches the sequence and quality string lengths if they are non-zero,
+// except that an unmapped record may have no CIGAR; and that the Paired,
+// ProperPair, Unmapped and MateUnmapped flags are consistent.
 func IsValidRecord(r *Record) bool {
 	if (r.Ref == nil || r.Pos == -1) && r.Flags&Unmapped == 0 {
 		return false
@@ -96,12 +122,85 @@ func IsValidRecord(r *Record) bool {
 	if len(r.Qual) != 0 && r.Seq.Length != len(r.Qual) {
 		return false
 	}
-	if r.Seq.Length != 0 && r.Seq.Length != r.queryLen() {
+	if r.Seq.Length != 0 && (len(r.Cigar) != 0 || r.Flags&Unmapped == 0) && r.Seq.Length != r.queryLen() {
 		return false
 	}
 	return true
 }
 
+// maxQual is the largest Phred quality score that can be represented
+// in SAM text format.
+const maxQual = 93
+
+// SeqQualValid returns whether the record's sequence and quality data
+// are internally consistent. The sequence must have a nybble-encoded
+// representation matching its length, and the quality scores must be
+// either absent, with all values set to 0xff, or all within the range
+// [0, 93]. If the data are not valid, a description of the problem is
+// returned.
+func (r *Record) SeqQualValid() (ok bool, reason string) {
+	if r.Seq.Length < 0 {
+		return false, fmt.Sprintf("invalid sequence length: %d", r.Seq.Length)
+	}
+	if len(r.Seq.Seq) != (r.Seq.Length+1)>>1 {
+		return false, fmt.Sprintf("sequence length mismatch: %d bases in %d bytes", r.Seq.Length, len(r.Seq.Seq))
+	}
+	if len(r.Qual) == 0 {
+		return true, ""
+	}
+	if len(r.Qual) != r.Seq.Length {
+		return false, fmt.Sprintf("quality length mismatch: %d != %d", len(r.Qual), r.Seq.Length)
+	}
+	if r.Qual[0] == 0xff {
+		for i, q := range r.Qual {
+			if q != 0xff {
+				return false, fmt.Sprintf("partially absent quality at position %d", i)
+			}
+		}
+		return true, ""
+	}
+	for i, q := range r.Qual {
+		if q > maxQual {
+			return false, fmt.Sprintf("quality out of range at position %d: %d", i, q)
+		}
+	}
+	return true, ""
+}
+
+// hasQual returns whether the record has quality scores. Quality is
+// absent if Qual is empty or its first value is the 0xff sentinel.
+func (r *Record) hasQual() bool {
+	return len(r.Qual) != 0 && r.Qual[0] != 0xff
+}
+
+// MeanQual returns the mean quality score of the record. If the
+// record has no quality scores, MeanQual returns NaN.
+func (r *Record) MeanQual() float64 {
+	if !r.hasQual() {
+		return math.NaN()
+	}
+	var sum int
+	for _, q := range r.Qual {
+		sum += int(q)
+	}
+	return float64(sum) / float64(len(r.Qual))
+}
+
+// MinQual returns the minimum quality score of the record. If the
+// record has no quality scores, MinQual returns 0xff.
+func (r *Record) MinQual() byte {
+	if !r.hasQual() {
+		return 0xff
+	}
+	min := r.Qual[0]
+	for _, q := range r.Qual[1:] {
+		if q < min {
+			min = q
+		}
+	}
+	return min
+}
+
 func (r *Record) queryLen() int {
 	var l int
 	for _, co := range r.Cigar {
@@ -124,6 +223,101 @@ func (r *Record) Tag(tag []byte) (v Aux, ok bool) {
 	return nil, false
 }
 
+// StripAux removes all auxiliary fields with any of the given tags from the
+// Record's AuxFields. The order of the remaining fields is retained.
+func (r *Record) StripAux(tags ...Tag) {
+	r.filterAux(tags, false)
+}
+
+// KeepAux removes all auxiliary fields except those with any of the given
+// tags from the Record's AuxFields. The order of the remaining fields is
+// retained.
+func (r *Record) KeepAux(tags ...Tag) {
+	r.filterAux(tags, true)
+}
+
+// filterAux filters the Record's AuxFields in place, retaining fields
+// whose membership in tags equals keep.
+func (r *Record) filterAux(tags []Tag, keep bool) {
+	n := 0
+	for _, aux := range r.AuxFields {
+		if hasTag(tags, aux.Tag()) == keep {
+			r.AuxFields[n] = aux
+			n++
+		}
+	}
+	for i := n; i < len(r.AuxFields); i++ {
+		r.AuxFields[i] = nil
+	}
+	r.AuxFields = r.AuxFields[:n]
+}
+
+func hasTag(tags []Tag, t Tag) bool {
+	for _, tag := range tags {
+		if tag == t {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkDuplicate sets the Duplicate flag of the Record and records reason
+// in the DT auxiliary field, replacing any existing value. If reason is
+// empty, any existing reason is removed.
+func (r *Record) MarkDuplicate(reason string) {
+	r.MarkDuplicateTag(Tag{'D', 'T'}, reason)
+}
+
+// MarkDuplicateTag is like MarkDuplicate, but records reason in the
+// auxiliary field with the given tag.
+func (r *Record) MarkDuplicateTag(tag Tag, reason string) {
+	r.StripAux(tag)
+	r.Flags |= Duplicate
+	if reason == "" {
+		return
+	}
+	aux, _ := NewAux(tag, reason) // Z values cannot fail.
+	r.AuxFields = append(r.AuxFields, aux)
+}
+
+// DuplicateReason returns the reason recorded by MarkDuplicate in the
+// DT auxiliary field, and whether the Record is marked as a duplicate
+// with a reason.
+func (r *Record) DuplicateReason() (string, bool) {
+	return r.DuplicateReasonTag(Tag{'D', 'T'})
+}
+
+// DuplicateReasonTag is like DuplicateReason, but returns the reason
+// recorded in the auxiliary field with the given tag.
+func (r *Record) DuplicateReasonTag(tag Tag) (string, bool) {
+	if r.Flags&Duplicate == 0 {
+		return "", false
+	}
+	aux := r.AuxFields.Get(tag)
+	if aux == nil || aux.Type() != 'Z' {
+		return "", false
+	}
+	return string(aux[3:]), true
+}
+
+// Sample returns the sample (SM) name of the read group in h named by
+// the Record's RG auxiliary field and true. If the Record has no RG
+// field, the read group is not in h or the read group has no sample
+// name, the empty string and false are returned.
+func (r *Record) Sample(h *Header) (string, bool) {
+	aux := r.AuxFields.Get(readGroupTag)
+	if aux == nil || aux.Type() != 'Z' {
+		return "", false
+	}
+	name := string(aux[3:])
+	for _, rg := range h.RGs() {
+		if rg.name == name {
+			return rg.sample, rg.sample != ""
+		}
+	}
+	return "", false
+}
+
 // RefID returns the reference ID for the Record.
 func (r *Record) RefID() int {
 	return r.Ref.ID()
@@ -134,6 +328,20 @@ func (r *Record) Start() int {
 	return r.Pos
 }
 
+// Pos1 returns the 1-based position of the start of the alignment, as
+// written in the POS field of SAM text. It is zero for an unplaced record.
+func (r *Record) Pos1() int {
+	return r.Pos + 1
+}
+
+// End1 returns the 1-based, closed position of the end of the alignment.
+// Since End returns a 0-based, half-open end, End1 is equal to End, and
+// the 1-based closed interval [Pos1, End1] covers the same bases as the
+// 0-based half-open interval [Start, End).
+func (r *Record) End1() int {
+	return r.End()
+}
+
 // Bin returns the BAM index bin of the record.
 func (r *Record) Bin() int {
 	if r.Flags&(Unmapped|MateUnmapped) == Unmapped|MateUnmapped {
@@ -147,6 +355,21 @@ func (r *Record) Len() int {
 	return r.End() - r.Start()
 }
 
+// ReadLen returns the length of the read. The length is taken from the
+// first available of the sequence, the query-consuming operations of the
+// CIGAR, and the quality scores, in that order, so a length is available
+// when the sequence has been omitted during reading. ReadLen returns zero
+// if none of these is present.
+func (r *Record) ReadLen() int {
+	if r.Seq.Length != 0 {
+		return r.Seq.Length
+	}
+	if l := r.queryLen(); l != 0 {
+		return l
+	}
+	return len(r.Qual)
+}
+
 func max(a, b int) int {
 	if a < b {
 		return b
@@ -154,6 +377,262 @@ func max(a, b int) int {
 	return a
 }
 
+// AlignmentStrings returns the gapped alignment of the Record's sequence
+// against ref, the sequence of the Record's reference starting at position
+// zero. Insertions are shown as '-' in refAln and deletions and skipped
+// reference regions as '-' in readAln. Padding is shown as '*' in both.
+// Clipped bases are not included. AlignmentStrings returns an error if the
+// Record is unmapped, has no sequence, has an invalid CIGAR or extends
+// beyond the end of ref.
+func (r *Record) AlignmentStrings(ref []byte) (refAln, readAln []byte, err error) {
+	if r.Flags&Unmapped != 0 || r.Pos < 0 || len(r.Cigar) == 0 {
+		return nil, nil, errors.New("sam: record is not aligned")
+	}
+	if r.Seq.Length == 0 {
+		return nil, nil, errors.New("sam: record has no sequence")
+	}
+	if !r.Cigar.IsValid(r.Seq.Length) {
+		return nil, nil, errors.New("sam: invalid cigar for sequence")
+	}
+	if r.End() > len(ref) {
+		return nil, nil, fmt.Errorf("sam: alignment end %d beyond reference length %d", r.End(), len(ref))
+	}
+	seq := r.Seq.Expand()
+	pos, q := r.Pos, 0
+	for _, co := range r.Cigar {
+		n := co.Len()
+		switch co.Type() {
+		case CigarMatch, CigarEqual, CigarMismatch:
+			refAln = append(refAln, ref[pos:pos+n]...)
+			readAln = append(readAln, seq[q:q+n]...)
+		case CigarInsertion:
+			refAln = append(refAln, bytes.Repeat([]byte{'-'}, n)...)
+			readAln = append(readAln, seq[q:q+n]...)
+		case CigarDeletion, CigarSkipped:
+			refAln = append(refAln, ref[pos:pos+n]...)
+			readAln = append(readAln, bytes.Repeat([]byte{'-'}, n)...)
+		case CigarPadded:
+			refAln = append(refAln, bytes.Repeat([]byte{'*'}, n)...)
+			readAln = append(readAln, bytes.Repeat([]byte{'*'}, n)...)
+		case CigarSoftClipped, CigarHardClipped:
+			// Clipped bases are not part of the alignment.
+		default:
+			return nil, nil, fmt.Errorf("sam: unsupported cigar operation: %v", co.Type())
+		}
+		con := co.Type().Consumes()
+		pos += n * con.Reference
+		q += n * con.Query
+	}
+	return refAln, readAln, nil
+}
+
+// EditDistance returns the edit distance of the Record's sequence from
+// ref, the sequence of the Record's reference starting at position zero,
+// as recorded by the NM auxiliary field. The distance is the number of
+// mismatched, inserted and deleted bases; skipped reference regions,
+// clipping and padding do not contribute. Following samtools, an aligned
+// base is a match only if it is '=' or has the same IUPAC code as the
+// reference base, ignoring case, and neither base is N. EditDistance
+// returns an error under the same conditions as AlignmentStrings.
+func (r *Record) EditDistance(ref []byte) (int, error) {
+	if r.Flags&Unmapped != 0 || r.Pos < 0 || len(r.Cigar) == 0 {
+		return 0, errors.New("sam: record is not aligned")
+	}
+	if r.Seq.Length == 0 {
+		return 0, errors.New("sam: record has no sequence")
+	}
+	if !r.Cigar.IsValid(r.Seq.Length) {
+		return 0, errors.New("sam: invalid cigar for sequence")
+	}
+	if r.End() > len(ref) {
+		return 0, fmt.Errorf("sam: alignment end %d beyond reference length %d", r.End(), len(ref))
+	}
+	seq := r.Seq.Expand()
+	var nm int
+	pos, q := r.Pos, 0
+	for _, co := range r.Cigar {
+		n := co.Len()
+		switch co.Type() {
+		case CigarMatch, CigarEqual, CigarMismatch:
+			for i := 0; i < n; i++ {
+				b, rb := n16Table[seq[q+i]], n16Table[ref[pos+i]]
+				if b == 0 || (b == rb && b != 0xf) {
+					continue
+				}
+				nm++
+			}
+		case CigarInsertion, CigarDeletion:
+			nm += n
+		case CigarSkipped, CigarPadded, CigarSoftClipped, CigarHardClipped:
+		default:
+			return 0, fmt.Errorf("sam: unsupported cigar operation: %v", co.Type())
+		}
+		con := co.Type().Consumes()
+		pos += n * con.Reference
+		q += n * con.Query
+	}
+	return nm, nil
+}
+
+// SplitAt returns the Record's CIGAR partitioned at the 0-based reference
+// position refPos. The alignment described by left starts at Pos and covers
+// [Pos, refPos), and the alignment described by right starts at refPos and
+// covers [refPos, End()). Query bases aligned on the other side of refPos
+// are soft-clipped, so both CIGARs remain valid for the Record's sequence,
+// and hard clips are retained at the ends of the read. An insertion at
+// refPos is soft-clipped in both CIGARs, and a deletion or skipped region
+// spanning refPos is divided between them. If the Record is unmapped or
+// refPos is not strictly within the alignment, ok is false.
+func (r *Record) SplitAt(refPos int) (left, right Cigar, ok bool) {
+	if r.Flags&Unmapped != 0 || len(r.Cigar) == 0 || refPos <= r.Pos || refPos >= r.End() {
+		return nil, nil, false
+	}
+
+	// Partition the operations at refPos, dividing
+	// any operation that spans the split.
+	var before, after Cigar
+	pos := r.Pos
+	for _, co := range r.Cigar {
+		t, n := co.Type(), co.Len()
+		m := n * t.Consumes().Reference
+		switch {
+		case pos+m <= refPos && pos < refPos:
+			before = append(before, co)
+		case pos >= refPos:
+			after = append(after, co)
+		default:
+			before = append(before, NewCigarOp(t, refPos-pos))
+			after = append(after, NewCigarOp(t, pos+m-refPos))
+		}
+		pos += m
+	}
+
+	// Insertions and padding adjacent to the split
+	// become part of the clipped region.
+	var clipped int
+	for len(before) != 0 && before[len(before)-1].Type().Consumes().Reference == 0 {
+		co := before[len(before)-1]
+		clipped += co.Len() * co.Type().Consumes().Query
+		before = before[:len(before)-1]
+	}
+	for len(after) != 0 && after[0].Type().Consumes().Reference == 0 {
+		co := after[0]
+		clipped += co.Len() * co.Type().Consumes().Query
+		after = after[1:]
+	}
+
+	var leadHard, trailHard Cigar
+	var leadQuery int
+	for _, co := range before {
+		if co.Type() == CigarHardClipped {
+			leadHard = append(leadHard, co)
+			continue
+		}
+		leadQuery += co.Len() * co.Type().Consumes().Query
+	}
+	var trailQuery int
+	for _, co := range after {
+		if co.Type() == CigarHardClipped {
+			trailHard = append(trailHard, co)
+			continue
+		}
+		trailQuery += co.Len() * co.Type().Consumes().Query
+	}
+
+	left = append(left, before...)
+	if n := clipped + trailQuery; n != 0 {
+		left = append(left, NewCigarOp(CigarSoftClipped, n))
+	}
+	left = append(left, trailHard...)
+
+	right = append(right, leadHard...)
+	if n := leadQuery + clipped; n != 0 {
+		right = append(right, NewCigarOp(CigarSoftClipped, n))
+	}
+	right = append(right, after...)
+
+	return left, right, true
+}
+
+// RepairMates makes the mate information of the paired records r1 and r2
+// consistent with each other. The MateRef and MatePos fields of each record
+// are set from the Ref and Pos of the other, the MateUnmapped and MateReverse
+// flags are set from the other's Unmapped and Reverse flags, and the Paired
+// flag is set on both. The TempLen fields are set to the signed observed
+// template length as returned by TemplateLength, or to zero if it is
+// undefined. RepairMates returns the names of the fields it changed,
+// prefixed by "r1." or "r2.", with flags named as "r1.Flags.Paired".
+func RepairMates(r1, r2 *Record) []string {
+	var changed []string
+	repairMate(&changed, "r1.", r1, r2)
+	repairMate(&changed, "r2.", r2, r1)
+
+	t1, _ := TemplateLength(r1, r2)
+	t2 := -t1
+	if r1.TempLen != t1 {
+		r1.TempLen = t1
+		changed = append(changed, "r1.TempLen")
+	}
+	if r2.TempLen != t2 {
+		r2.TempLen = t2
+		changed = append(changed, "r2.TempLen")
+	}
+	return changed
+}
+
+// TemplateLength returns the signed observed template length of r1 given its
+// mate r2, measured from the leftmost mapped base to the rightmost mapped base
+// of the pair. The returned length is positive if r1 is the leftmost record and
+// negative if it is the rightmost; if the records start at the same position,
+// r1 is considered leftmost. Overlapping and contained pairs are measured from
+// their outermost coordinates. If either record is unmapped or the records are
+// mapped to different references, the template length is undefined and
+// TemplateLength returns 0 and false.
+func TemplateLength(r1, r2 *Record) (int, bool) {
+	if r1.Flags&Unmapped != 0 || r2.Flags&Unmapped != 0 || r1.Ref == nil || r1.Ref != r2.Ref {
+		return 0, false
+	}
+	beg, end := r1.Pos, r1.End()
+	if r2.Pos < beg {
+		beg = r2.Pos
+	}
+	if e := r2.End(); e > end {
+		end = e
+	}
+	if r2.Pos < r1.Pos {
+		return beg - end, true
+	}
+	return end - beg, true
+}
+
+// repairMate sets the mate fields of r from mate, recording the
+// names of changed fields in changed with the given prefix.
+func repairMate(changed *[]string, prefix string, r, mate *Record) {
+	if r.MateRef != mate.Ref {
+		r.MateRef = mate.Ref
+		*changed = append(*changed, prefix+"MateRef")
+	}
+	if r.MatePos != mate.Pos {
+		r.MatePos = mate.Pos
+		*changed = append(*changed, prefix+"MatePos")
+	}
+	for _, f := range []struct {
+		flag Flags
+		name string
+		want bool
+	}{
+		{flag: Paired, name: "Paired", want: true},
+		{flag: MateUnmapped, name: "MateUnmapped", want: mate.Flags&Unmapped != 0},
+		{flag: MateReverse, name: "MateReverse", want: mate.Flags&Reverse != 0},
+	} {
+		if (r.Flags&f.flag != 0) == f.want {
+			continue
+		}
+		r.Flags ^= f.flag
+		*changed = append(*changed, prefix+"Flags."+f.name)
+	}
+}
+
 // End returns the highest query-consuming coordinate end of the alignment.
 // The position returned by End is not valid if r.Cigar.IsValid(r.Seq.Length)
 // is false.
@@ -170,6 +649,16 @@ func (r *Record) End() int {
 	return end
 }
 
+// Overlaps returns whether the alignment of r overlaps the half-open
+// interval [beg,end) on ref. Overlaps returns false if r is unmapped
+// or the interval is empty.
+func (r *Record) Overlaps(ref *Reference, beg, end int) bool {
+	if r.Flags&Unmapped != 0 || r.Ref == nil || r.Ref != ref || beg >= end {
+		return false
+	}
+	return r.Pos < end && beg < r.End()
+}
+
 // Strand returns an int8 indicating the strand of the alignment. A positive return indicates
 // alignment in the forward orientation, a negative returns indicates alignment in the reverse
 // orientation.
@@ -181,24 +670,94 @@ func (r *Record) Strand() int8 {
 }
 
 // LessByName returns true if the receiver sorts by record name before other.
+// Names are compared lexically by byte value.
 func (r *Record) LessByName(other *Record) bool {
 	return r.Name < other.Name
 }
 
-// LessByCoordinate returns true if the receiver sorts by coordinate before other
-// according to the SAM specification.
+// LessByCoordinate returns true if the receiver sorts by coordinate before other.
+// Records are ordered by reference name, compared lexically by byte value, and
+// then by position. Records without a reference, including those with a nil Ref,
+// sort after all records with a reference and are equal to each other. Note that
+// reference names are not ordered by their position in a header; callers that
+// require header order should compare Ref.ID values.
 func (r *Record) LessByCoordinate(other *Record) bool {
 	rRefName := r.Ref.Name()
 	oRefName := other.Ref.Name()
 	switch {
-	case oRefName == "*":
-		return true
 	case rRefName == "*":
 		return false
+	case oRefName == "*":
+		return true
 	}
 	return (rRefName < oRefName) || (rRefName == oRefName && r.Pos < other.Pos)
 }
 
+// Equal returns whether the receiver and o describe the same alignment.
+// Records are compared by value rather than by identity: references are
+// compared by name and length, sequences are compared as bases, absent
+// and all-missing (0xff) qualities are equal, and auxiliary fields are
+// compared by tag and value without regard to their order. Integer
+// auxiliary fields are equal if their values are equal, independent of
+// their encoded width.
+func (r *Record) Equal(o *Record) bool {
+	if r == o {
+		return true
+	}
+	if r == nil || o == nil {
+		return false
+	}
+	if r.Name != o.Name ||
+		r.Flags != o.Flags ||
+		r.Pos != o.Pos ||
+		r.MapQ != o.MapQ ||
+		r.MatePos != o.MatePos ||
+		r.TempLen != o.TempLen ||
+		!sameRef(r.Ref, o.Ref) ||
+		!sameRef(r.MateRef, o.MateRef) {
+		return false
+	}
+	if len(r.Cigar) != len(o.Cigar) {
+		return false
+	}
+	for i, co := range r.Cigar {
+		if co != o.Cigar[i] {
+			return false
+		}
+	}
+	if r.Seq.Length != o.Seq.Length || !bytes.Equal(r.Seq.Expand(), o.Seq.Expand()) {
+		return false
+	}
+	if missingQual(r.Qual) != missingQual(o.Qual) || (!missingQual(r.Qual) && !bytes.Equal(r.Qual, o.Qual)) {
+		return false
+	}
+	if len(r.AuxFields) != len(o.AuxFields) {
+		return false
+	}
+	for _, a := range r.AuxFields {
+		b := o.AuxFields.Get(a.Tag())
+		if b == nil || !equalAux(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRef returns whether a and b have the same name and length.
+func sameRef(a, b *Reference) bool {
+	return a.Name() == b.Name() && a.Len() == b.Len()
+}
+
+// missingQual returns whether q holds no quality scores.
+func missingQual(q []byte) bool {
+	for _, v := range q {
+		if v != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
 // String returns a string representation of the Record.
 func (r *Record) String() string {
 	end := r.End()
@@ -231,8 +790,20 @@ func (r *Record) UnmarshalText(b []byte) error {
 // references from the provided Header. If a nil Header is passed to UnmarshalSAM
 // and the SAM data include non-empty refence and mate reference names, fake
 // references with zero length and an ID of -1 are created to hold the reference
-// names.
+// names. Errors in parsing individual fields are returned as a *RecordParseError.
 func (r *Record) UnmarshalSAM(h *Header, b []byte) error {
+	return r.unmarshalSAM(h, b, false)
+}
+
+// UnmarshalSAMStrict parses a SAM format alignment line in the provided []byte,
+// using references from the provided Header. It differs from UnmarshalSAM in
+// that fake references are never created; if h is nil, any reference or mate
+// reference name other than "*" is an error.
+func (r *Record) UnmarshalSAMStrict(h *Header, b []byte) error {
+	return r.unmarshalSAM(h, b, true)
+}
+
+func (r *Record) unmarshalSAM(h *Header, b []byte, strict bool) error {
 	f := bytes.Split(b, []byte{'\t'})
 	if len(f) < 11 {
 		return errors.New("sam: missing SAM fields")
@@ -241,43 +812,43 @@ func (r *Record) UnmarshalSAM(h *Header, b []byte) error {
 	// TODO(kortschak): Consider parsing string format flags.
 	flags, err := strconv.ParseUint(string(f[1]), 0, 16)
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse flags: %w", err)
+		return parseError(1, "flags", f[1], err)
 	}
 	r.Flags = Flags(flags)
-	r.Ref, err = referenceForName(h, string(f[2]))
+	r.Ref, err = referenceForName(h, string(f[2]), strict)
 	if err != nil {
-		return fmt.Errorf("sam: failed to assign reference: %w", err)
+		return parseError(2, "reference", f[2], err)
 	}
 	r.Pos, err = strconv.Atoi(string(f[3]))
 	r.Pos--
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse position: %w", err)
+		return parseError(3, "position", f[3], err)
 	}
 	mapQ, err := strconv.ParseUint(string(f[4]), 10, 8)
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse map quality: %w", err)
+		return parseError(4, "map quality", f[4], err)
 	}
 	r.MapQ = byte(mapQ)
 	r.Cigar, err = ParseCigar(f[5])
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse cigar string: %w", err)
+		return parseError(5, "cigar string", f[5], err)
 	}
 	if bytes.Equal(f[2], f[6]) || bytes.Equal(f[6], []byte{'='}) {
 		r.MateRef = r.Ref
 	} else {
-		r.MateRef, err = referenceForName(h, string(f[6]))
+		r.MateRef, err = referenceForName(h, string(f[6]), strict)
 		if err != nil {
-			return fmt.Errorf("sam: failed to assign mate reference: %w", err)
+			return parseError(6, "mate reference", f[6], err)
 		}
 	}
 	r.MatePos, err = strconv.Atoi(string(f[7]))
 	r.MatePos--
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse mate position: %w", err)
+		return parseError(7, "mate position", f[7], err)
 	}
 	r.TempLen, err = strconv.Atoi(string(f[8]))
 	if err != nil {
-		return fmt.Errorf("sam: failed to parse template length: %w", err)
+		return parseError(8, "template length", f[8], err)
 	}
 	if !bytes.Equal(f[9], []byte{'*'}) {
 		r.Seq = NewSeq(f[9])
@@ -304,7 +875,7 @@ func (r *Record) UnmarshalSAM(h *Header, b []byte) error {
 		for i, aux := range f[11:] {
 			a, err := ParseAux(aux)
 			if err != nil {
-				return err
+				return parseError(11+i, "auxiliary field", aux, err)
 			}
 			r.AuxFields[i] = a
 		}
@@ -312,11 +883,39 @@ func (r *Record) UnmarshalSAM(h *Header, b []byte) error {
 	return nil
 }
 
-func referenceForName(h *Header, name string) (*Reference, error) {
+// RecordParseError is the error returned by UnmarshalSAM and UnmarshalSAMStrict
+// when a field of a SAM alignment line cannot be parsed.
+type RecordParseError struct {
+	// Field is the zero-based index of the
+	// tab-separated field that failed to
+	// parse; 1 is FLAG and 3 is POS.
+	Field int
+
+	// Err is the underlying error,
+	// including the offending bytes.
+	Err error
+}
+
+func (e *RecordParseError) Error() string {
+	return fmt.Sprintf("sam: field %d: %v", e.Field, e.Err)
+}
+
+func (e *RecordParseError) Unwrap() error { return e.Err }
+
+// parseError returns a *RecordParseError for the named field at index i
+// holding the text b.
+func parseError(i int, name string, b []byte, err error) error {
+	return &RecordParseError{Field: i, Err: fmt.Errorf("failed to parse %s %q: %w", name, b, err)}
+}
+
+func referenceForName(h *Header, name string, strict bool) (*Reference, error) {
 	if name == "*" {
 		return nil, nil
 	}
 	if h == nil {
+		if strict {
+			return nil, fmt.Errorf("no header for reference name %q", name)
+		}
 		// If we don't have a Header, return a fake Reference.
 		return &Reference{
 			id:   -1,
@@ -324,10 +923,8 @@ func referenceForName(h *Header, name string) (*Reference, error) {
 		}, nil
 	}
 
-	for _, r := range h.refs {
-		if r.Name() == name {
-			return r, nil
-		}
+	if r, ok := h.ReferenceByName(name); ok {
+		return r, nil
 	}
 	return nil, fmt.Errorf("no reference with name %q", name)
 }
@@ -338,11 +935,20 @@ func (r *Record) MarshalText() ([]byte, error) {
 }
 
 // MarshalSAM formats a Record as SAM using the specified flag format. Acceptable
-// formats are FlagDecimal, FlagHex and FlagString.
+// formats are FlagDecimal, FlagHex and FlagString, optionally combined with
+// FlagSortedAux.
 func (r *Record) MarshalSAM(flags int) ([]byte, error) {
-	if flags < FlagDecimal || flags > FlagString {
+	return r.marshalSAM(flags, QualityAuto)
+}
+
+// marshalSAM formats a Record as SAM using the specified flag format
+// and quality mode.
+func (r *Record) marshalSAM(flags int, qm QualityMode) ([]byte, error) {
+	if !validFlagFormat(flags) {
 		return nil, errors.New("sam: flag format option out of range")
 	}
+	sortAux := flags&FlagSortedAux != 0
+	flags &^= FlagSortedAux
 	if r.Qual != nil && len(r.Qual) != r.Seq.Length {
 		return nil, errors.New("sam: sequence/quality length mismatch")
 	}
@@ -358,14 +964,258 @@ func (r *Record) MarshalSAM(flags int) ([]byte, error) {
 		r.MatePos+1,
 		r.TempLen,
 		formatSeq(r.Seq),
-		formatQual(r.Qual),
+		formatQual(r.Qual, qm),
 	)
-	for _, t := range r.AuxFields {
+	aux := r.AuxFields
+	if sortAux {
+		aux = append(AuxFields(nil), aux...)
+		aux.SortByTag()
+	}
+	for _, t := range aux {
 		fmt.Fprintf(&buf, "\t%v", samAux(t))
 	}
 	return buf.Bytes(), nil
 }
 
+// MarshalBinary implements the encoding.BinaryMarshaler. It returns the BAM
+// encoding of the Record, using the IDs of r.Ref and r.MateRef as the
+// reference and mate reference IDs.
+func (r *Record) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := r.EncodeBinary(&buf, int32(r.Ref.ID()), int32(r.MateRef.ID()))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bamFixedSize is the size of the fixed length portion of a BAM
+// record following the block_size field.
+const bamFixedSize = 32
+
+// maxCigarOps is the maximum number of CIGAR operations
+// that can be held in the CIGAR field of a BAM record.
+const maxCigarOps = 0xffff
+
+// EncodeBinary writes the BAM encoding of the Record to the given io.Writer,
+// using refID and mateRefID as the reference and mate reference IDs. The
+// format of the encoding, including the leading block_size field, is defined
+// in the SAM specification, section 4.2. If r has more than 65535 CIGAR
+// operations, the CIGAR is written as a <readlen>S<reflen>N placeholder and
+// the complete CIGAR is stored in a CG:B,I auxiliary field.
+//
+// EncodeBinary performs many small writes, so w should be buffered.
+func (r *Record) EncodeBinary(w io.Writer, refID, mateRefID int32) error {
+	if len(r.Name) == 0 || len(r.Name) > 254 {
+		return errors.New("sam: name absent or too long")
+	}
+	if r.Qual != nil && len(r.Qual) != r.Seq.Length {
+		return errors.New("sam: sequence/quality length mismatch")
+	}
+	if len(r.Seq.Seq) != (r.Seq.Length+1)>>1 {
+		return errors.New("sam: sequence length mismatch")
+	}
+	cigar := r.Cigar
+	tags := encodeAux(r.AuxFields)
+	if len(cigar) > maxCigarOps {
+		ref, _ := cigar.Lengths()
+		cigar = Cigar{
+			NewCigarOp(CigarSoftClipped, r.Seq.Length),
+			NewCigarOp(CigarSkipped, ref),
+		}
+		tags = append(tags, longCigarAux(r.Cigar)...)
+	}
+	recLen := bamFixedSize +
+		len(r.Name) + 1 + // Null terminated.
+		len(cigar)<<2 + // CigarOps are 4 bytes.
+		len(r.Seq.Seq) +
+		r.Seq.Length +
+		len(tags)
+	if !validInt32(recLen) {
+		return errors.New("sam: record too long")
+	}
+
+	bin := binaryWriter{w: &errWriter{w: w}}
+
+	// Write record header data.
+	bin.writeInt32(int32(recLen))
+	bin.writeInt32(refID)
+	bin.writeInt32(int32(r.Pos))
+	bin.writeUint8(byte(len(r.Name) + 1))
+	bin.writeUint8(r.MapQ)
+	bin.writeUint16(uint16(r.Bin()))
+	bin.writeUint16(uint16(len(cigar)))
+	bin.writeUint16(uint16(r.Flags))
+	bin.writeInt32(int32(r.Seq.Length))
+	bin.writeInt32(mateRefID)
+	bin.writeInt32(int32(r.MatePos))
+	bin.writeInt32(int32(r.TempLen))
+
+	// Write variable length data.
+	io.WriteString(bin.w, r.Name)
+	bin.writeUint8(0)
+	for _, o := range cigar {
+		bin.writeUint32(uint32(o))
+	}
+	bin.w.Write(*(*[]byte)(unsafe.Pointer(&r.Seq.Seq)))
+	if r.Qual != nil {
+		bin.w.Write(r.Qual)
+	} else {
+		bin.w.Write(bytes.Repeat([]byte{0xff}, r.Seq.Length))
+	}
+	bin.w.Write(tags)
+	return bin.w.err
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler. It calls
+// UnmarshalBAM with a nil Header.
+func (r *Record) UnmarshalBinary(b []byte) error {
+	return r.UnmarshalBAM(nil, b)
+}
+
+// UnmarshalBAM decodes the BAM record encoding in b, including the leading
+// block_size field, as written by EncodeBinary, using references from the
+// provided Header. If a nil Header is passed to UnmarshalBAM, fake references
+// with an empty name, zero length and the encoded reference ID are created
+// so that the reference IDs of the record are retained. A CIGAR stored in a
+// CG:B,I auxiliary field is restored to the Cigar field. The decoded Record
+// does not share memory with b.
+func (r *Record) UnmarshalBAM(h *Header, b []byte) error {
+	if len(b) < 4+bamFixedSize {
+		return errors.New("sam: truncated BAM record")
+	}
+	if int(binary.LittleEndian.Uint32(b)) != len(b)-4 {
+		return errors.New("sam: BAM record block size mismatch")
+	}
+	b = b[4:]
+	le := binary.LittleEndian
+	refID := int32(le.Uint32(b[0:]))
+	nLen := int(b[8])
+	nCigar := int(le.Uint16(b[12:]))
+	lSeq := int(int32(le.Uint32(b[16:])))
+	mateRefID := int32(le.Uint32(b[20:]))
+	*r = Record{
+		Pos:     int(int32(le.Uint32(b[4:]))),
+		MapQ:    b[9],
+		Flags:   Flags(le.Uint16(b[14:])),
+		MatePos: int(int32(le.Uint32(b[24:]))),
+		TempLen: int(int32(le.Uint32(b[28:]))),
+	}
+	b = b[bamFixedSize:]
+
+	if nLen < 1 || lSeq < 0 {
+		return errors.New("sam: invalid BAM record field length")
+	}
+	lDoublets := (lSeq + 1) >> 1
+	if len(b) < nLen+nCigar*4+lDoublets+lSeq {
+		return errors.New("sam: truncated BAM record")
+	}
+	r.Name = string(b[:nLen-1])
+	b = b[nLen:]
+	if nCigar != 0 {
+		r.Cigar = make(Cigar, nCigar)
+		for i := range r.Cigar {
+			r.Cigar[i] = CigarOp(le.Uint32(b[i*4:]))
+		}
+		b = b[nCigar*4:]
+	}
+	if lSeq != 0 {
+		seq := make([]Doublet, lDoublets)
+		for i, d := range b[:lDoublets] {
+			seq[i] = Doublet(d)
+		}
+		r.Seq = Seq{Length: lSeq, Seq: seq}
+		b = b[lDoublets:]
+		r.Qual = append([]byte(nil), b[:lSeq]...)
+		b = b[lSeq:]
+	}
+	var err error
+	r.AuxFields, err = internal.ParseAux[Aux](append([]byte(nil), b...))
+	if err != nil {
+		return fmt.Errorf("sam: %w", err)
+	}
+	r.Cigar, r.AuxFields = internal.ExpandLongCigar(r.Cigar, r.Seq.Length, r.AuxFields)
+
+	r.Ref, err = referenceForID(h, refID)
+	if err != nil {
+		return err
+	}
+	if mateRefID == refID {
+		r.MateRef = r.Ref
+		return nil
+	}
+	r.MateRef, err = referenceForID(h, mateRefID)
+	return err
+}
+
+// referenceForID returns the reference in h with the given ID.
+// If h is nil, a fake reference with the ID is returned.
+func referenceForID(h *Header, id int32) (*Reference, error) {
+	if id == -1 {
+		return nil, nil
+	}
+	if id < -1 {
+		return nil, fmt.Errorf("sam: invalid reference id: %d", id)
+	}
+	if h == nil {
+		return &Reference{id: id}, nil
+	}
+	if int(id) >= len(h.refs) {
+		return nil, fmt.Errorf("sam: reference id out of range: %d", id)
+	}
+	return h.refs[id], nil
+}
+
+// encodeAux returns the BAM encoding of the auxiliary fields in aa.
+func encodeAux(aa []Aux) (aux []byte) {
+	for _, a := range aa {
+		// TODO: validate each 'a'
+		aux = append(aux, []byte(a)...)
+		switch a.Type() {
+		case 'Z', 'H':
+			aux = append(aux, 0)
+		}
+	}
+	return aux
+}
+
+// longCigarAux returns the BAM encoding of a CG:B,I
+// auxiliary field holding the CIGAR operations in co.
+func longCigarAux(co []CigarOp) []byte {
+	aux := make([]byte, 8+len(co)*4)
+	copy(aux, "CGBI")
+	binary.LittleEndian.PutUint32(aux[4:8], uint32(len(co)))
+	for i, o := range co {
+		binary.LittleEndian.PutUint32(aux[8+i*4:], uint32(o))
+	}
+	return aux
+}
+
+type binaryWriter struct {
+	w   *errWriter
+	buf [4]byte
+}
+
+func (w *binaryWriter) writeUint8(v uint8) {
+	w.buf[0] = v
+	w.w.Write(w.buf[:1])
+}
+
+func (w *binaryWriter) writeUint16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[:2], v)
+	w.w.Write(w.buf[:2])
+}
+
+func (w *binaryWriter) writeInt32(v int32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], uint32(v))
+	w.w.Write(w.buf[:4])
+}
+
+func (w *binaryWriter) writeUint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.w.Write(w.buf[:4])
+}
+
 // Flag format constants.
 const (
 	FlagDecimal = iota
@@ -373,6 +1223,15 @@ const (
 	FlagString
 )
 
+// FlagSortedAux may be combined with a flag format constant to specify
+// that auxiliary fields are written in tag order.
+const FlagSortedAux = 1 << 4
+
+func validFlagFormat(flags int) bool {
+	flags &^= FlagSortedAux
+	return FlagDecimal <= flags && flags <= FlagString
+}
+
 func formatFlags(f Flags, format int) interface{} {
 	switch format {
 	case FlagDecimal:
@@ -415,7 +1274,47 @@ func formatSeq(s Seq) []byte {
 	return s.Expand()
 }
 
-func formatQual(q []byte) []byte {
+// EncodeQual returns the Phred+33 ASCII encoding of the quality scores in q,
+// as written in the QUAL field of SAM text. A quality score of 0xff is the
+// sentinel used in BAM and in Record.Qual to mark quality scores as absent;
+// if all of q are the sentinel, or q is empty, EncodeQual returns "*".
+func EncodeQual(q []byte) []byte {
+	return formatQual(q, QualityAuto)
+}
+
+// DecodeQual returns the quality scores encoded as Phred+33 ASCII in ascii.
+// If ascii is "*", indicating absent quality scores, DecodeQual returns nil;
+// callers holding a sequence of known length may represent the absent scores
+// with the 0xff sentinel as UnmarshalSAM does. The bytes of ascii are
+// expected to be in the range '!' to '~'.
+func DecodeQual(ascii []byte) []byte {
+	if len(ascii) == 0 || (len(ascii) == 1 && ascii[0] == '*') {
+		return nil
+	}
+	q := make([]byte, len(ascii))
+	for i, a := range ascii {
+		q[i] = a - 33
+	}
+	return q
+}
+
+func formatQual(q []byte, qm QualityMode) []byte {
+	switch qm {
+	case QualityOmit:
+		return []byte{'*'}
+	case QualityAlways:
+		if len(q) == 0 {
+			return []byte{'*'}
+		}
+		a := make([]byte, len(q))
+		for i, p := range q {
+			if p == 0xff {
+				p = 0
+			}
+			a[i] = p + 33
+		}
+		return a
+	}
 	for _, v := range q {
 		if v != 0xff {
 			a := make([]byte, len(q))
@@ -499,6 +1398,38 @@ func (ns Seq) Expand() []byte {
 	return s
 }
 
+// isAmbiguous indicates whether a nybble-encoded base is an IUPAC
+// ambiguity code other than N.
+var isAmbiguous = [16]bool{
+	0x3: true, 0x5: true, 0x6: true, 0x7: true, 0x9: true,
+	0xa: true, 0xb: true, 0xc: true, 0xd: true, 0xe: true,
+}
+
+// ExpandNormalized returns the byte encoded form of the receiver and the
+// number of IUPAC ambiguity codes other than N that it contains. If strict
+// is true, each of these ambiguity codes is replaced with N in the returned
+// sequence. The '=' base is not considered to be an ambiguity code and is
+// retained.
+func (ns Seq) ExpandNormalized(strict bool) (seq []byte, ambiguous int) {
+	s := make([]byte, ns.Length)
+	for i := range s {
+		var b Doublet
+		if i&1 == 0 {
+			b = ns.Seq[i>>1] >> 4
+		} else {
+			b = ns.Seq[i>>1] & 0xf
+		}
+		if isAmbiguous[b] {
+			ambiguous++
+			if strict {
+				b = 0xf
+			}
+		}
+		s[i] = n16TableRev[b]
+	}
+	return s, ambiguous
+}
+
 // At returns the base at the given position.
 // At panics unless 0 <= pos < ns.Length.
 func (ns Seq) At(pos int) byte {
@@ -507,3 +1438,41 @@ func (ns Seq) At(pos int) byte {
 	}
 	return n16TableRev[ns.Seq[pos/2]&0xf]
 }
+
+// Append returns a new Seq holding the concatenation of the receiver and
+// other. Neither the receiver nor other is modified.
+func (ns Seq) Append(other Seq) Seq {
+	n := ns.Length + other.Length
+	seq := make([]Doublet, (n+1)>>1)
+	copy(seq, ns.Seq[:(ns.Length+1)>>1])
+	if ns.Length&1 == 0 {
+		copy(seq[ns.Length>>1:], other.Seq[:(other.Length+1)>>1])
+		return Seq{Length: n, Seq: seq}
+	}
+
+	// The receiver ends in the high nybble of its last
+	// doublet, so each base of other must be shifted by
+	// half a doublet.
+	seq[ns.Length>>1] &= 0xf0
+	for j := 0; j < other.Length; j++ {
+		var b Doublet
+		if j&1 == 0 {
+			b = other.Seq[j>>1] >> 4
+		} else {
+			b = other.Seq[j>>1] & 0xf
+		}
+		k := ns.Length + j
+		if k&1 == 0 {
+			seq[k>>1] = b << 4
+		} else {
+			seq[k>>1] |= b
+		}
+	}
+	return Seq{Length: n, Seq: seq}
+}
+
+// AppendBytes returns a new Seq holding the concatenation of the receiver
+// and the byte encoded sequence b. The receiver is not modified.
+func (ns Seq) AppendBytes(b []byte) Seq {
+	return ns.Append(NewSeq(b))
+}
@@ -9,9 +9,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"sort"
 	"strconv"
+
+	"github.com/biogo/hts/fai"
 )
 
 // Reference is a mapping reference.
@@ -29,10 +32,12 @@ type Reference struct {
 
 // NewReference returns a new Reference based on the given parameters.
 // Only name and length are mandatory and length must be a valid reference
-// length according to the SAM specification, [1, 1<<31).
+// length according to the SAM specification, [1, 1<<31), so that it can
+// be held by the int32 l_ref field of a BAM header. If length is out of
+// range the error returned is the same as for an invalid LN header field.
 func NewReference(name, assemID, species string, length int, md5 []byte, uri *url.URL) (*Reference, error) {
 	if !validLen(length) {
-		return nil, errors.New("sam: length out of range")
+		return nil, errBadLen
 	}
 	if name == "" {
 		return nil, errors.New("sam: no name provided")
@@ -55,6 +60,52 @@ func NewReference(name, assemID, species string, length int, md5 []byte, uri *ur
 	}, nil
 }
 
+// ReferencesFromFai returns a slice of References with the names and lengths
+// of the sequences in the FASTA index idx. The References are ordered by
+// their position in the indexed FASTA file and are not owned by a Header.
+func ReferencesFromFai(idx fai.Index) ([]*Reference, error) {
+	recs := make([]fai.Record, 0, len(idx))
+	for _, rec := range idx {
+		recs = append(recs, rec)
+	}
+	sort.Sort(faiByStart(recs))
+	refs := make([]*Reference, len(recs))
+	for i, rec := range recs {
+		var err error
+		refs[i], err = NewReference(rec.Name, "", "", rec.Length, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, rec.Name)
+		}
+	}
+	return refs, nil
+}
+
+type faiByStart []fai.Record
+
+func (r faiByStart) Len() int           { return len(r) }
+func (r faiByStart) Less(i, j int) bool { return r[i].Start < r[j].Start }
+func (r faiByStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// ReferencesFromDict returns a slice of References described by the @SQ
+// lines of the Picard sequence dictionary read from r. The References are
+// in the order of the dictionary and are not owned by a Header.
+func ReferencesFromDict(r io.Reader) ([]*Reference, error) {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var h Header
+	err = h.UnmarshalText(text)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]*Reference, len(h.refs))
+	for i, ref := range h.refs {
+		refs[i] = ref.Clone()
+	}
+	return refs, nil
+}
+
 // ID returns the header ID of the Reference.
 func (r *Reference) ID() int {
 	if r == nil {
@@ -132,7 +183,7 @@ func (r *Reference) Len() int {
 // must be a valid SAM reference length.
 func (r *Reference) SetLen(l int) error {
 	if !validLen(l) {
-		return errors.New("sam: length out of range")
+		return errBadLen
 	}
 	r.lRef = int32(l)
 	return nil
@@ -270,6 +321,12 @@ func (r *Reference) Set(t Tag, value string) error {
 // String returns a string representation of the Reference according to the
 // SAM specification section 1.3.
 func (r *Reference) String() string {
+	return r.format(false)
+}
+
+// format returns the SAM header line for the Reference, with the
+// non-standard tags sorted by tag if sorted is true.
+func (r *Reference) format(sorted bool) string {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "@SQ\tSN:%s\tLN:%d", r.name, r.lRef)
 	if r.md5 != "" {
@@ -284,9 +341,7 @@ func (r *Reference) String() string {
 	if r.uri != nil {
 		fmt.Fprintf(&buf, "\tUR:%s", r.uri)
 	}
-	for _, tp := range r.otherTags {
-		fmt.Fprintf(&buf, "\t%s:%s", tp.tag, tp.value)
-	}
+	writeTagPairs(&buf, r.otherTags, sorted)
 	return buf.String()
 }
 
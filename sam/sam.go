@@ -10,8 +10,11 @@ package sam
 
 import (
 	"bufio"
+	"compress/gzip"
 	"errors"
 	"io"
+
+	"github.com/biogo/hts/bgzf"
 )
 
 // Reader implements SAM format reading.
@@ -19,6 +22,15 @@ type Reader struct {
 	r *bufio.Reader
 	h *Header
 
+	// StrictReferences specifies that records
+	// naming references not described by the
+	// SAM header are rejected. Without it, the
+	// references of a headerless SAM stream are
+	// created as they are first seen. Records
+	// naming references absent from a header are
+	// always rejected.
+	StrictReferences bool
+
 	seenRefs map[string]*Reference
 }
 
@@ -66,6 +78,34 @@ func NewReader(r io.Reader) (*Reader, error) {
 	return sr, nil
 }
 
+// NewReaderAuto returns a new Reader, reading from the given io.Reader
+// which may hold BGZF compressed, gzip compressed or uncompressed SAM
+// data. The compression format is determined by inspecting the start
+// of the stream.
+func NewReaderAuto(r io.Reader) (*Reader, error) {
+	ok, r, err := bgzf.IsBGZF(r)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		bg, err := bgzf.NewReader(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		return NewReader(bg)
+	}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return NewReader(gz)
+	}
+	return NewReader(br)
+}
+
 // Header returns the SAM Header held by the Reader.
 func (r *Reader) Header() *Header {
 	return r.h
@@ -93,7 +133,11 @@ func (r *Reader) Read() (*Record, error) {
 	}
 
 	// Handle cases where no SAM header is present.
-	err = rec.UnmarshalSAM(nil, b)
+	if r.StrictReferences {
+		err = rec.UnmarshalSAMStrict(nil, b)
+	} else {
+		err = rec.UnmarshalSAM(nil, b)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -175,15 +219,39 @@ func (i *Iterator) Record() *Record { return i.rec }
 
 // Writer implements SAM format writing.
 type Writer struct {
+	// QualityMode specifies how quality
+	// strings are written. The zero value
+	// is QualityAuto.
+	QualityMode QualityMode
+
 	w     io.Writer
 	flags int
 }
 
+// QualityMode specifies how a Writer formats quality strings.
+type QualityMode int
+
+const (
+	// QualityAuto writes "*" when all the quality
+	// scores of a record are the missing quality
+	// sentinel, 0xff, and the scores otherwise.
+	QualityAuto QualityMode = iota
+
+	// QualityAlways always writes the quality scores
+	// of a record that has a quality slice. Missing
+	// quality sentinels are written as '!', a quality
+	// score of zero.
+	QualityAlways
+
+	// QualityOmit always writes "*".
+	QualityOmit
+)
+
 // NewWriter returns a Writer to the given io.Writer using h for the SAM
 // header. The format of flags for SAM lines can be FlagDecimal, FlagHex
-// or FlagString.
+// or FlagString, optionally combined with FlagSortedAux.
 func NewWriter(w io.Writer, h *Header, flags int) (*Writer, error) {
-	if flags < FlagDecimal || flags > FlagString {
+	if !validFlagFormat(flags) {
 		return nil, errors.New("bam: flag format option out of range")
 	}
 	sw := &Writer{w: w, flags: flags}
@@ -197,7 +265,10 @@ func NewWriter(w io.Writer, h *Header, flags int) (*Writer, error) {
 
 // Write writes r to the SAM stream.
 func (w *Writer) Write(r *Record) error {
-	b, err := r.MarshalSAM(w.flags)
+	if w.QualityMode < QualityAuto || QualityOmit < w.QualityMode {
+		return errors.New("sam: quality mode out of range")
+	}
+	b, err := r.marshalSAM(w.flags, w.QualityMode)
 	if err != nil {
 		return err
 	}
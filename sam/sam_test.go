@@ -6,16 +6,23 @@ package sam
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/fai"
+
 	"gopkg.in/check.v1"
 )
 
@@ -780,6 +787,275 @@ func (s *S) TestLengths(c *check.C) {
 	}
 }
 
+func (s *S) TestParseAbsentCigar(c *check.C) {
+	for _, b := range [][]byte{nil, {}, []byte("*")} {
+		cig, err := ParseCigar(b)
+		c.Check(err, check.Equals, nil, check.Commentf("cigar %q", b))
+		c.Check(cig, check.IsNil, check.Commentf("cigar %q", b))
+	}
+}
+
+func (s *S) TestRecordOverlaps(c *check.C) {
+	ref, err := NewReference("ref", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	other, err := NewReference("other", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+
+	// The alignment covers [100,110).
+	r := &Record{
+		Ref:   ref,
+		Pos:   100,
+		Cigar: Cigar{NewCigarOp(CigarMatch, 10)},
+	}
+	for _, test := range []struct {
+		ref      *Reference
+		beg, end int
+		flags    Flags
+		want     bool
+	}{
+		{ref: ref, beg: 0, end: 1000, want: true},
+		{ref: ref, beg: 105, end: 106, want: true},
+		{ref: ref, beg: 109, end: 200, want: true},
+		{ref: ref, beg: 0, end: 101, want: true},
+		{ref: ref, beg: 110, end: 200, want: false}, // Abuts the end of the alignment.
+		{ref: ref, beg: 0, end: 100, want: false},   // Abuts the start of the alignment.
+		{ref: ref, beg: 105, end: 105, want: false},
+		{ref: other, beg: 0, end: 1000, want: false},
+		{ref: nil, beg: 0, end: 1000, want: false},
+		{ref: ref, beg: 0, end: 1000, flags: Unmapped, want: false},
+	} {
+		r.Flags = test.flags
+		c.Check(r.Overlaps(test.ref, test.beg, test.end), check.Equals, test.want,
+			check.Commentf("ref=%v [%d,%d) flags=%v", test.ref.Name(), test.beg, test.end, test.flags),
+		)
+	}
+}
+
+func (s *S) TestSeqQualValid(c *check.C) {
+	for _, test := range []struct {
+		seq    Seq
+		qual   []byte
+		ok     bool
+		reason string
+	}{
+		{seq: NewSeq([]byte("ACGTN")), qual: []byte{0, 10, 20, 30, 93}, ok: true},
+		{seq: NewSeq([]byte("ACGTN")), qual: nil, ok: true},
+		{seq: NewSeq([]byte("ACGTN")), qual: []byte{0xff, 0xff, 0xff, 0xff, 0xff}, ok: true},
+		{seq: Seq{}, qual: nil, ok: true},
+		{
+			seq: NewSeq([]byte("ACGTN")), qual: []byte{0, 10, 94, 30, 40},
+			ok: false, reason: "quality out of range at position 2: 94",
+		},
+		{
+			seq: NewSeq([]byte("ACGTN")), qual: []byte{0xff, 0xff, 30, 0xff, 0xff},
+			ok: false, reason: "partially absent quality at position 2",
+		},
+		{
+			seq: NewSeq([]byte("ACGTN")), qual: []byte{30, 30, 30},
+			ok: false, reason: "quality length mismatch: 3 != 5",
+		},
+		{
+			seq:  Seq{Length: 5, Seq: NewSeq([]byte("AC")).Seq},
+			qual: nil,
+			ok:   false, reason: "sequence length mismatch: 5 bases in 1 bytes",
+		},
+	} {
+		r := &Record{Seq: test.seq, Qual: test.qual}
+		ok, reason := r.SeqQualValid()
+		c.Check(ok, check.Equals, test.ok)
+		c.Check(reason, check.Equals, test.reason)
+	}
+}
+
+func (s *S) TestSeqAppend(c *check.C) {
+	for _, test := range []struct {
+		a, b string
+	}{
+		{a: "", b: ""},
+		{a: "", b: "ACG"},
+		{a: "ACG", b: ""},
+		{a: "ACG", b: "TNA"},       // odd+odd
+		{a: "ACG", b: "TNAC"},      // odd+even
+		{a: "ACGT", b: "NAC"},      // even+odd
+		{a: "ACGT", b: "NACG"},     // even+even
+		{a: "A", b: "=MRSVWYHKDB"}, // all codes
+	} {
+		a, b := NewSeq([]byte(test.a)), NewSeq([]byte(test.b))
+		want := NewSeq([]byte(test.a + test.b))
+
+		got := a.Append(b)
+		c.Check(got, check.DeepEquals, want, check.Commentf("%q+%q", test.a, test.b))
+		c.Check(string(got.Expand()), check.Equals, test.a+test.b)
+
+		got = a.AppendBytes([]byte(test.b))
+		c.Check(got, check.DeepEquals, want, check.Commentf("%q+%q bytes", test.a, test.b))
+
+		// Operands must not be modified.
+		c.Check(string(a.Expand()), check.Equals, test.a)
+		c.Check(string(b.Expand()), check.Equals, test.b)
+	}
+
+	// Trailing data in the last doublet of an odd length
+	// receiver must not leak into the result.
+	a := Seq{Length: 1, Seq: []Doublet{0x1f}}
+	got := a.Append(NewSeq([]byte("C")))
+	c.Check(got.Seq, check.DeepEquals, []Doublet{0x12})
+}
+
+func (s *S) TestRecordBinaryRoundTrip(c *check.C) {
+	chr1, err := NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	chr2, err := NewReference("chr2", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := NewHeader(nil, []*Reference{chr1, chr2})
+	c.Assert(err, check.Equals, nil)
+
+	var aux []Aux
+	for _, v := range []struct {
+		tag string
+		val interface{}
+	}{
+		{tag: "XA", val: 'x'},
+		{tag: "XC", val: int8(-3)},
+		{tag: "XS", val: uint16(1000)},
+		{tag: "XI", val: int32(-100000)},
+		{tag: "XF", val: float32(0.5)},
+		{tag: "XZ", val: "text"},
+		{tag: "XB", val: []int16{1, -2, 3}},
+		{tag: "XH", val: Hex([]byte{0xde, 0xad})},
+	} {
+		a, err := NewAux(NewTag(v.tag), v.val)
+		c.Assert(err, check.Equals, nil, check.Commentf("tag %s", v.tag))
+		aux = append(aux, a)
+	}
+	r, err := NewRecord("read", chr1, chr2, 10, 500, 0, 60,
+		[]CigarOp{NewCigarOp(CigarMatch, 3), NewCigarOp(CigarInsertion, 1), NewCigarOp(CigarMatch, 1)},
+		[]byte("ACGTA"), []byte{30, 31, 32, 33, 34}, aux)
+	c.Assert(err, check.Equals, nil)
+	r.Flags = Paired | Read1
+
+	b, err := r.MarshalBinary()
+	c.Assert(err, check.Equals, nil)
+
+	var got Record
+	c.Assert(got.UnmarshalBAM(h, b), check.Equals, nil)
+	c.Check(&got, check.DeepEquals, r)
+
+	// Without a header, reference IDs are retained.
+	got = Record{}
+	c.Assert(got.UnmarshalBinary(b), check.Equals, nil)
+	c.Check(got.Ref.ID(), check.Equals, 0)
+	c.Check(got.MateRef.ID(), check.Equals, 1)
+	c.Check(got.AuxFields, check.DeepEquals, r.AuxFields)
+	rb, err := got.MarshalBinary()
+	c.Assert(err, check.Equals, nil)
+	c.Check(rb, check.DeepEquals, b)
+
+	// Records can be sent using encoding/gob.
+	var buf bytes.Buffer
+	c.Assert(gob.NewEncoder(&buf).Encode(r), check.Equals, nil)
+	got = Record{}
+	c.Assert(gob.NewDecoder(&buf).Decode(&got), check.Equals, nil)
+	c.Check(got.Name, check.Equals, r.Name)
+	c.Check(got.Cigar, check.DeepEquals, r.Cigar)
+	c.Check(got.AuxFields, check.DeepEquals, r.AuxFields)
+
+	for i := 0; i < len(b); i++ {
+		c.Check(got.UnmarshalBinary(b[:i]), check.Not(check.Equals), nil, check.Commentf("truncated at %d", i))
+	}
+	c.Check(got.UnmarshalBAM(&Header{}, b), check.ErrorMatches, "sam: reference id out of range: 0")
+}
+
+func (s *S) TestNewUnmappedRecord(c *check.C) {
+	for _, qual := range [][]byte{nil, {30, 31, 32, 33}} {
+		r, err := NewUnmappedRecord("read", []byte("ACGT"), qual)
+		c.Assert(err, check.Equals, nil)
+		c.Check(r.Flags, check.Equals, Unmapped)
+		c.Check(r.Ref, check.IsNil)
+		c.Check(r.MateRef, check.IsNil)
+		c.Check(r.Pos, check.Equals, -1)
+		c.Check(r.MatePos, check.Equals, -1)
+		c.Check(string(r.Seq.Expand()), check.Equals, "ACGT")
+		c.Check(IsValidRecord(r), check.Equals, true)
+	}
+
+	// Only unmapped records may omit the CIGAR.
+	ref, err := NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	mapped := &Record{Name: "read", Ref: ref, Pos: 10, Seq: NewSeq([]byte("ACGT"))}
+	c.Check(IsValidRecord(mapped), check.Equals, false)
+	mapped.Cigar = Cigar{NewCigarOp(CigarMatch, 4)}
+	c.Check(IsValidRecord(mapped), check.Equals, true)
+
+	_, err = NewUnmappedRecord("", []byte("ACGT"), nil)
+	c.Check(err, check.ErrorMatches, "sam: name absent or too long")
+	_, err = NewUnmappedRecord("read", []byte("ACGT"), []byte{30})
+	c.Check(err, check.ErrorMatches, "sam: sequence/quality length mismatch")
+	_, err = NewUnmappedRecord("read", []byte("ACGT"), []byte{30, 31, 100, 33})
+	c.Check(err, check.ErrorMatches, "sam: quality out of range at position 2: 100")
+}
+
+func (s *S) TestQualStats(c *check.C) {
+	r := &Record{Seq: NewSeq([]byte("ACGT")), Qual: []byte{30, 10, 40, 20}}
+	c.Check(r.MeanQual(), check.Equals, 25.0)
+	c.Check(r.MinQual(), check.Equals, byte(10))
+
+	for _, qual := range [][]byte{nil, {0xff, 0xff, 0xff, 0xff}} {
+		r.Qual = qual
+		c.Check(math.IsNaN(r.MeanQual()), check.Equals, true)
+		c.Check(r.MinQual(), check.Equals, byte(0xff))
+	}
+}
+
+func (s *S) TestCigarCounts(c *check.C) {
+	cigar, err := ParseCigar([]byte("5S10M2I20M3D1M1I4M5H"))
+	c.Assert(err, check.Equals, nil)
+	c.Check(cigar.Counts(), check.DeepEquals, map[CigarOpType]int{
+		CigarSoftClipped: 1,
+		CigarMatch:       4,
+		CigarInsertion:   2,
+		CigarDeletion:    1,
+		CigarHardClipped: 1,
+	})
+	c.Check(cigar.BaseCounts(), check.DeepEquals, map[CigarOpType]int{
+		CigarSoftClipped: 5,
+		CigarMatch:       35,
+		CigarInsertion:   3,
+		CigarDeletion:    3,
+		CigarHardClipped: 5,
+	})
+	c.Check(Cigar(nil).Counts(), check.HasLen, 0)
+	c.Check(Cigar(nil).BaseCounts(), check.HasLen, 0)
+}
+
+func (s *S) TestConsumeFor(c *check.C) {
+	for _, test := range []struct {
+		typ  CigarOpType
+		want Consume
+		str  string
+	}{
+		{typ: CigarMatch, want: Consume{Query: 1, Reference: 1}, str: "query+ref"},
+		{typ: CigarInsertion, want: Consume{Query: 1}, str: "query"},
+		{typ: CigarDeletion, want: Consume{Reference: 1}, str: "ref"},
+		{typ: CigarSkipped, want: Consume{Reference: 1}, str: "ref"},
+		{typ: CigarSoftClipped, want: Consume{Query: 1}, str: "query"},
+		{typ: CigarHardClipped, want: Consume{}, str: "none"},
+		{typ: CigarPadded, want: Consume{}, str: "none"},
+		{typ: CigarEqual, want: Consume{Query: 1, Reference: 1}, str: "query+ref"},
+		{typ: CigarMismatch, want: Consume{Query: 1, Reference: 1}, str: "query+ref"},
+		{typ: CigarBack, want: Consume{Reference: -1}, str: "-ref"},
+		{typ: lastCigar, want: Consume{}, str: "none"},
+		{typ: lastCigar + 1, want: Consume{}, str: "none"},
+	} {
+		got := ConsumeFor(test.typ)
+		c.Check(got, check.Equals, test.want, check.Commentf("op %v", test.typ))
+		c.Check(got.String(), check.Equals, test.str, check.Commentf("op %v", test.typ))
+		if test.typ <= lastCigar {
+			c.Check(test.typ.Consumes(), check.Equals, got, check.Commentf("op %v", test.typ))
+		}
+	}
+}
+
 func (s *S) TestIssue32(c *check.C) {
 	sam := []byte(`@HD	VN:1.5	SO:coordinate
 @SQ	SN:name	LN:1
@@ -811,6 +1087,40 @@ q3	0	q2	4	7	1S13M2I18M	*	0	0	TCAGGAAGGCGGTATCGACGACGGTGCCGAGGCA	*	PG:i:0	NM:i:0
 	c.Check(IsValidRecord(rec), check.Equals, true)
 }
 
+func (s *S) TestStrictReferences(c *check.C) {
+	const (
+		mapped   = "r1\t0\tchr1\t4\t7\t4M\t*\t0\t0\tACGT\t*"
+		unmapped = "r2\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*"
+		header   = "@HD\tVN:1.5\n@SQ\tSN:chr2\tLN:1000\n"
+	)
+
+	var rec Record
+	c.Check(rec.UnmarshalSAM(nil, []byte(mapped)), check.Equals, nil)
+	c.Check(rec.Ref.Name(), check.Equals, "chr1")
+	c.Check(rec.Ref.ID(), check.Equals, -1)
+	c.Check(rec.UnmarshalSAMStrict(nil, []byte(mapped)), check.ErrorMatches, `.*no header for reference name "chr1"`)
+	c.Check(rec.UnmarshalSAMStrict(nil, []byte(unmapped)), check.Equals, nil)
+	c.Check(rec.Ref, check.IsNil)
+
+	for _, test := range []struct {
+		sam    string
+		strict bool
+		ok     bool
+	}{
+		{sam: mapped + "\n", strict: false, ok: true},
+		{sam: mapped + "\n", strict: true, ok: false},
+		{sam: unmapped + "\n", strict: true, ok: true},
+		{sam: header + mapped + "\n", strict: false, ok: false},
+		{sam: header + mapped + "\n", strict: true, ok: false},
+	} {
+		r, err := NewReader(strings.NewReader(test.sam))
+		c.Assert(err, check.Equals, nil)
+		r.StrictReferences = test.strict
+		_, err = r.Read()
+		c.Check(err == nil, check.Equals, test.ok, check.Commentf("strict=%t sam=%q err=%v", test.strict, test.sam, err))
+	}
+}
+
 func (s *S) TestEqualRefs(c *check.C) {
 	a, err := NewReference("aaa", "assem", "species", 1234, nil, nil)
 	c.Assert(err, check.IsNil)
@@ -893,6 +1203,42 @@ func (s *S) TestSort(c *check.C) {
 	}
 }
 
+func (s *S) TestLessByCoordinate(c *check.C) {
+	chr1, err := NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	chr2, err := NewReference("chr2", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+
+	var (
+		a1   = &Record{Name: "a1", Ref: chr1, Pos: 10}
+		a2   = &Record{Name: "a2", Ref: chr1, Pos: 20}
+		b1   = &Record{Name: "b1", Ref: chr2, Pos: 5}
+		u1   = &Record{Name: "u1", Ref: nil, Pos: -1}
+		u2   = &Record{Name: "u2", Ref: nil, Pos: -1}
+		same = &Record{Name: "same", Ref: chr1, Pos: 10}
+	)
+	for _, test := range []struct {
+		a, b *Record
+		want bool
+	}{
+		{a: a1, b: a2, want: true},
+		{a: a2, b: a1, want: false},
+		{a: a2, b: b1, want: true}, // Reference name takes precedence over position.
+		{a: b1, b: a2, want: false},
+		{a: a1, b: same, want: false},
+		{a: same, b: a1, want: false},
+		{a: a1, b: u1, want: true},
+		{a: u1, b: a1, want: false},
+		{a: u1, b: u2, want: false}, // Both unmapped.
+		{a: u2, b: u1, want: false},
+		{a: u1, b: u1, want: false},
+	} {
+		c.Check(test.a.LessByCoordinate(test.b), check.Equals, test.want,
+			check.Commentf("%s < %s", test.a.Name, test.b.Name),
+		)
+	}
+}
+
 type byName []*Record
 
 func (r byName) Len() int           { return len(r) }
@@ -916,6 +1262,28 @@ func (s *S) TestRemoveReference(c *check.C) {
 	c.Check(len(h.Refs()), check.Equals, len(headerHG00096_1000.Refs()))
 }
 
+func (s *S) TestReferenceByName(c *check.C) {
+	h := headerHG00096_1000.Clone()
+	for _, want := range h.Refs() {
+		got, ok := h.ReferenceByName(want.Name())
+		c.Check(ok, check.Equals, true)
+		c.Check(got, check.Equals, want)
+	}
+	got, ok := h.ReferenceByName("absent")
+	c.Check(ok, check.Equals, false)
+	c.Check(got, check.IsNil)
+
+	removed := h.Refs()[2]
+	c.Assert(h.RemoveReference(removed), check.Equals, nil)
+	_, ok = h.ReferenceByName(removed.Name())
+	c.Check(ok, check.Equals, false)
+	for _, want := range h.Refs() {
+		got, ok := h.ReferenceByName(want.Name())
+		c.Check(ok, check.Equals, true)
+		c.Check(got, check.Equals, want)
+	}
+}
+
 func (s *S) TestRemoveReadGroup(c *check.C) {
 	h := headerHG00096_1000.Clone()
 	h.RemoveReadGroup(h.RGs()[1])
@@ -1036,6 +1404,233 @@ func (s *S) TestMergeHeaders(c *check.C) {
 	}
 }
 
+func (s *S) TestAuxSetValue(c *check.C) {
+	nm := NewTag("NM")
+
+	a, err := NewAux(nm, uint(3))
+	c.Assert(err, check.Equals, nil)
+	c.Assert(a.Type(), check.Equals, byte('C'))
+
+	// Values fitting the existing type are written in place.
+	got, err := a.SetValue(4)
+	c.Assert(err, check.Equals, nil)
+	c.Check(&got[0], check.Equals, &a[0])
+	c.Check(got.Type(), check.Equals, byte('C'))
+	c.Check(got.Value(), check.Equals, uint8(4))
+	c.Check(a.Value(), check.Equals, uint8(4))
+
+	// Values not fitting the existing type are newly allocated.
+	got, err = a.SetValue(uint(1000))
+	c.Assert(err, check.Equals, nil)
+	c.Check(&got[0], check.Not(check.Equals), &a[0])
+	c.Check(got.Tag(), check.Equals, nm)
+	c.Check(got.Type(), check.Equals, byte('S'))
+	c.Check(got.Value(), check.Equals, uint16(1000))
+	c.Check(a.Value(), check.Equals, uint8(4))
+
+	// Equal length encodings are written in place, even
+	// when the type changes.
+	got, err = a.SetValue(-1)
+	c.Assert(err, check.Equals, nil)
+	c.Check(&got[0], check.Equals, &a[0])
+	c.Check(got.Type(), check.Equals, byte('c'))
+	c.Check(got.Value(), check.Equals, int8(-1))
+
+	z, err := NewAux(NewTag("XZ"), "foo")
+	c.Assert(err, check.Equals, nil)
+	got, err = z.SetValue("bar")
+	c.Assert(err, check.Equals, nil)
+	c.Check(&got[0], check.Equals, &z[0])
+	c.Check(got.Value(), check.Equals, "bar")
+	got, err = z.SetValue("barbaz")
+	c.Assert(err, check.Equals, nil)
+	c.Check(&got[0], check.Not(check.Equals), &z[0])
+	c.Check(got.Value(), check.Equals, "barbaz")
+	c.Check(z.Value(), check.Equals, "bar")
+
+	_, err = a.SetValue(struct{}{})
+	c.Check(err, check.Not(check.Equals), nil)
+}
+
+func (s *S) TestSortByTag(c *check.C) {
+	aux := AuxFields{
+		mustAux(ParseAux([]byte("NM:i:1"))),
+		mustAux(ParseAux([]byte("XB:B:i,2,1"))),
+		mustAux(ParseAux([]byte("MD:Z:9"))),
+		mustAux(ParseAux([]byte("XB:B:i,1,2"))),
+		mustAux(ParseAux([]byte("AS:Z:b"))),
+		mustAux(ParseAux([]byte("AS:Z:a"))),
+	}
+	want := AuxFields{aux[4], aux[5], aux[2], aux[0], aux[1], aux[3]}
+
+	r := &Record{
+		Name:      "r001",
+		Pos:       -1,
+		MatePos:   -1,
+		Flags:     Unmapped,
+		AuxFields: append(AuxFields(nil), aux...),
+	}
+	b, err := r.MarshalSAM(FlagDecimal | FlagSortedAux)
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(b), check.Equals, "r001\t4\t*\t0\t0\t*\t*\t0\t0\t*\t*\tAS:Z:b\tAS:Z:a\tMD:Z:9\tNM:i:1\tXB:B:i,2,1\tXB:B:i,1,2")
+	c.Check(r.AuxFields, check.DeepEquals, aux, check.Commentf("record aux fields mutated"))
+
+	_, err = r.MarshalSAM(FlagString + 1)
+	c.Check(err, check.Not(check.Equals), nil)
+
+	aux.SortByTag()
+	c.Check(aux, check.DeepEquals, want)
+}
+
+func (s *S) TestNewHeaderFull(c *check.C) {
+	ref1, err := NewReference("ref1", "", "", 100, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	ref2, err := NewReference("ref2", "", "", 200, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	rg, err := NewReadGroup("group", "", "", "lib", "", "ILLUMINA", "", "sample", "", "", time.Time{}, 0)
+	c.Assert(err, check.Equals, nil)
+	prog := NewProgram("prog", "aligner", "aligner ref.fa", "", "1.0")
+
+	h, err := NewHeaderFull(nil, []*Reference{ref1, ref2}, []*ReadGroup{rg}, []*Program{prog})
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.Refs(), check.DeepEquals, []*Reference{ref1, ref2})
+	c.Check(h.RGs(), check.DeepEquals, []*ReadGroup{rg})
+	c.Check(h.Progs(), check.DeepEquals, []*Program{prog})
+	c.Check(ref2.ID(), check.Equals, 1)
+	c.Check(rg.ID(), check.Equals, 0)
+	c.Check(prog.ID(), check.Equals, 0)
+
+	// Re-adding a duplicate must be detected by the usual methods.
+	dup, err := NewReadGroup("group", "", "", "", "", "", "", "", "", "", time.Time{}, 0)
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.AddReadGroup(dup), check.Equals, errDupReadGroup)
+
+	_, err = NewHeaderFull(nil, []*Reference{ref1}, nil, nil)
+	c.Check(err, check.Equals, errUsedReference)
+	_, err = NewHeaderFull(nil, nil, []*ReadGroup{rg}, nil)
+	c.Check(err, check.Equals, errUsedReadGroup)
+	_, err = NewHeaderFull(nil, nil, nil, []*Program{prog})
+	c.Check(err, check.Equals, errUsedProgram)
+
+	p1 := NewProgram("p", "", "", "", "")
+	p2 := NewProgram("p", "", "", "", "")
+	_, err = NewHeaderFull(nil, nil, nil, []*Program{p1, p2})
+	c.Check(err, check.Equals, errDupProgram)
+	c.Check(p1.ID(), check.Equals, -1)
+
+	// Values are released when the text cannot be parsed.
+	ref, err := NewReference("ref", "", "", 100, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	rg, err = NewReadGroup("group", "", "", "", "", "", "", "", "", "", time.Time{}, 0)
+	c.Assert(err, check.Equals, nil)
+	_, err = NewHeaderFull([]byte("@SQ\tSN:other\n"), []*Reference{ref}, []*ReadGroup{rg}, []*Program{p1})
+	c.Check(errors.Is(err, errBadHeader), check.Equals, true)
+	c.Check(ref.ID(), check.Equals, -1)
+	c.Check(ref.owner == nil, check.Equals, true)
+	c.Check(rg.ID(), check.Equals, -1)
+	c.Check(rg.owner == nil, check.Equals, true)
+	c.Check(p1.ID(), check.Equals, -1)
+	c.Check(p1.owner == nil, check.Equals, true)
+	_, err = NewHeaderFull(nil, []*Reference{ref}, []*ReadGroup{rg}, []*Program{p1})
+	c.Check(err, check.Equals, nil)
+}
+
+func (s *S) TestVersionNumber(c *check.C) {
+	for _, test := range []struct {
+		text         string
+		major, minor int
+		ok           bool
+	}{
+		{text: "@HD\tVN:1.6\tSO:coordinate\n", major: 1, minor: 6, ok: true},
+		{text: "@HD\tVN:1.0\n", major: 1, minor: 0, ok: true},
+		{text: "@HD\tVN:1.10\n", major: 1, minor: 10, ok: true},
+		{text: "@HD\tVN:one.six\n", ok: false},
+		{text: "@HD\tVN:1\n", ok: false},
+		{text: "@HD\tVN:1.6.1\n", ok: false},
+		{text: "@HD\tVN:+1.6\n", ok: false},
+	} {
+		// Malformed versions are accepted when parsing.
+		h, err := NewHeader([]byte(test.text), nil)
+		c.Assert(err, check.Equals, nil, check.Commentf("header %q", test.text))
+		major, minor, ok := h.VersionNumber()
+		c.Check(ok, check.Equals, test.ok, check.Commentf("header %q", test.text))
+		c.Check(major, check.Equals, test.major, check.Commentf("header %q", test.text))
+		c.Check(minor, check.Equals, test.minor, check.Commentf("header %q", test.text))
+	}
+}
+
+func (s *S) TestReferencesFromFai(c *check.C) {
+	idx := fai.Index{
+		"chr2": fai.Record{Name: "chr2", Length: 500, Start: 1030, BasesPerLine: 60, BytesPerLine: 61},
+		"chr1": fai.Record{Name: "chr1", Length: 1000, Start: 6, BasesPerLine: 60, BytesPerLine: 61},
+		"chrM": fai.Record{Name: "chrM", Length: 16569, Start: 1550, BasesPerLine: 60, BytesPerLine: 61},
+	}
+	refs, err := ReferencesFromFai(idx)
+	c.Assert(err, check.Equals, nil)
+	c.Assert(len(refs), check.Equals, 3)
+	for i, want := range []struct {
+		name   string
+		length int
+	}{{"chr1", 1000}, {"chr2", 500}, {"chrM", 16569}} {
+		c.Check(refs[i].Name(), check.Equals, want.name)
+		c.Check(refs[i].Len(), check.Equals, want.length)
+		c.Check(refs[i].ID(), check.Equals, -1)
+	}
+	h, err := NewHeader(nil, refs)
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.Refs()[2].ID(), check.Equals, 2)
+
+	_, err = ReferencesFromFai(fai.Index{"empty": fai.Record{Name: "empty"}})
+	c.Check(err, check.ErrorMatches, `sam: reference length out of range: "empty"`)
+}
+
+func (s *S) TestReferencesFromDict(c *check.C) {
+	const dict = `@HD	VN:1.6	SO:unsorted
+@SQ	SN:chr1	LN:1000	M5:0123456789abcdef0123456789abcdef	UR:file:/data/ref.fa
+@SQ	SN:chr2	LN:500	AS:GRCh38
+`
+	refs, err := ReferencesFromDict(strings.NewReader(dict))
+	c.Assert(err, check.Equals, nil)
+	c.Assert(len(refs), check.Equals, 2)
+	c.Check(refs[0].Name(), check.Equals, "chr1")
+	c.Check(refs[0].Len(), check.Equals, 1000)
+	c.Check(fmt.Sprintf("%x", refs[0].MD5()), check.Equals, "0123456789abcdef0123456789abcdef")
+	c.Check(refs[0].URI(), check.Equals, "file:/data/ref.fa")
+	c.Check(refs[1].Name(), check.Equals, "chr2")
+	c.Check(refs[1].Len(), check.Equals, 500)
+	c.Check(refs[1].AssemblyID(), check.Equals, "GRCh38")
+	for _, r := range refs {
+		c.Check(r.ID(), check.Equals, -1)
+	}
+	h, err := NewHeader(nil, refs)
+	c.Assert(err, check.Equals, nil)
+	c.Check(len(h.Refs()), check.Equals, 2)
+
+	_, err = ReferencesFromDict(strings.NewReader("@SQ\tSN:chr1\n"))
+	c.Check(err, check.Not(check.Equals), nil)
+}
+
+func (s *S) TestSubSort(c *check.C) {
+	const text = "@HD\tVN:1.6\tSO:coordinate\tSS:coordinate:queryname\tGO:query\n@SQ\tSN:ref\tLN:45\n"
+	h, err := NewHeader([]byte(text), nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.SubSort, check.Equals, "coordinate:queryname")
+	c.Check(h.Get(NewTag("SS")), check.Equals, "coordinate:queryname")
+	c.Check(h.otherTags, check.HasLen, 0)
+	b, err := h.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(b), check.Equals, text)
+	c.Check(h.Clone().SubSort, check.Equals, h.SubSort)
+
+	c.Check(h.Set(NewTag("SS"), ""), check.Equals, nil)
+	b, err = h.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(b), check.Equals, "@HD\tVN:1.6\tSO:coordinate\tGO:query\n@SQ\tSN:ref\tLN:45\n")
+
+	_, err = NewHeader([]byte("@HD\tVN:1.6\tSS:a\tSS:b\n"), nil)
+	c.Check(err, check.Not(check.Equals), nil)
+}
+
 func TestSeqAt(t *testing.T) {
 	input := "TACGTMRSVKDBWYH"
 	seq := NewSeq([]byte(input))
@@ -1046,6 +1641,31 @@ func TestSeqAt(t *testing.T) {
 	}
 }
 
+func TestSeqExpandNormalized(t *testing.T) {
+	tests := []struct {
+		seq       string
+		strict    bool
+		want      string
+		ambiguous int
+	}{
+		{seq: "ACGTN", strict: false, want: "ACGTN", ambiguous: 0},
+		{seq: "ACGTN", strict: true, want: "ACGTN", ambiguous: 0},
+		{seq: "ACRGTY=N", strict: false, want: "ACRGTY=N", ambiguous: 2},
+		{seq: "ACRGTY=N", strict: true, want: "ACNGTN=N", ambiguous: 2},
+		{seq: "acrgty=n", strict: true, want: "ACNGTN=N", ambiguous: 2},
+		{seq: "MRSVWYHKDB", strict: true, want: "NNNNNNNNNN", ambiguous: 10},
+	}
+	for _, test := range tests {
+		got, n := NewSeq([]byte(test.seq)).ExpandNormalized(test.strict)
+		if string(got) != test.want {
+			t.Errorf("unexpected normalized sequence for %q strict=%t: got:%q want:%q", test.seq, test.strict, got, test.want)
+		}
+		if n != test.ambiguous {
+			t.Errorf("unexpected ambiguous count for %q: got:%d want:%d", test.seq, n, test.ambiguous)
+		}
+	}
+}
+
 func BenchmarkParseCigar(b *testing.B) {
 	cig := []byte("69S17M5I30M1D45M1D23M5I14M2I4M1I10M2D7M1D6M14I33M1D6M1I7M1I18M1I8M1D4M1D4M2D57M1D21M1D6M1I14M1I7M1I3M1I9M1D3M1D7M1D37M1D9M1I5M1I15M4I12M1D10M1I10M1D8M1D26M7I12M1D20M1I36M1I22M3D8M1I23M1I13M2D10M1D12M1I15M6D4M1D4M1D1M2D5M1D3M17D1M1D13M3D7M1I29M2I9M1D2M4D7M2D8M5D3M1D1M1D23M1D10M6D19M3I24M1D8M1I11M6D14M1I5M8I12M1D8M2D5M2D2M1D23M1D11M4I35M2I19M1I4M1D13M7I33M1D21M3D2M1D9M4I19M1I14M1D7M1I41M1D23M3I18M1I6M1I13M1D9M1D1M1D20M1D23M5D8M1I13M2I11M1D78M2I18M10D9M2D10M1D10M2I6M1D3M1D21M2I7M1D7M2I12M1D20M2D18M1I12M1D8M4D18M1D6M1D20M1D14M1I1M2I23M1I10M1D7M1I15M1D4M1I9M1D11M1D12M1I8M1D21M1I13M2I59M1D12M1D18M1D13M1D22M1D13M1I19M1D13M1D19M1I11M2I27M2D10M1D17M6D13M2D17M1D13M1D19M1I3M1D13M2I33M1I26M2D9M2I21M2D10M1D36M1D32M5I23M1D13M2D17M1I14M2I24M1I5M2I8M2I24M2I9M1D7M1D2M1D15M3I19M1I2M1D3M1I7M1D5M2D24M5I1M4I33M1I13M3I34M1I2M1I23M1D3M2I8M1I5M5S")
 	for i := 0; i < b.N; i++ {
@@ -1069,3 +1689,1178 @@ func BenchmarkParseAuxInt(b *testing.B)   { benchmarkAux(b, []byte("NM:i:1")) }
 func BenchmarkParseAuxZ(b *testing.B)     { benchmarkAux(b, []byte("SA:Z:ref,29,-,6H5M,17,0;")) }
 func BenchmarkParseAuxFloat(b *testing.B) { benchmarkAux(b, []byte("FL:f:100042.42")) }
 func BenchmarkParseAuxArray(b *testing.B) { benchmarkAux(b, []byte("BB:B:i,629,1095")) }
+
+func (s *S) TestEmptyReferenceName(c *check.C) {
+	var buf bytes.Buffer
+	buf.Write(bamMagic[:])
+	binary.Write(&buf, binary.LittleEndian, int32(0)) // l_text
+	binary.Write(&buf, binary.LittleEndian, int32(1)) // n_ref
+	binary.Write(&buf, binary.LittleEndian, int32(1)) // l_name
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.LittleEndian, int32(100)) // l_ref
+
+	h, err := NewHeader(nil, nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(h.DecodeBinary(&buf), check.Equals, errEmptyRefName)
+
+	c.Check(h.AddReference(&Reference{id: -1, lRef: 100}), check.Equals, errEmptyRefName)
+	c.Check(h.Refs(), check.HasLen, 0)
+
+	_, err = NewHeader([]byte("@SQ\tSN:\tLN:100\n"), nil)
+	c.Check(errors.Is(err, errEmptyRefName), check.Equals, true)
+}
+
+func (s *S) TestRecordParseError(c *check.C) {
+	for _, test := range []struct {
+		sam   string
+		field int
+		err   string
+	}{
+		{
+			sam:   "r1\tx\tchr1\t4\t7\t4M\t*\t0\t0\tACGT\t*",
+			field: 1,
+			err:   `sam: field 1: failed to parse flags "x": .*`,
+		},
+		{
+			sam:   "r1\t0\tchr1\t4a\t7\t4M\t*\t0\t0\tACGT\t*",
+			field: 3,
+			err:   `sam: field 3: failed to parse position "4a": .*`,
+		},
+		{
+			sam:   "r1\t0\tchr1\t4\t7\t4M\t*\t0\t0\tACGT\t*\tNM:i:x",
+			field: 11,
+			err:   `sam: field 11: failed to parse auxiliary field "NM:i:x": .*`,
+		},
+	} {
+		var rec Record
+		err := rec.UnmarshalSAM(nil, []byte(test.sam))
+		c.Check(err, check.ErrorMatches, test.err)
+		var perr *RecordParseError
+		c.Assert(errors.As(err, &perr), check.Equals, true)
+		c.Check(perr.Field, check.Equals, test.field)
+
+		r, err := NewReader(strings.NewReader(test.sam + "\n"))
+		c.Assert(err, check.Equals, nil)
+		_, err = r.Read()
+		c.Assert(errors.As(err, &perr), check.Equals, true)
+		c.Check(perr.Field, check.Equals, test.field)
+	}
+}
+
+func (s *S) TestHeaderMarshalTextSorted(c *check.C) {
+	build := func(order []string) *Header {
+		ref, err := NewReference("chr1", "", "", 1000, nil, nil)
+		c.Assert(err, check.Equals, nil)
+		rg, err := NewReadGroup("rg1", "", "", "", "", "", "", "", "", "", time.Time{}, 0)
+		c.Assert(err, check.Equals, nil)
+		p := NewProgram("prog", "", "", "", "")
+		h, err := NewHeader(nil, []*Reference{ref})
+		c.Assert(err, check.Equals, nil)
+		h.Version = "1.6"
+		c.Assert(h.AddReadGroup(rg), check.Equals, nil)
+		c.Assert(h.AddProgram(p), check.Equals, nil)
+		for _, t := range order {
+			tag := NewTag(t)
+			c.Assert(h.Set(tag, "h"+t), check.Equals, nil)
+			c.Assert(ref.Set(tag, "r"+t), check.Equals, nil)
+			c.Assert(rg.Set(tag, "g"+t), check.Equals, nil)
+			c.Assert(p.Set(tag, "p"+t), check.Equals, nil)
+		}
+		return h
+	}
+	a := build([]string{"zz", "aa", "mm"})
+	b := build([]string{"mm", "zz", "aa"})
+
+	at, err := a.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	bt, err := b.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(at), check.Not(check.Equals), string(bt))
+
+	as, err := a.MarshalTextSorted()
+	c.Assert(err, check.Equals, nil)
+	bs, err := b.MarshalTextSorted()
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(as), check.Equals, string(bs))
+	c.Check(string(as), check.Equals, "@HD\tVN:1.6\tSO:unknown\taa:haa\tmm:hmm\tzz:hzz\n"+
+		"@SQ\tSN:chr1\tLN:1000\taa:raa\tmm:rmm\tzz:rzz\n"+
+		"@RG\tID:rg1\taa:gaa\tmm:gmm\tzz:gzz\n"+
+		"@PG\tID:prog\taa:paa\tmm:pmm\tzz:pzz\n")
+
+	// The insertion order of the Header is retained.
+	at2, err := a.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	c.Check(string(at2), check.Equals, string(at))
+}
+
+func (s *S) TestStripKeepAux(c *check.C) {
+	fields := func() AuxFields {
+		var a AuxFields
+		for _, t := range []string{"NM:i:1", "OA:Z:chr1,1,+,4M,60,0;", "XS:i:10", "MD:Z:4", "XS:i:11", "RG:Z:rg1"} {
+			aux, err := ParseAux([]byte(t))
+			c.Assert(err, check.Equals, nil)
+			a = append(a, aux)
+		}
+		return a
+	}
+	tags := func(a AuxFields) []string {
+		var t []string
+		for _, aux := range a {
+			t = append(t, aux.Tag().String())
+		}
+		return t
+	}
+
+	for _, test := range []struct {
+		tags  []Tag
+		strip []string
+		keep  []string
+	}{
+		{
+			tags:  nil,
+			strip: []string{"NM", "OA", "XS", "MD", "XS", "RG"},
+			keep:  nil,
+		},
+		{
+			tags:  []Tag{NewTag("OA"), NewTag("XS")},
+			strip: []string{"NM", "MD", "RG"},
+			keep:  []string{"OA", "XS", "XS"},
+		},
+		{
+			tags:  []Tag{NewTag("XS"), NewTag("XS"), NewTag("ZZ"), NewTag("NM")},
+			strip: []string{"OA", "MD", "RG"},
+			keep:  []string{"NM", "XS", "XS"},
+		},
+		{
+			tags:  []Tag{NewTag("NM"), NewTag("OA"), NewTag("XS"), NewTag("MD"), NewTag("RG")},
+			strip: nil,
+			keep:  []string{"NM", "OA", "XS", "MD", "XS", "RG"},
+		},
+	} {
+		r := &Record{AuxFields: fields()}
+		r.StripAux(test.tags...)
+		c.Check(tags(r.AuxFields), check.DeepEquals, test.strip, check.Commentf("strip %v", test.tags))
+
+		r = &Record{AuxFields: fields()}
+		r.KeepAux(test.tags...)
+		c.Check(tags(r.AuxFields), check.DeepEquals, test.keep, check.Commentf("keep %v", test.tags))
+	}
+}
+
+func (s *S) TestReadLen(c *check.C) {
+	for _, test := range []struct {
+		r    *Record
+		want int
+	}{
+		{r: &Record{}, want: 0},
+		{r: &Record{Seq: NewSeq([]byte("ACGTACGT"))}, want: 8},
+		{r: &Record{Cigar: Cigar{NewCigarOp(CigarSoftClipped, 2), NewCigarOp(CigarMatch, 5), NewCigarOp(CigarDeletion, 3), NewCigarOp(CigarInsertion, 1), NewCigarOp(CigarHardClipped, 4)}}, want: 8},
+		{r: &Record{Qual: []byte{30, 30, 30}}, want: 3},
+		{r: &Record{Seq: NewSeq([]byte("ACGT")), Cigar: Cigar{NewCigarOp(CigarMatch, 6)}}, want: 4},
+		{r: &Record{Cigar: Cigar{NewCigarOp(CigarMatch, 6)}, Qual: []byte{30, 30, 30}}, want: 6},
+	} {
+		c.Check(test.r.ReadLen(), check.Equals, test.want)
+	}
+}
+
+func (s *S) TestFlagsPredicates(c *check.C) {
+	f := Paired | ProperPair | MateReverse | Read1 | Duplicate
+	for _, test := range []struct {
+		name string
+		fn   func(Flags) bool
+		want bool
+	}{
+		{name: "IsPaired", fn: Flags.IsPaired, want: true},
+		{name: "IsProperPair", fn: Flags.IsProperPair, want: true},
+		{name: "IsUnmapped", fn: Flags.IsUnmapped, want: false},
+		{name: "IsMateUnmapped", fn: Flags.IsMateUnmapped, want: false},
+		{name: "IsReverse", fn: Flags.IsReverse, want: false},
+		{name: "IsMateReverse", fn: Flags.IsMateReverse, want: true},
+		{name: "IsRead1", fn: Flags.IsRead1, want: true},
+		{name: "IsRead2", fn: Flags.IsRead2, want: false},
+		{name: "IsSecondary", fn: Flags.IsSecondary, want: false},
+		{name: "IsQCFail", fn: Flags.IsQCFail, want: false},
+		{name: "IsDuplicate", fn: Flags.IsDuplicate, want: true},
+		{name: "IsSupplementary", fn: Flags.IsSupplementary, want: false},
+	} {
+		c.Check(test.fn(f), check.Equals, test.want, check.Commentf("%s(%v)", test.name, f))
+		c.Check(test.fn(^f), check.Equals, !test.want, check.Commentf("%s(%v)", test.name, ^f))
+	}
+}
+
+func (s *S) TestGroupOrderRoundTrip(c *check.C) {
+	for _, test := range []struct {
+		text string
+		want GroupOrder
+		tag  string
+	}{
+		{text: "@HD\tVN:1.6\tSO:coordinate\n", want: GroupUnspecified, tag: ""},
+		{text: "@HD\tVN:1.6\tSO:coordinate\tGO:none\n", want: GroupNone, tag: "none"},
+		{text: "@HD\tVN:1.6\tSO:coordinate\tGO:query\n", want: GroupQuery, tag: "query"},
+	} {
+		h, err := NewHeader([]byte(test.text), nil)
+		c.Assert(err, check.Equals, nil)
+		c.Check(h.GroupOrder, check.Equals, test.want)
+		c.Check(h.Get(NewTag("GO")), check.Equals, test.tag)
+		var tags []string
+		h.Tags(func(t Tag, v string) {
+			if t == NewTag("GO") {
+				tags = append(tags, v)
+			}
+		})
+		if test.tag == "" {
+			c.Check(tags, check.IsNil)
+		} else {
+			c.Check(tags, check.DeepEquals, []string{test.tag})
+		}
+
+		text, err := h.MarshalText()
+		c.Assert(err, check.Equals, nil)
+		c.Check(string(text), check.Equals, test.text)
+
+		b, err := h.MarshalBinary()
+		c.Assert(err, check.Equals, nil)
+		var got Header
+		c.Assert(got.UnmarshalBinary(b), check.Equals, nil)
+		c.Check(got.GroupOrder, check.Equals, test.want)
+	}
+}
+
+func (s *S) TestOneBasedPositions(c *check.C) {
+	const text = "r1\t0\tchr1\t100\t60\t2S5M2D3M\t*\t0\t0\tACGTACGTAC\t*"
+	var r Record
+	c.Assert(r.UnmarshalSAM(nil, []byte(text)), check.Equals, nil)
+	c.Check(r.Pos, check.Equals, 99)
+	c.Check(r.Pos1(), check.Equals, 100)
+	c.Check(r.Pos1(), check.Equals, r.Start()+1)
+	c.Check(r.End(), check.Equals, 109)
+	c.Check(r.End1(), check.Equals, 109)
+	c.Check(r.End1()-r.Pos1()+1, check.Equals, r.Len())
+
+	b, err := r.MarshalText()
+	c.Assert(err, check.Equals, nil)
+	c.Check(bytes.Split(b, []byte{'\t'})[3], check.DeepEquals, []byte("100"))
+
+	u, err := NewUnmappedRecord("r2", []byte("ACGT"), nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(u.Pos1(), check.Equals, 0)
+}
+
+func (s *S) TestNewReferenceLength(c *check.C) {
+	// tooLong exceeds the int32 range of BAM l_ref.
+	// It is not constant to allow compilation with
+	// 32-bit ints.
+	tooLong := int64(maxInt32) + 1
+	for _, test := range []struct {
+		length int
+		err    error
+	}{
+		{length: 1},
+		{length: maxInt32},
+		{length: 0, err: errBadLen},
+		{length: -1, err: errBadLen},
+		{length: int(tooLong), err: errBadLen},
+		{length: int(tooLong << 8), err: errBadLen},
+	} {
+		ref, err := NewReference("ref", "", "", test.length, nil, nil)
+		c.Check(err, check.Equals, test.err, check.Commentf("length %d", test.length))
+		if err == nil {
+			c.Check(ref.Len(), check.Equals, test.length)
+		}
+	}
+
+	ref, err := NewReference("ref", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	c.Check(ref.SetLen(int(tooLong)), check.Equals, errBadLen)
+	c.Check(ref.Len(), check.Equals, 1000)
+}
+
+func (s *S) TestWriterQualityMode(c *check.C) {
+	ref, err := NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	h, err := NewHeader(nil, []*Reference{ref})
+	c.Assert(err, check.Equals, nil)
+	cig := Cigar{NewCigarOp(CigarMatch, 4)}
+	missing, err := NewRecord("missing", ref, nil, 9, -1, 0, 60, cig, []byte("ACGT"), []byte{0xff, 0xff, 0xff, 0xff}, nil)
+	c.Assert(err, check.Equals, nil)
+	present, err := NewRecord("present", ref, nil, 9, -1, 0, 60, cig, []byte("ACGT"), []byte{0, 10, 20, 30}, nil)
+	c.Assert(err, check.Equals, nil)
+	absent, err := NewRecord("absent", ref, nil, 9, -1, 0, 60, cig, []byte("ACGT"), nil, nil)
+	c.Assert(err, check.Equals, nil)
+
+	for _, test := range []struct {
+		mode QualityMode
+		want []string
+	}{
+		{mode: QualityAuto, want: []string{"*", "!+5?", "*"}},
+		{mode: QualityAlways, want: []string{"!!!!", "!+5?", "*"}},
+		{mode: QualityOmit, want: []string{"*", "*", "*"}},
+	} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, h, FlagDecimal)
+		c.Assert(err, check.Equals, nil)
+		w.QualityMode = test.mode
+		buf.Reset()
+		for _, r := range []*Record{missing, present, absent} {
+			c.Assert(w.Write(r), check.Equals, nil)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		c.Assert(lines, check.HasLen, len(test.want))
+		for i, l := range lines {
+			c.Check(strings.Split(l, "\t")[10], check.Equals, test.want[i], check.Commentf("mode %d record %d", test.mode, i))
+		}
+	}
+
+	w, err := NewWriter(io.Discard, h, FlagDecimal)
+	c.Assert(err, check.Equals, nil)
+	w.QualityMode = QualityOmit + 1
+	c.Check(w.Write(present), check.ErrorMatches, "sam: quality mode out of range")
+}
+
+func (s *S) TestOriginalAlignment(c *check.C) {
+	const value = "chr1,1001,+,10S90M,60,2;chr2,501,-,50M1I49M,0,;"
+	a, err := ParseAux([]byte("OA:Z:" + value))
+	c.Assert(err, check.Equals, nil)
+	alns, err := ParseOA(a)
+	c.Assert(err, check.Equals, nil)
+	c.Check(alns, check.DeepEquals, []OriginalAlignment{
+		{
+			Ref: "chr1", Pos: 1000, Strand: 1, MapQ: 60, NM: 2,
+			Cigar: Cigar{NewCigarOp(CigarSoftClipped, 10), NewCigarOp(CigarMatch, 90)},
+		},
+		{
+			Ref: "chr2", Pos: 500, Strand: -1, MapQ: 0, NM: -1,
+			Cigar: Cigar{NewCigarOp(CigarMatch, 50), NewCigarOp(CigarInsertion, 1), NewCigarOp(CigarMatch, 49)},
+		},
+	})
+
+	got, err := FormatOA(alns)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got, check.DeepEquals, a)
+	c.Check(got.String(), check.Equals, "OA:Z:"+value)
+
+	// The final semicolon is optional when parsing.
+	alns, err = ParseOA(Aux("OAZ" + strings.TrimSuffix(value, ";")))
+	c.Assert(err, check.Equals, nil)
+	c.Check(alns, check.HasLen, 2)
+
+	for _, bad := range []Aux{
+		Aux("OAZ"),
+		Aux("XAZchr1,1001,+,100M,60,2;"),
+		Aux("OAZchr1,1001,+,100M,60;"),
+		Aux("OAZchr1,1001,+,100M,60,2,0;"),
+		Aux("OAZ,1001,+,100M,60,2;"),
+		Aux("OAZchr1,x,+,100M,60,2;"),
+		Aux("OAZchr1,1001,.,100M,60,2;"),
+		Aux("OAZchr1,1001,+,100Q,60,2;"),
+		Aux("OAZchr1,1001,+,100M,256,2;"),
+		Aux("OAZchr1,1001,+,100M,60,-2;"),
+		{'O', 'A', 'i', 0, 0, 0, 0},
+	} {
+		_, err = ParseOA(bad)
+		c.Check(err, check.NotNil, check.Commentf("%q", []byte(bad)))
+	}
+
+	for _, bad := range [][]OriginalAlignment{
+		nil,
+		{{Ref: "", Strand: 1}},
+		{{Ref: "chr1;chr2", Strand: 1}},
+		{{Ref: "chr1", Strand: 0}},
+		{{Ref: "chr1", Pos: -2, Strand: 1}},
+		{{Ref: "chr1", Strand: 1, NM: -2}},
+	} {
+		_, err = FormatOA(bad)
+		c.Check(err, check.NotNil, check.Commentf("%+v", bad))
+	}
+}
+
+func (s *S) TestSupplementaryAlignment(c *check.C) {
+	sr, err := NewReader(bytes.NewReader(specExamples.data))
+	c.Assert(err, check.Equals, nil)
+	var got []SupplementaryAlignment
+	for {
+		r, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		a := r.AuxFields.Get(NewTag("SA"))
+		if a == nil {
+			continue
+		}
+		segs, err := ParseSA(a)
+		c.Assert(err, check.Equals, nil)
+		c.Assert(segs, check.HasLen, 1)
+		got = append(got, segs...)
+
+		// Each segment describes the other part of r003.
+		f, err := FormatSA(segs)
+		c.Assert(err, check.Equals, nil)
+		c.Check(f, check.DeepEquals, a)
+	}
+	c.Check(got, check.DeepEquals, []SupplementaryAlignment{
+		{
+			Ref: "ref", Pos: 28, Strand: -1, MapQ: 17, NM: 0,
+			Cigar: Cigar{NewCigarOp(CigarHardClipped, 6), NewCigarOp(CigarMatch, 5)},
+		},
+		{
+			Ref: "ref", Pos: 8, Strand: 1, MapQ: 30, NM: 1,
+			Cigar: Cigar{NewCigarOp(CigarSoftClipped, 5), NewCigarOp(CigarMatch, 6)},
+		},
+	})
+
+	segs, err := ParseSA(Aux("SAZchr1,100,+,50M50S,60,0;chr2,200,-,50S50M,3,2;"))
+	c.Assert(err, check.Equals, nil)
+	c.Check(segs, check.HasLen, 2)
+
+	for _, bad := range []Aux{
+		Aux("SAZ"),
+		Aux("SAZref,29,-,6H5M,17,0"),
+		Aux("SAZref,29,-,6H5M,17,;"),
+		Aux("SAZref,29,-,6H5M,17;"),
+		Aux("SAZref,29,-,6H5M,17,0;;"),
+		Aux("OAZref,29,-,6H5M,17,0;"),
+	} {
+		_, err = ParseSA(bad)
+		c.Check(err, check.NotNil, check.Commentf("%q", []byte(bad)))
+	}
+
+	_, err = FormatSA([]SupplementaryAlignment{{Ref: "ref", Strand: 1, NM: -1}})
+	c.Check(err, check.NotNil)
+}
+
+func (s *S) TestRecordEqual(c *check.C) {
+	const text = "r1\t99\tchr1\t100\t60\t5S10M\t=\t300\t210\tACGTACGTACGTACG\t*\tNM:i:1\tRG:Z:grp\tXB:B:s,1,2"
+	newRecord := func(h *Header) *Record {
+		var r Record
+		c.Assert(r.UnmarshalSAM(h, []byte(text)), check.Equals, nil)
+		return &r
+	}
+	newHeader := func() *Header {
+		h, err := NewHeader([]byte("@SQ\tSN:chr1\tLN:1000\n"), nil)
+		c.Assert(err, check.Equals, nil)
+		return h
+	}
+
+	a := newRecord(newHeader())
+	b := newRecord(newHeader())
+	c.Assert(a.Ref != b.Ref, check.Equals, true)
+	c.Check(a.Equal(b), check.Equals, true)
+	c.Check(a.Equal(a), check.Equals, true)
+	c.Check(a.Equal(nil), check.Equals, false)
+
+	// Differently backed and ordered aux fields with an
+	// integer of a different width.
+	nm, err := NewAux(NewTag("NM"), int32(1))
+	c.Assert(err, check.Equals, nil)
+	c.Assert(nm.Type(), check.Equals, byte('i'))
+	b.AuxFields = AuxFields{
+		append(Aux(nil), b.AuxFields[2]...),
+		append(Aux(nil), b.AuxFields[1]...),
+		nm,
+	}
+	b.Qual = bytes.Repeat([]byte{0xff}, b.Seq.Length)
+	b.Seq = Seq{Length: b.Seq.Length, Seq: append([]Doublet(nil), b.Seq.Seq...)}
+	b.Cigar = append(Cigar(nil), b.Cigar...)
+	c.Check(a.Equal(b), check.Equals, true)
+	c.Check(b.Equal(a), check.Equals, true)
+
+	for _, mutate := range []func(r *Record){
+		func(r *Record) { r.Name = "r2" },
+		func(r *Record) { r.Flags |= Duplicate },
+		func(r *Record) { r.Pos++ },
+		func(r *Record) { r.MapQ-- },
+		func(r *Record) { r.MatePos++ },
+		func(r *Record) { r.TempLen = -r.TempLen },
+		func(r *Record) { r.Ref = nil },
+		func(r *Record) { r.MateRef = nil },
+		func(r *Record) { r.Cigar = r.Cigar[1:] },
+		func(r *Record) { r.Seq = NewSeq([]byte("ACGTACGTACGTACC")) },
+		func(r *Record) { r.Qual = bytes.Repeat([]byte{30}, r.Seq.Length) },
+		func(r *Record) { r.AuxFields = r.AuxFields[1:] },
+		func(r *Record) { r.AuxFields[0], _ = NewAux(NewTag("NM"), 2) },
+		func(r *Record) { r.AuxFields[1], _ = NewAux(NewTag("RG"), "other") },
+		func(r *Record) { r.AuxFields[2], _ = NewAux(NewTag("XB"), []int16{1, 3}) },
+	} {
+		b := newRecord(newHeader())
+		mutate(b)
+		c.Check(a.Equal(b), check.Equals, false, check.Commentf("%v", b))
+	}
+}
+
+func (s *S) TestMarkDuplicate(c *check.C) {
+	r, err := NewUnmappedRecord("r1", []byte("ACGT"), nil)
+	c.Assert(err, check.Equals, nil)
+	nm, err := NewAux(NewTag("NM"), 0)
+	c.Assert(err, check.Equals, nil)
+	r.AuxFields = AuxFields{nm}
+
+	_, ok := r.DuplicateReason()
+	c.Check(ok, check.Equals, false)
+
+	r.MarkDuplicate("LB")
+	c.Check(r.Flags&Duplicate, check.Equals, Duplicate)
+	reason, ok := r.DuplicateReason()
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "LB")
+	c.Check(r.AuxFields.Get(NewTag("DT")).String(), check.Equals, "DT:Z:LB")
+
+	// Marking again replaces the reason.
+	r.MarkDuplicate("SQ")
+	reason, ok = r.DuplicateReason()
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "SQ")
+	c.Check(r.AuxFields, check.HasLen, 2)
+
+	// An empty reason removes the recorded reason.
+	r.MarkDuplicate("")
+	c.Check(r.Flags&Duplicate, check.Equals, Duplicate)
+	_, ok = r.DuplicateReason()
+	c.Check(ok, check.Equals, false)
+	c.Check(r.AuxFields, check.DeepEquals, AuxFields{nm})
+
+	// The reason is not reported if the flag is cleared.
+	r.MarkDuplicate("LB")
+	r.Flags &^= Duplicate
+	_, ok = r.DuplicateReason()
+	c.Check(ok, check.Equals, false)
+
+	// Reasons may be recorded under a user tag.
+	r.Flags |= Duplicate
+	xd := NewTag("XD")
+	r.MarkDuplicateTag(xd, "optical")
+	c.Check(r.AuxFields.Get(xd).String(), check.Equals, "XD:Z:optical")
+	reason, ok = r.DuplicateReasonTag(xd)
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "optical")
+	reason, ok = r.DuplicateReason()
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "LB")
+	reason, ok = r.DuplicateReasonTag(NewTag("DT"))
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "LB")
+
+	// An empty reason only removes the reason under the given tag.
+	r.MarkDuplicateTag(xd, "")
+	_, ok = r.DuplicateReasonTag(xd)
+	c.Check(ok, check.Equals, false)
+	reason, ok = r.DuplicateReason()
+	c.Check(ok, check.Equals, true)
+	c.Check(reason, check.Equals, "LB")
+}
+
+func (s *S) TestAlignmentStrings(c *check.C) {
+	sr, err := NewReader(bytes.NewReader(specExamples.data))
+	c.Assert(err, check.Equals, nil)
+	ref := []byte(specExamples.ref)
+	want := []struct{ ref, read string }{
+		{ref: "TTAGATAA--GATAGCTG", read: "TTAGATAAAGGATA-CTG"},               // r001/1 8M2I4M1D3M
+		{ref: "AGATAA*-GATA", read: "AGATAA*GGATA"},                           // r002 3S6M1P1I4M
+		{ref: "AGATAA", read: "AGCTAA"},                                       // r003 5S6M
+		{ref: "ATAGCTGTGCTAGTAGGCAGTCAGC", read: "ATAGCT--------------TCAGC"}, // r004 6M14N5M
+		{ref: "TAGGC", read: "TAGGC"},                                         // r003 6H5M
+		{ref: "CAGCGCCAT", read: "CAGCGGCAT"},                                 // r001/2 9M
+	}
+	for i := 0; ; i++ {
+		r, err := sr.Read()
+		if err == io.EOF {
+			c.Check(i, check.Equals, len(want))
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		refAln, readAln, err := r.AlignmentStrings(ref)
+		c.Assert(err, check.Equals, nil)
+		c.Check(string(refAln), check.Equals, want[i].ref, check.Commentf("%s %v", r.Name, r.Cigar))
+		c.Check(string(readAln), check.Equals, want[i].read, check.Commentf("%s %v", r.Name, r.Cigar))
+	}
+
+	r := &Record{
+		Name:  "r",
+		Ref:   &Reference{id: -1, name: "ref", lRef: int32(len(ref))},
+		Pos:   40,
+		Cigar: Cigar{NewCigarOp(CigarMatch, 10)},
+		Seq:   NewSeq([]byte("ACGTACGTAC")),
+	}
+	_, _, err = r.AlignmentStrings(ref)
+	c.Check(err, check.ErrorMatches, "sam: alignment end 50 beyond reference length 45")
+
+	r.Pos = 0
+	r.Cigar = Cigar{NewCigarOp(CigarMatch, 9)}
+	_, _, err = r.AlignmentStrings(ref)
+	c.Check(err, check.ErrorMatches, "sam: invalid cigar for sequence")
+
+	r, err = NewUnmappedRecord("r", []byte("ACGT"), nil)
+	c.Assert(err, check.Equals, nil)
+	_, _, err = r.AlignmentStrings(ref)
+	c.Check(err, check.ErrorMatches, "sam: record is not aligned")
+}
+
+func (s *S) TestEditDistance(c *check.C) {
+	sr, err := NewReader(bytes.NewReader(specExamples.data))
+	c.Assert(err, check.Equals, nil)
+	ref := []byte(specExamples.ref)
+	want := []int{
+		3, // r001/1 8M2I4M1D3M
+		1, // r002 3S6M1P1I4M
+		1, // r003 5S6M
+		0, // r004 6M14N5M
+		0, // r003 6H5M
+		1, // r001/2 9M
+	}
+	for i := 0; ; i++ {
+		r, err := sr.Read()
+		if err == io.EOF {
+			c.Check(i, check.Equals, len(want))
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		nm, err := r.EditDistance(ref)
+		c.Assert(err, check.Equals, nil)
+		c.Check(nm, check.Equals, want[i], check.Commentf("%s %v", r.Name, r.Cigar))
+	}
+
+	// '=' matches and ambiguity codes match themselves
+	// regardless of case, but N never matches.
+	r := &Record{
+		Name:  "r",
+		Ref:   &Reference{id: -1, name: "ref", lRef: 10},
+		Cigar: Cigar{NewCigarOp(CigarEqual, 10)},
+		Seq:   NewSeq([]byte("=CGTNRACGA")),
+	}
+	nm, err := r.EditDistance([]byte("ACGTNracgt"))
+	c.Assert(err, check.Equals, nil)
+	c.Check(nm, check.Equals, 2)
+
+	r.Pos = 1
+	_, err = r.EditDistance([]byte("ACGTNracgt"))
+	c.Check(err, check.ErrorMatches, "sam: alignment end 11 beyond reference length 10")
+
+	r, err = NewUnmappedRecord("r", []byte("ACGT"), nil)
+	c.Assert(err, check.Equals, nil)
+	_, err = r.EditDistance(ref)
+	c.Check(err, check.ErrorMatches, "sam: record is not aligned")
+}
+
+func (s *S) TestCigarEqualTo(c *check.C) {
+	for _, test := range []struct {
+		a, b string
+		want bool
+	}{
+		{a: "15M", b: "15M", want: true},
+		{a: "10M5M", b: "15M", want: true},
+		{a: "5M5M5M", b: "10M5M", want: true},
+		{a: "3S10M0I5M", b: "3S15M", want: true},
+		{a: "2S1S4M2I1I3M", b: "3S4M3I3M", want: true},
+		{a: "*", b: "0M", want: true},
+		{a: "*", b: "*", want: true},
+
+		{a: "15M", b: "14M", want: false},
+		{a: "15M", b: "15=", want: false},
+		{a: "10M5M", b: "10M5I", want: false},
+		{a: "5M1I5M", b: "10M1I", want: false},
+		{a: "3S15M", b: "15M", want: false},
+		{a: "*", b: "1M", want: false},
+	} {
+		a, err := ParseCigar([]byte(test.a))
+		c.Assert(err, check.Equals, nil)
+		b, err := ParseCigar([]byte(test.b))
+		c.Assert(err, check.Equals, nil)
+		c.Check(a.EqualTo(b), check.Equals, test.want, check.Commentf("%s %s", test.a, test.b))
+		c.Check(b.EqualTo(a), check.Equals, test.want, check.Commentf("%s %s", test.b, test.a))
+	}
+}
+
+func (s *S) TestBuildCigar(c *check.C) {
+	want := Cigar{
+		NewCigarOp(CigarSoftClipped, 5),
+		NewCigarOp(CigarMatch, 6),
+		NewCigarOp(CigarDeletion, 1<<28-1),
+	}
+	c.Check(NewCigar(want...), check.DeepEquals, want)
+	c.Check(NewCigar(), check.IsNil)
+
+	got, err := BuildCigar(
+		CigarPair{Op: CigarSoftClipped, Len: 5},
+		CigarPair{Op: CigarMatch, Len: 6},
+		CigarPair{Op: CigarDeletion, Len: 1<<28 - 1},
+	)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got, check.DeepEquals, want)
+	c.Check(got.String(), check.Equals, "5S6M268435455D")
+
+	for _, test := range []struct {
+		pair CigarPair
+		err  string
+	}{
+		{pair: CigarPair{Op: CigarMatch, Len: 1 << 28}, err: "sam: CIGAR operation length out of range at 1: 268435456"},
+		{pair: CigarPair{Op: CigarMatch, Len: 0}, err: "sam: CIGAR operation length out of range at 1: 0"},
+		{pair: CigarPair{Op: CigarMatch, Len: -1}, err: "sam: CIGAR operation length out of range at 1: -1"},
+		{pair: CigarPair{Op: lastCigar, Len: 1}, err: "sam: invalid CIGAR operation type at 1: 10"},
+	} {
+		got, err := BuildCigar(CigarPair{Op: CigarMatch, Len: 1}, test.pair)
+		c.Check(err, check.ErrorMatches, test.err)
+		c.Check(got, check.IsNil)
+	}
+}
+
+func (s *S) TestNewHeaderReferenceNames(c *check.C) {
+	var refs []*Reference
+	for _, name := range []string{"chr1", "chr2"} {
+		ref, err := NewReference(name, "", "", 1000, nil, nil)
+		c.Assert(err, check.Equals, nil)
+		refs = append(refs, ref)
+	}
+	h, err := NewHeader(nil, refs)
+	c.Assert(err, check.Equals, nil)
+
+	for i, ref := range refs {
+		got, ok := h.ReferenceByName(ref.Name())
+		c.Check(ok, check.Equals, true)
+		c.Check(got == refs[i], check.Equals, true)
+	}
+
+	var r Record
+	err = r.UnmarshalSAM(h, []byte("r0\t67\tchr1\t10\t30\t4M\tchr2\t100\t0\tACGT\t*"))
+	c.Assert(err, check.Equals, nil)
+	c.Check(r.Ref == refs[0], check.Equals, true)
+	c.Check(r.MateRef == refs[1], check.Equals, true)
+
+	// An equal reference is resolved to the one held.
+	clone, err := NewReference("chr2", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	got, err := h.AddReferenceClone(clone)
+	c.Assert(err, check.Equals, nil)
+	c.Check(got == refs[1], check.Equals, true)
+	c.Check(h.Refs(), check.HasLen, 2)
+
+	var dups []*Reference
+	for i := 0; i < 2; i++ {
+		ref, err := NewReference("chr1", "", "", 1000, nil, nil)
+		c.Assert(err, check.Equals, nil)
+		dups = append(dups, ref)
+	}
+	_, err = NewHeader(nil, dups)
+	c.Check(err, check.Equals, errDupReference)
+	for _, ref := range dups {
+		c.Check(ref.owner == nil, check.Equals, true)
+		c.Check(ref.ID(), check.Equals, -1)
+	}
+}
+
+func (s *S) TestAddReferenceClone(c *check.C) {
+	ref, err := NewReference("chr1", "assem", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+
+	var added []*Reference
+	for i := 0; i < 2; i++ {
+		h, err := NewHeader(nil, nil)
+		c.Assert(err, check.Equals, nil)
+		got, err := h.AddReferenceClone(ref)
+		c.Assert(err, check.Equals, nil)
+		c.Check(got != ref, check.Equals, true)
+		c.Check(got.ID(), check.Equals, 0)
+		c.Check(got.owner == h, check.Equals, true)
+		c.Check(got.Name(), check.Equals, "chr1")
+		c.Check(got.Len(), check.Equals, 1000)
+		c.Check(got.AssemblyID(), check.Equals, "assem")
+		c.Check(h.Refs(), check.HasLen, 1)
+		c.Check(h.Refs()[0] == got, check.Equals, true)
+		added = append(added, got)
+
+		// The original is untouched and so can be reused.
+		c.Check(ref.ID(), check.Equals, -1)
+		c.Check(ref.owner == nil, check.Equals, true)
+
+		// Adding an equal reference returns the held reference.
+		again, err := h.AddReferenceClone(ref)
+		c.Assert(err, check.Equals, nil)
+		c.Check(again == got, check.Equals, true)
+		c.Check(h.Refs(), check.HasLen, 1)
+	}
+	c.Check(added[0] != added[1], check.Equals, true)
+
+	h, err := NewHeader(nil, nil)
+	c.Assert(err, check.Equals, nil)
+	_, err = h.AddReferenceClone(&Reference{id: -1})
+	c.Check(err, check.Equals, errEmptyRefName)
+}
+
+func (s *S) TestNewReaderAuto(c *check.C) {
+	const sam = "@HD\tVN:1.0\tSO:coordinate\n" +
+		"@SQ\tSN:ref\tLN:45\n" +
+		"r001\t99\tref\t7\t30\t8M2I4M1D3M\t=\t37\t39\tTTAGATAAAGGATACTG\t*\n" +
+		"r002\t0\tref\t9\t30\t3S6M1P1I4M\t*\t0\t0\tAAAAGATAAGGATA\t*\n"
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(sam))
+	c.Assert(err, check.Equals, nil)
+	c.Assert(gw.Close(), check.Equals, nil)
+
+	var bg bytes.Buffer
+	bw := bgzf.NewWriter(&bg, 1)
+	_, err = bw.Write([]byte(sam))
+	c.Assert(err, check.Equals, nil)
+	c.Assert(bw.Close(), check.Equals, nil)
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "plain", data: []byte(sam)},
+		{name: "gzip", data: gz.Bytes()},
+		{name: "bgzf", data: bg.Bytes()},
+	} {
+		r, err := NewReaderAuto(bytes.NewReader(test.data))
+		c.Assert(err, check.Equals, nil, check.Commentf("%s", test.name))
+		c.Check(r.Header().Refs(), check.HasLen, 1, check.Commentf("%s", test.name))
+		var names []string
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			c.Assert(err, check.Equals, nil, check.Commentf("%s", test.name))
+			names = append(names, rec.Name)
+		}
+		c.Check(names, check.DeepEquals, []string{"r001", "r002"}, check.Commentf("%s", test.name))
+	}
+}
+
+func (s *S) TestReadGroupsBySample(c *check.C) {
+	h, err := NewHeader([]byte("@HD\tVN:1.6\n"+
+		"@RG\tID:rg1\tSM:alice\tLB:lib1\n"+
+		"@RG\tID:rg2\tSM:bob\tLB:lib2\n"+
+		"@RG\tID:rg3\tSM:alice\tLB:lib3\n"+
+		"@RG\tID:rg4\tLB:lib4\n"), nil)
+	c.Assert(err, check.Equals, nil)
+
+	names := func(rgs []*ReadGroup) []string {
+		var n []string
+		for _, rg := range rgs {
+			n = append(n, rg.Name())
+		}
+		return n
+	}
+	got := h.ReadGroupsBySample()
+	c.Check(got, check.HasLen, 3)
+	c.Check(names(got["alice"]), check.DeepEquals, []string{"rg1", "rg3"})
+	c.Check(names(got["bob"]), check.DeepEquals, []string{"rg2"})
+	c.Check(names(got[""]), check.DeepEquals, []string{"rg4"})
+
+	for _, test := range []struct {
+		rg     string
+		sample string
+		ok     bool
+	}{
+		{rg: "rg1", sample: "alice", ok: true},
+		{rg: "rg2", sample: "bob", ok: true},
+		{rg: "rg3", sample: "alice", ok: true},
+		{rg: "rg4", sample: "", ok: false},
+		{rg: "rg5", sample: "", ok: false},
+		{rg: "", sample: "", ok: false},
+	} {
+		var r Record
+		if test.rg != "" {
+			aux, err := NewAux(NewTag("RG"), test.rg)
+			c.Assert(err, check.Equals, nil)
+			r.AuxFields = AuxFields{aux}
+		}
+		sample, ok := r.Sample(h)
+		c.Check(sample, check.Equals, test.sample, check.Commentf("read group %q", test.rg))
+		c.Check(ok, check.Equals, test.ok, check.Commentf("read group %q", test.rg))
+	}
+}
+
+func (s *S) TestSplitAt(c *check.C) {
+	for _, test := range []struct {
+		pos    int
+		cigar  string
+		flags  Flags
+		refPos int
+
+		left, right string
+		ok          bool
+	}{
+		// Match.
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 105, left: "3S5M22S", right: "8S5M2I5M3D6M4S", ok: true},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 101, left: "3S1M26S", right: "4S9M2I5M3D6M4S", ok: true},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 123, left: "3S10M2I5M3D5M5S", right: "25S1M4S", ok: true},
+
+		// Insertion.
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 110, left: "3S10M17S", right: "15S5M3D6M4S", ok: true},
+
+		// Deletion.
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 115, left: "3S10M2I5M10S", right: "20S3D6M4S", ok: true},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 116, left: "3S10M2I5M1D10S", right: "20S2D6M4S", ok: true},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 118, left: "3S10M2I5M3D10S", right: "20S6M4S", ok: true},
+
+		// Hard clips are retained.
+		{pos: 0, cigar: "2H5M3H", refPos: 2, left: "2H2M3S3H", right: "2H2S3M3H", ok: true},
+
+		// Outside the alignment.
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 100, ok: false},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 99, ok: false},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", refPos: 124, ok: false},
+		{pos: 100, cigar: "3S10M2I5M3D6M4S", flags: Unmapped, refPos: 105, ok: false},
+	} {
+		cigar, err := ParseCigar([]byte(test.cigar))
+		c.Assert(err, check.Equals, nil)
+		r := &Record{Pos: test.pos, Cigar: cigar, Flags: test.flags}
+		left, right, ok := r.SplitAt(test.refPos)
+		c.Check(ok, check.Equals, test.ok, check.Commentf("%s at %d", test.cigar, test.refPos))
+		if !ok {
+			c.Check(left, check.IsNil)
+			c.Check(right, check.IsNil)
+			continue
+		}
+		c.Check(left.String(), check.Equals, test.left, check.Commentf("%s at %d", test.cigar, test.refPos))
+		c.Check(right.String(), check.Equals, test.right, check.Commentf("%s at %d", test.cigar, test.refPos))
+
+		_, wantRead := cigar.Lengths()
+		leftRef, leftRead := left.Lengths()
+		rightRef, rightRead := right.Lengths()
+		c.Check(leftRef, check.Equals, test.refPos-test.pos)
+		c.Check(rightRef, check.Equals, r.End()-test.refPos)
+		c.Check(leftRead, check.Equals, wantRead)
+		c.Check(rightRead, check.Equals, wantRead)
+	}
+}
+
+func (s *S) TestProcessedBy(c *check.C) {
+	h, err := NewHeader([]byte("@HD\tVN:1.6\tSO:coordinate\n"+
+		"@PG\tID:bwa\tPN:bwa\tVN:0.7.17\tCL:bwa mem ref.fa r1.fq r2.fq\n"+
+		"@PG\tID:samtools\tPN:samtools\tPP:bwa\tVN:1.9\n"+
+		"@PG\tID:MarkDuplicates\tPN:MarkDuplicates\tPP:samtools\tVN:2.18\n"+
+		"@PG\tID:samtools.1\tPN:samtools\tPP:MarkDuplicates\tVN:1.10\n"+
+		"@PG\tID:other\tPN:other\n"), nil)
+	c.Assert(err, check.Equals, nil)
+
+	for _, test := range []struct {
+		name string
+		uid  string
+		ok   bool
+	}{
+		{name: "bwa", uid: "bwa", ok: true},
+		{name: "MarkDuplicates", uid: "MarkDuplicates", ok: true},
+		{name: "samtools", uid: "samtools.1", ok: true},
+		{name: "other", uid: "other", ok: true},
+		{name: "picard", ok: false},
+		{name: "", ok: false},
+	} {
+		p, ok := h.ProcessedBy(test.name)
+		c.Check(ok, check.Equals, test.ok, check.Commentf("%q", test.name))
+		c.Check(p.UID(), check.Equals, test.uid, check.Commentf("%q", test.name))
+	}
+
+	// Cyclic chains do not prevent a result.
+	h, err = NewHeader([]byte("@HD\tVN:1.6\n"+
+		"@PG\tID:a\tPN:tool\tPP:b\n"+
+		"@PG\tID:b\tPN:tool\tPP:a\n"), nil)
+	c.Assert(err, check.Equals, nil)
+	p, ok := h.ProcessedBy("tool")
+	c.Check(ok, check.Equals, true)
+	c.Check(p.UID(), check.Equals, "b")
+}
+
+func (s *S) TestEncodeDecodeQual(c *check.C) {
+	for _, test := range []struct {
+		qual  []byte
+		ascii string
+		back  []byte
+	}{
+		{qual: []byte{0, 1, 30, 40, 93}, ascii: "!\"?I~", back: []byte{0, 1, 30, 40, 93}},
+		{qual: []byte{0xff, 0xff, 0xff}, ascii: "*", back: nil},
+		{qual: []byte{}, ascii: "*", back: nil},
+		{qual: nil, ascii: "*", back: nil},
+		// '*' is a valid single quality of 9 when not the whole field.
+		{qual: []byte{9, 9}, ascii: "**", back: []byte{9, 9}},
+	} {
+		got := EncodeQual(test.qual)
+		c.Check(string(got), check.Equals, test.ascii, check.Commentf("%v", test.qual))
+		c.Check(DecodeQual(got), check.DeepEquals, test.back, check.Commentf("%q", got))
+	}
+
+	// Encoding agrees with SAM text formatting.
+	r := &Record{Name: "r", Pos: -1, MatePos: -1, Flags: Unmapped, Seq: NewSeq([]byte("ACGT")), Qual: []byte{10, 20, 30, 40}}
+	b, err := r.MarshalSAM(0)
+	c.Assert(err, check.Equals, nil)
+	c.Check(bytes.HasSuffix(b, append([]byte{'\t'}, EncodeQual(r.Qual)...)), check.Equals, true)
+}
+
+func (s *S) TestRepairMates(c *check.C) {
+	sr, err := NewReader(bytes.NewReader(specExamples.data))
+	c.Assert(err, check.Equals, nil)
+	var recs []*Record
+	for {
+		r, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.Equals, nil)
+		recs = append(recs, r)
+	}
+	// r001/1 and r001/2 are a consistent pair.
+	pair := func() (r1, r2 *Record) {
+		a, b := *recs[0], *recs[5]
+		return &a, &b
+	}
+	r1, r2 := pair()
+	c.Check(RepairMates(r1, r2), check.IsNil)
+	c.Check(r1.TempLen, check.Equals, 39)
+	c.Check(r2.TempLen, check.Equals, -39)
+
+	other, err := NewReference("other", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+
+	for _, test := range []struct {
+		name    string
+		corrupt func(r1, r2 *Record)
+		want    []string
+		check   func(r1, r2 *Record)
+	}{
+		{
+			name:    "mate strand",
+			corrupt: func(r1, r2 *Record) { r1.Flags &^= MateReverse },
+			want:    []string{"r1.Flags.MateReverse"},
+		},
+		{
+			name: "mate position and template length",
+			corrupt: func(r1, r2 *Record) {
+				r1.MatePos = 40
+				r2.TempLen = 39
+			},
+			want: []string{"r1.MatePos", "r2.TempLen"},
+		},
+		{
+			name: "unpaired flags",
+			corrupt: func(r1, r2 *Record) {
+				r1.Flags &^= Paired
+				r2.Flags &^= Paired | MateReverse
+				r2.Flags |= MateUnmapped | MateReverse
+			},
+			want: []string{"r1.Flags.Paired", "r2.Flags.Paired", "r2.Flags.MateUnmapped", "r2.Flags.MateReverse"},
+		},
+		{
+			name: "unmapped mate",
+			corrupt: func(r1, r2 *Record) {
+				r2.Flags |= Unmapped
+			},
+			want: []string{"r1.Flags.MateUnmapped", "r1.TempLen", "r2.TempLen"},
+			check: func(r1, r2 *Record) {
+				c.Check(r1.TempLen, check.Equals, 0)
+				c.Check(r2.TempLen, check.Equals, 0)
+			},
+		},
+		{
+			name: "different references",
+			corrupt: func(r1, r2 *Record) {
+				r2.Ref = other
+			},
+			want: []string{"r1.MateRef", "r1.TempLen", "r2.TempLen"},
+			check: func(r1, r2 *Record) {
+				c.Check(r1.MateRef == other, check.Equals, true)
+			},
+		},
+		{
+			name: "swapped order",
+			corrupt: func(r1, r2 *Record) {
+				r1.Pos, r2.Pos = 36, 6
+			},
+			want: []string{"r1.MatePos", "r2.MatePos", "r1.TempLen", "r2.TempLen"},
+			check: func(r1, r2 *Record) {
+				c.Check(r1.TempLen, check.Equals, -(r1.End() - 6))
+				c.Check(r2.TempLen, check.Equals, r1.End()-6)
+			},
+		},
+	} {
+		r1, r2 := pair()
+		test.corrupt(r1, r2)
+		c.Check(RepairMates(r1, r2), check.DeepEquals, test.want, check.Commentf("%s", test.name))
+		if test.check != nil {
+			test.check(r1, r2)
+		}
+		// Repaired records are consistent.
+		c.Check(RepairMates(r1, r2), check.IsNil, check.Commentf("%s", test.name))
+	}
+}
+
+func (s *S) TestTemplateLength(c *check.C) {
+	chr1, err := NewReference("chr1", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	chr2, err := NewReference("chr2", "", "", 1000, nil, nil)
+	c.Assert(err, check.Equals, nil)
+	rec := func(ref *Reference, pos int, flags Flags, co ...CigarOp) *Record {
+		return &Record{Name: "r", Ref: ref, Pos: pos, Flags: flags, Cigar: co}
+	}
+	m := func(n int) CigarOp { return NewCigarOp(CigarMatch, n) }
+
+	for _, test := range []struct {
+		name   string
+		r1, r2 *Record
+		want   int
+		ok     bool
+	}{
+		{
+			name: "FR pair",
+			r1:   rec(chr1, 100, Paired, m(50)),
+			r2:   rec(chr1, 250, Paired|Reverse, m(50)),
+			want: 200, ok: true,
+		},
+		{
+			name: "RF order",
+			r1:   rec(chr1, 250, Paired|Reverse, m(50)),
+			r2:   rec(chr1, 100, Paired, m(50)),
+			want: -200, ok: true,
+		},
+		{
+			name: "overlapping pair",
+			r1:   rec(chr1, 100, Paired, m(50)),
+			r2:   rec(chr1, 120, Paired|Reverse, m(50)),
+			want: 70, ok: true,
+		},
+		{
+			name: "contained pair",
+			r1:   rec(chr1, 100, Paired, m(50)),
+			r2:   rec(chr1, 110, Paired|Reverse, m(10)),
+			want: 50, ok: true,
+		},
+		{
+			name: "same start",
+			r1:   rec(chr1, 100, Paired, m(30)),
+			r2:   rec(chr1, 100, Paired|Reverse, m(50)),
+			want: 50, ok: true,
+		},
+		{
+			name: "soft clipped",
+			r1:   rec(chr1, 100, Paired, NewCigarOp(CigarSoftClipped, 5), m(45)),
+			r2:   rec(chr1, 200, Paired|Reverse, m(45), NewCigarOp(CigarSoftClipped, 5)),
+			want: 145, ok: true,
+		},
+		{
+			name: "different references",
+			r1:   rec(chr1, 100, Paired, m(50)),
+			r2:   rec(chr2, 250, Paired|Reverse, m(50)),
+		},
+		{
+			name: "unmapped mate",
+			r1:   rec(chr1, 100, Paired|MateUnmapped, m(50)),
+			r2:   rec(chr1, 100, Paired|Unmapped),
+		},
+		{
+			name: "no reference",
+			r1:   rec(nil, -1, Paired|Unmapped),
+			r2:   rec(nil, -1, Paired|Unmapped),
+		},
+	} {
+		got, ok := TemplateLength(test.r1, test.r2)
+		c.Check(got, check.Equals, test.want, check.Commentf("test %q", test.name))
+		c.Check(ok, check.Equals, test.ok, check.Commentf("test %q", test.name))
+		if ok {
+			got, _ = TemplateLength(test.r2, test.r1)
+			if test.r1.Pos != test.r2.Pos {
+				c.Check(got, check.Equals, -test.want, check.Commentf("test %q reversed", test.name))
+			}
+		}
+	}
+}